@@ -10,11 +10,20 @@ import (
 
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/handoff"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/server"
 )
 
 func main() {
+	// Deferred first so it runs after the other deferred cleanups
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	log.Println("Starting SabaiPics FTP Server...")
 
@@ -49,9 +58,9 @@ func main() {
 		log.Fatalf("Failed to create FTP server: %v", err)
 	}
 
-	// Set up signal handling for graceful shutdown
+	// Set up signal handling for graceful shutdown (SIGINT/SIGTERM) and graceful restart (SIGUSR2)
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, handoff.RestartSignals...)...)
 
 	// Start server in a goroutine
 	errChan := make(chan error, 1)
@@ -59,26 +68,82 @@ func main() {
 		errChan <- ftpServer.Start()
 	}()
 
-	// Wait for either a shutdown signal or server error
-	select {
-	case sig := <-sigChan:
-		log.Printf("Received signal: %v - initiating graceful shutdown", sig)
-
-		// Create shutdown context with timeout
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// In-flight uploads get until the deadline to finish, then a moment to reach the spool
+	shutdown := func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second+server.SpoolGrace)
 		defer cancel()
 
 		// Attempt graceful shutdown
 		if err := ftpServer.Shutdown(shutdownCtx); err != nil {
 			log.Printf("Error during shutdown: %v", err)
+			exitCode = 1
 		}
+	}
 
-	case err := <-errChan:
-		if err != nil {
-			log.Printf("Server error: %v", err)
-			os.Exit(1)
+	// Wait for either a shutdown signal, a restart signal or server error
+	for {
+		select {
+		case sig := <-sigChan:
+			if !handoff.IsRestartSignal(sig) {
+				log.Printf("Received signal: %v - initiating graceful shutdown", sig)
+				shutdown()
+				log.Println("FTP server shut down successfully")
+				return
+			}
+
+			log.Printf("Received signal: %v - handing listeners to a new process", sig)
+			child, err := ftpServer.HandOff()
+			if err != nil {
+				log.Printf("Graceful restart failed, still serving: %v", err)
+				continue
+			}
+
+			// Connected sessions finish here; the new process serves everything else.
+			// SIGTERM/SIGINT stop waiting and drain what is left the usual way.
+			sessionsCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.HandoffTimeout)*time.Second)
+			sessionsDone := make(chan error, 1)
+			go func() { sessionsDone <- ftpServer.WaitForSessions(sessionsCtx) }()
+			var stopSig os.Signal
+		waitSessions:
+			for {
+				select {
+				case err := <-sessionsDone:
+					if err != nil {
+						log.Printf("Sessions still connected after %ds - shutting them down", cfg.HandoffTimeout)
+					}
+					break waitSessions
+				case sig := <-sigChan:
+					if handoff.IsRestartSignal(sig) {
+						log.Printf("Received signal: %v - restart already handed off, ignoring", sig)
+						continue
+					}
+					log.Printf("Received signal: %v - shutting down the remaining sessions", sig)
+					stopSig = sig
+					break waitSessions
+				}
+			}
+			cancel()
+			shutdown()
+			log.Printf("Old process finished, pid %d is serving", child.Pid)
+
+			// As a container's PID 1 our exit would stop the container (and the new process with it)
+			if os.Getpid() == 1 {
+				signal.Stop(sigChan)
+				// A stop signal meant for the container goes on to the new process
+				if stopSig != nil {
+					child.Signal(stopSig)
+				}
+				handoff.Supervise(child)
+			}
+			return
+
+		case err := <-errChan:
+			if err != nil {
+				log.Printf("Server error: %v", err)
+				os.Exit(1)
+			}
+			// The explicit listener also stops when handed off; keep waiting for our sessions then
+			errChan = nil
 		}
 	}
-
-	log.Println("FTP server shut down successfully")
 }
@@ -0,0 +1,223 @@
+package acmecert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Supported ACME challenge types
+const (
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeHTTP01    = "http-01"
+)
+
+// Manager obtains and renews the FTP server certificate from an ACME CA
+// Certificates are cached on disk so restarts don't re-issue.
+type Manager struct {
+	autocert      *autocert.Manager
+	hostname      string
+	challenge     string
+	challengeAddr string
+
+	listener   net.Listener // TLS-ALPN-01 challenge listener
+	httpServer *http.Server // HTTP-01 challenge server
+
+	expiryMu   sync.Mutex
+	lastExpiry time.Time
+
+	wg sync.WaitGroup
+}
+
+// New creates an ACME certificate manager from config
+func New(cfg *config.Config) (*Manager, error) {
+	if cfg.ACMEHostname == "" {
+		return nil, errors.New("ACME_HOSTNAME is required when ACME_ENABLED=true")
+	}
+
+	challenge := cfg.ACMEChallenge
+	challengeAddr := cfg.ACMEChallengeListenAddress
+	switch challenge {
+	case ChallengeTLSALPN01:
+		if challengeAddr == "" {
+			challengeAddr = "0.0.0.0:443"
+		}
+	case ChallengeHTTP01:
+		if challengeAddr == "" {
+			challengeAddr = "0.0.0.0:80"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ACME_CHALLENGE %q (use %s or %s)", challenge, ChallengeTLSALPN01, ChallengeHTTP01)
+	}
+
+	httpClient := http.DefaultClient
+	if cfg.ACMEDirectoryCAPath != "" {
+		// Private ACME CAs (and Pebble in tests) serve the directory with their own root
+		caPEM, err := os.ReadFile(cfg.ACMEDirectoryCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME directory CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in ACME directory CA %s", cfg.ACMEDirectoryCAPath)
+		}
+		httpClient = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+
+	m := &Manager{
+		hostname:      cfg.ACMEHostname,
+		challenge:     challenge,
+		challengeAddr: challengeAddr,
+		autocert: &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEHostname),
+			Email:      cfg.ACMEEmail,
+			Client: &acme.Client{
+				DirectoryURL: cfg.ACMEDirectoryURL,
+				HTTPClient:   httpClient,
+			},
+		},
+	}
+
+	return m, nil
+}
+
+// GetCertificate returns the certificate for the configured hostname (for tls.Config.GetCertificate)
+// Cameras usually connect by IP and send no SNI, so the configured hostname is assumed.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName == "" {
+		withName := *hello
+		withName.ServerName = m.hostname
+		hello = &withName
+	}
+
+	cert, err := m.autocert.GetCertificate(hello)
+	if err != nil {
+		return nil, err
+	}
+	m.recordExpiry(cert)
+	return cert, nil
+}
+
+// Start serves the ACME challenge and obtains the certificate in the background
+// so the first camera handshake doesn't wait for issuance
+func (m *Manager) Start() error {
+	switch m.challenge {
+	case ChallengeTLSALPN01:
+		listener, err := net.Listen("tcp", m.challengeAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for ACME TLS-ALPN-01 challenges: %w", err)
+		}
+		m.listener = listener
+		m.wg.Add(1)
+		go m.serveTLSALPN()
+
+	case ChallengeHTTP01:
+		listener, err := net.Listen("tcp", m.challengeAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for ACME HTTP-01 challenges: %w", err)
+		}
+		m.httpServer = &http.Server{
+			Handler:           m.autocert.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			if err := m.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("acme_challenge_server_error challenge=%s error=%v", m.challenge, err)
+			}
+		}()
+	}
+
+	log.Printf("acme_challenge_listening challenge=%s addr=%s hostname=%s", m.challenge, m.challengeAddr, m.hostname)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: m.hostname}); err != nil {
+			log.Printf("acme_certificate_failed hostname=%s error=%v", m.hostname, err)
+			return
+		}
+		log.Printf("acme_certificate_ready hostname=%s", m.hostname)
+	}()
+
+	return nil
+}
+
+// Stop closes the challenge listener
+func (m *Manager) Stop() {
+	if m.listener != nil {
+		m.listener.Close()
+	}
+	if m.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		m.httpServer.Shutdown(ctx)
+	}
+	m.wg.Wait()
+}
+
+// serveTLSALPN answers TLS-ALPN-01 validation handshakes - only the handshake matters
+func (m *Manager) serveTLSALPN() {
+	defer m.wg.Done()
+
+	tlsConfig := &tls.Config{
+		GetCertificate: m.autocert.GetCertificate,
+		NextProtos:     []string{acme.ALPNProto},
+	}
+
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("acme_challenge_accept_error error=%v", err)
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				log.Printf("acme_challenge_handshake_failed remote=%s error=%v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// recordExpiry exports the served certificate's expiry once per issued certificate
+func (m *Manager) recordExpiry(cert *tls.Certificate) {
+	if cert.Leaf == nil {
+		return
+	}
+	m.expiryMu.Lock()
+	defer m.expiryMu.Unlock()
+	if cert.Leaf.NotAfter.Equal(m.lastExpiry) {
+		return
+	}
+	m.lastExpiry = cert.Leaf.NotAfter
+	observability.SetCertExpiry(cert.Leaf.NotAfter)
+	log.Printf("acme_certificate_loaded hostname=%s not_after=%s", m.hostname, cert.Leaf.NotAfter.Format(time.RFC3339))
+}
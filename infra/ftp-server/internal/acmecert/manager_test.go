@@ -0,0 +1,18 @@
+package acmecert
+
+import (
+	"testing"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+)
+
+const testHostname = "ftp.sabaipics.test"
+
+func TestNew_RejectsInvalidConfig(t *testing.T) {
+	if _, err := New(&config.Config{ACMEChallenge: ChallengeTLSALPN01}); err == nil {
+		t.Error("Expected error without ACME_HOSTNAME")
+	}
+	if _, err := New(&config.Config{ACMEHostname: testHostname, ACMEChallenge: "dns-01"}); err == nil {
+		t.Error("Expected error for unsupported challenge")
+	}
+}
@@ -0,0 +1,4 @@
+// Package pebbletest runs acmecert against an in-process Pebble CA
+// It is a separate module so Pebble and challtestsrv stay out of the server's go.mod;
+// run it with `go test ./...` from this directory.
+package pebbletest
@@ -0,0 +1,158 @@
+package pebbletest
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/challtestsrv"
+	"github.com/letsencrypt/pebble/v2/ca"
+	"github.com/letsencrypt/pebble/v2/db"
+	"github.com/letsencrypt/pebble/v2/va"
+	"github.com/letsencrypt/pebble/v2/wfe"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/acmecert"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+)
+
+const testHostname = "ftp.sabaipics.test"
+
+// freePort reserves and releases a local TCP port
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startPebble runs an in-process Pebble CA that validates TLS-ALPN-01 on challengePort
+// Returns the directory URL and the path of the CA certificate serving it.
+func startPebble(t *testing.T, challengePort int) (string, string) {
+	t.Helper()
+	t.Setenv("PEBBLE_VA_NOSLEEP", "1")
+
+	logger := log.New(io.Discard, "", 0)
+
+	// Resolve every name to loopback so the VA dials our challenge listener
+	dnsAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(freePort(t)))
+	dnsSrv, err := challtestsrv.New(challtestsrv.Config{
+		DNSAddrs: []string{dnsAddr},
+		Log:      logger,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create DNS server: %v", err)
+	}
+	dnsSrv.SetDefaultDNSIPv4("127.0.0.1")
+	dnsSrv.SetDefaultDNSIPv6("")
+	go dnsSrv.Run()
+	t.Cleanup(dnsSrv.Shutdown)
+
+	store := db.NewMemoryStore()
+	authority := ca.New(logger, store, "", "ecdsa", 0, 1, map[string]ca.Profile{
+		"default": {Description: "test"},
+	})
+	validator := va.New(logger, 0, challengePort, false, dnsAddr, store)
+	frontend := wfe.New(logger, store, validator, authority, nil, false, false, 0, 0)
+
+	srv := httptest.NewTLSServer(withFinalizeLocation(frontend.Handler()))
+	t.Cleanup(srv.Close)
+
+	caPath := filepath.Join(t.TempDir(), "pebble-ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caPath, caPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write Pebble CA: %v", err)
+	}
+
+	return srv.URL + "/dir", caPath
+}
+
+// withFinalizeLocation adds the order URL to Pebble's finalize responses
+// Let's Encrypt sends it and the acme client polls it while the order is processing.
+func withFinalizeLocation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := strings.CutPrefix(r.URL.Path, "/finalize-order/"); ok {
+			w.Header().Set("Location", "https://"+r.Host+"/my-order/"+id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestManager_ObtainsCertificateFromPebble(t *testing.T) {
+	challengePort := freePort(t)
+	directoryURL, caPath := startPebble(t, challengePort)
+	cacheDir := t.TempDir()
+
+	cfg := &config.Config{
+		ACMEEnabled:                true,
+		ACMEHostname:               testHostname,
+		ACMEDirectoryURL:           directoryURL,
+		ACMEDirectoryCAPath:        caPath,
+		ACMECacheDir:               cacheDir,
+		ACMEChallenge:              acmecert.ChallengeTLSALPN01,
+		ACMEChallengeListenAddress: net.JoinHostPort("127.0.0.1", strconv.Itoa(challengePort)),
+	}
+
+	m, err := acmecert.New(cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer m.Stop()
+
+	// Cameras connect by IP, so no SNI is sent
+	var cert *tls.Certificate
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		cert, err = m.GetCertificate(&tls.ClientHelloInfo{})
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+
+	if cert.Leaf == nil {
+		t.Fatal("Expected parsed leaf certificate")
+	}
+	if err := cert.Leaf.VerifyHostname(testHostname); err != nil {
+		t.Errorf("Certificate not valid for %s: %v", testHostname, err)
+	}
+	if !strings.Contains(cert.Leaf.Issuer.CommonName, "Pebble") {
+		t.Errorf("Expected Pebble issuer, got %q", cert.Leaf.Issuer.CommonName)
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("Expected certificate cached in %s (err=%v)", cacheDir, err)
+	}
+
+	// A restarted server reuses the cached certificate without talking to the CA
+	cfg.ACMEDirectoryURL = "https://127.0.0.1:1/unreachable"
+	restarted, err := acmecert.New(cfg)
+	if err != nil {
+		t.Fatalf("New (restart) failed: %v", err)
+	}
+	cached, err := restarted.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate from cache failed: %v", err)
+	}
+	if cached.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Error("Expected cached certificate to be reused after restart")
+	}
+}
@@ -0,0 +1,360 @@
+package adminserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/bandwidth"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/handoff"
+)
+
+// Server is the operator HTTP API for live sessions
+// It is bound to localhost unless ADMIN_TOKEN is set, in which case every request needs the token.
+type Server struct {
+	config     *config.Config
+	clientMgr  *clientmgr.Manager
+	httpServer *http.Server
+
+	// socket is passed on by graceful restarts
+	socketMu sync.Mutex
+	socket   net.Listener
+}
+
+// ValidateConfig refuses to expose the admin API beyond localhost without a token
+func ValidateConfig(cfg *config.Config) error {
+	if cfg.AdminToken != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(cfg.AdminListenAddress)
+	if err != nil {
+		return fmt.Errorf("invalid ADMIN_LISTEN_ADDRESS %q: %w", cfg.AdminListenAddress, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("ADMIN_LISTEN_ADDRESS %s is not a loopback address - set ADMIN_TOKEN to expose the admin API", cfg.AdminListenAddress)
+	}
+	return nil
+}
+
+// New creates the admin server
+func New(cfg *config.Config, clientMgr *clientmgr.Manager) *Server {
+	s := &Server{
+		config:    cfg,
+		clientMgr: clientMgr,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.handleList)
+	mux.HandleFunc("GET /sessions/{id}", s.handleGet)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleKick)
+	mux.HandleFunc("PUT /sessions/{id}/bandwidth", s.handleSessionBandwidth)
+	mux.HandleFunc("PUT /sessions/{id}/max-file-size", s.handleSessionMaxFileSize)
+	mux.HandleFunc("GET /bandwidth", s.handleBandwidth)
+	mux.HandleFunc("PUT /bandwidth", s.handleSetBandwidth)
+	mux.HandleFunc("PUT /bandwidth/events/{eventID}", s.handleEventBandwidth)
+
+	s.httpServer = &http.Server{
+		Handler:           s.withToken(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// ListenAndServe serves admin requests until Stop is called
+func (s *Server) ListenAndServe() error {
+	listener, err := handoff.Listen("admin", s.config.AdminListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.AdminListenAddress, err)
+	}
+	s.socketMu.Lock()
+	s.socket = listener
+	s.socketMu.Unlock()
+
+	log.Printf("admin_listening addr=%s token=%t", listener.Addr(), s.config.AdminToken != "")
+
+	if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ListenerFile returns a duplicate of the listening socket for a graceful restart
+func (s *Server) ListenerFile() (string, *os.File, error) {
+	s.socketMu.Lock()
+	socket := s.socket
+	s.socketMu.Unlock()
+	if socket == nil {
+		return "admin", nil, errors.New("listener not started")
+	}
+	file, err := handoff.File(socket)
+	return "admin", file, err
+}
+
+// Stop closes the listener and lets requests in progress finish until ctx is done
+func (s *Server) Stop(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.httpServer.Close()
+	}
+	return err
+}
+
+// withToken requires "Authorization: Bearer <ADMIN_TOKEN>" when a token is configured
+func (s *Server) withToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+				http.Error(w, "invalid admin token", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type sessionJSON struct {
+	ID          uint32       `json:"id"`
+	Protocol    string       `json:"protocol"`
+	ClientIP    string       `json:"client_ip"`
+	EventID     string       `json:"event_id"`
+	ConnectedAt time.Time    `json:"connected_at"`
+	FilesOK     int64        `json:"files_ok"`
+	FilesFailed int64        `json:"files_failed"`
+	Bytes       int64        `json:"bytes"`
+	LastUpload  *time.Time   `json:"last_upload_at,omitempty"`
+	LastError   string       `json:"last_error,omitempty"`
+	Throughput  float64      `json:"avg_throughput_mbps"`
+	Degraded    string       `json:"degraded,omitempty"`
+	MaxFileSize int64        `json:"max_file_size,omitempty"`
+	Uploads     []uploadJSON `json:"uploads"`
+}
+
+type uploadJSON struct {
+	File      string    `json:"file"`
+	Bytes     int64     `json:"bytes"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func toJSON(info clientmgr.ClientInfo) sessionJSON {
+	uploads := make([]uploadJSON, 0, len(info.Uploads))
+	for _, u := range info.Uploads {
+		uploads = append(uploads, uploadJSON{File: u.Filename, Bytes: u.Bytes, StartedAt: u.StartedAt})
+	}
+	var lastUpload *time.Time
+	if !info.Stats.LastUploadAt.IsZero() {
+		lastUpload = &info.Stats.LastUploadAt
+	}
+	return sessionJSON{
+		ID:          info.ID,
+		Protocol:    info.Protocol,
+		ClientIP:    info.ClientIP,
+		EventID:     info.EventID,
+		ConnectedAt: info.ConnectedAt,
+		FilesOK:     info.Stats.FilesOK,
+		FilesFailed: info.Stats.FilesFailed,
+		Bytes:       info.Stats.Bytes,
+		LastUpload:  lastUpload,
+		LastError:   info.Stats.LastError,
+		Throughput:  info.Stats.ThroughputMBps(),
+		Degraded:    info.Degraded,
+		MaxFileSize: info.MaxFileSize,
+		Uploads:     uploads,
+	}
+}
+
+// handleList returns every connected session, optionally filtered by ?event=
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	eventID := r.URL.Query().Get("event")
+
+	sessions := []sessionJSON{}
+	for _, info := range s.clientMgr.Clients() {
+		if eventID != "" && info.EventID != eventID {
+			continue
+		}
+		sessions = append(sessions, toJSON(info))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": sessions})
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSON(info))
+}
+
+// handleKick disconnects the session through the client manager (as on expired credentials)
+// The disconnect is asynchronous, so the reply is 202.
+func (s *Server) handleKick(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "disconnected by operator"
+	}
+	log.Printf("admin_kick id=%d ip=%s event=%s reason=%q", info.ID, info.ClientIP, info.EventID, reason)
+
+	s.clientMgr.SendEvent(clientmgr.ClientEvent{
+		Type:     clientmgr.EventKicked,
+		ClientID: info.ID,
+		Reason:   reason,
+	})
+	writeJSON(w, http.StatusAccepted, toJSON(info))
+}
+
+type bandwidthJSON struct {
+	Limits   bandwidth.Limits `json:"limits"`
+	Events   map[string]int64 `json:"events"`
+	Sessions map[string]int64 `json:"sessions"`
+}
+
+// handleBandwidth returns the default limits and every per-event and per-session override
+func (s *Server) handleBandwidth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.bandwidthJSON())
+}
+
+func (s *Server) bandwidthJSON() bandwidthJSON {
+	shaper := s.clientMgr.Bandwidth()
+	events, sessions := shaper.Overrides()
+	body := bandwidthJSON{Limits: shaper.Limits(), Events: events, Sessions: make(map[string]int64, len(sessions))}
+	for id, bps := range sessions {
+		body.Sessions[strconv.FormatUint(uint64(id), 10)] = bps
+	}
+	return body
+}
+
+// handleSetBandwidth changes the default limits; fields left out keep their value
+func (s *Server) handleSetBandwidth(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Session *int64 `json:"session_bps"`
+		Event   *int64 `json:"event_bps"`
+		Global  *int64 `json:"global_bps"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	shaper := s.clientMgr.Bandwidth()
+	limits := shaper.Limits()
+	if req.Session != nil {
+		limits.Session = *req.Session
+	}
+	if req.Event != nil {
+		limits.Event = *req.Event
+	}
+	if req.Global != nil {
+		limits.Global = *req.Global
+	}
+	if limits.Session < 0 || limits.Event < 0 || limits.Global < 0 {
+		http.Error(w, "limits must be 0 (unlimited) or positive", http.StatusBadRequest)
+		return
+	}
+
+	shaper.SetLimits(limits)
+	log.Printf("admin_bandwidth session=%d event=%d global=%d", limits.Session, limits.Event, limits.Global)
+	writeJSON(w, http.StatusOK, s.bandwidthJSON())
+}
+
+// handleEventBandwidth overrides one event's limit; {"bps": 0} restores the default
+func (s *Server) handleEventBandwidth(w http.ResponseWriter, r *http.Request) {
+	bps, ok := decodeRate(w, r)
+	if !ok {
+		return
+	}
+	eventID := r.PathValue("eventID")
+	s.clientMgr.Bandwidth().SetEventLimit(eventID, bps)
+	log.Printf("admin_bandwidth event=%s bps=%d", eventID, bps)
+	writeJSON(w, http.StatusOK, s.bandwidthJSON())
+}
+
+// handleSessionBandwidth overrides one session's limit; {"bps": 0} restores the default
+func (s *Server) handleSessionBandwidth(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	bps, ok := decodeRate(w, r)
+	if !ok {
+		return
+	}
+	s.clientMgr.Bandwidth().SetSessionLimit(info.ID, bps)
+	log.Printf("admin_bandwidth id=%d ip=%s bps=%d", info.ID, info.ClientIP, bps)
+	writeJSON(w, http.StatusOK, s.bandwidthJSON())
+}
+
+// handleSessionMaxFileSize overrides the largest file one session may upload; {"bytes": 0} restores the default
+// Uploads already running keep their limit.
+func (s *Server) handleSessionMaxFileSize(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Bytes *int64 `json:"bytes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Bytes == nil || *req.Bytes < 0 {
+		http.Error(w, `body must be {"bytes": n} with n >= 0 (0 = default)`, http.StatusBadRequest)
+		return
+	}
+	s.clientMgr.SetSessionMaxFileSize(info.ID, *req.Bytes)
+	log.Printf("admin_max_file_size id=%d ip=%s bytes=%d", info.ID, info.ClientIP, *req.Bytes)
+
+	if info, ok = s.clientMgr.GetClient(info.ID); !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSON(info))
+}
+
+// decodeRate reads {"bps": n}, answering 400 itself
+func decodeRate(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	var req struct {
+		BPS *int64 `json:"bps"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BPS == nil || *req.BPS < 0 {
+		http.Error(w, `body must be {"bps": n} with n >= 0 (0 = default)`, http.StatusBadRequest)
+		return 0, false
+	}
+	return *req.BPS, true
+}
+
+// lookup resolves the {id} path value, answering 400/404 itself
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (clientmgr.ClientInfo, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "invalid session id", http.StatusBadRequest)
+		return clientmgr.ClientInfo{}, false
+	}
+	info, ok := s.clientMgr.GetClient(uint32(id))
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return clientmgr.ClientInfo{}, false
+	}
+	return info, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("admin_response_failed error=%v", err)
+	}
+}
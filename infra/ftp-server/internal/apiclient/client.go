@@ -18,9 +18,11 @@ import (
 // APIClient defines the interface for API operations (for testing)
 type APIClient interface {
 	Authenticate(ctx context.Context, req AuthRequest) (*AuthResponse, error)
+	AuthenticateCertificate(ctx context.Context, req CertificateAuthRequest) (*AuthResponse, error)
 	Presign(ctx context.Context, token, filename, contentType string, contentLength *int64) (*PresignResponse, error)
 	PresignWithRetry(ctx context.Context, token, filename, contentType string, contentLength *int64, backoff []time.Duration) (*PresignResponse, error)
 	UploadToPresignedURL(ctx context.Context, putURL string, headers map[string]string, reader io.Reader) (*http.Response, error)
+	ReportSessionSummary(ctx context.Context, token string, summary SessionSummaryRequest) error
 }
 
 // Client is the HTTP client for communicating with the SabaiPics API
@@ -38,6 +40,12 @@ type AuthRequest struct {
 	Password string `json:"password"`
 }
 
+// CertificateAuthRequest represents a client certificate authentication request
+// Fingerprint is the lowercase hex SHA-256 of the leaf certificate (DER)
+type CertificateAuthRequest struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
 // AuthResponse represents the FTP authentication response
 type AuthResponse struct {
 	Token            string `json:"token"`
@@ -63,6 +71,20 @@ type PresignResponse struct {
 	RequiredHeaders map[string]string `json:"required_headers"`
 }
 
+// SessionSummaryRequest reports the totals of an ended camera session
+type SessionSummaryRequest struct {
+	Protocol          string  `json:"protocol"`
+	ClientIP          string  `json:"clientIp"`
+	ConnectedAt       string  `json:"connectedAt"`
+	DisconnectedAt    string  `json:"disconnectedAt"`
+	FilesOK           int64   `json:"filesOk"`
+	FilesFailed       int64   `json:"filesFailed"`
+	Bytes             int64   `json:"bytes"`
+	LastUploadAt      string  `json:"lastUploadAt,omitempty"`
+	LastError         string  `json:"lastError,omitempty"`
+	AvgThroughputMBps float64 `json:"avgThroughputMbps"`
+}
+
 // APIError represents an error response from the API
 type APIError struct {
 	Error struct {
@@ -130,6 +152,82 @@ func (c *Client) Authenticate(ctx context.Context, req AuthRequest) (*AuthRespon
 	return &authResp, nil
 }
 
+// AuthenticateCertificate resolves a registered device certificate to the photographer's active event
+func (c *Client) AuthenticateCertificate(ctx context.Context, req CertificateAuthRequest) (*AuthResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal certificate auth request: %w", err)
+	}
+
+	authURL, err := url.JoinPath(c.baseURL, "/api/ftp/auth/certificate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct certificate auth URL: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", authURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate auth request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	applyTraceHeaders(httpReq, ctx, "/api/ftp/auth/certificate")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("certificate auth request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		var apiErr APIError
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+			return nil, fmt.Errorf("certificate auth failed with status %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("certificate auth failed (%d): %s", resp.StatusCode, apiErr.Error.Message)
+	}
+
+	var authResp AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		return nil, fmt.Errorf("failed to decode certificate auth response: %w", err)
+	}
+
+	return &authResp, nil
+}
+
+// ReportSessionSummary posts the totals of an ended session (best effort, for photographer dashboards)
+func (c *Client) ReportSessionSummary(ctx context.Context, token string, summary SessionSummaryRequest) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session summary: %w", err)
+	}
+
+	summaryURL, err := url.JoinPath(c.baseURL, "/api/ftp/session-summary")
+	if err != nil {
+		return fmt.Errorf("failed to construct session summary URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", summaryURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	applyTraceHeaders(req, ctx, "/api/ftp/session-summary")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("session summary request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if apiErr, parsed := parseAPIError(resp); parsed {
+			return fmt.Errorf("session summary rejected (%d): %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return fmt.Errorf("session summary rejected with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // Presign requests a presigned R2 URL for upload
 func (c *Client) Presign(ctx context.Context, token, filename, contentType string, contentLength *int64) (*PresignResponse, error) {
 	reqBody := PresignRequest{
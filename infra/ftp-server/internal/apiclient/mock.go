@@ -2,6 +2,7 @@ package apiclient
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,12 @@ import (
 	"time"
 )
 
+// MockToken is the token the mock returns at login: shaped like an FTP upload JWT for
+// evt_test123 (photographer ph_test123), with a signature no secret verifies
+var MockToken = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." +
+	base64.RawURLEncoding.EncodeToString([]byte(`{"eventId":"evt_test123","photographerId":"ph_test123","aud":"ftp-upload"}`)) +
+	".mock-signature"
+
 // MockClient is a mock implementation of APIClient for testing
 type MockClient struct {
 	mu sync.Mutex
@@ -17,19 +24,25 @@ type MockClient struct {
 	// Configurable responses
 	AuthResponse      *AuthResponse
 	AuthError         error
+	CertAuthResponse  *AuthResponse // Defaults to AuthResponse when nil
+	CertAuthError     error
 	PresignResponse   *PresignResponse
 	PresignError      error
 	PresignHTTPStatus int // For simulating 401, 429, etc.
 	UploadError       error
-	UploadHTTPStatus  int // For simulating R2 errors
+	UploadHTTPStatus  int           // For simulating R2 errors
+	UploadDelay       time.Duration // For simulating slow R2 PUTs (cut short by ctx)
 
 	// Call tracking
-	AuthCalls    []AuthRequest
-	PresignCalls []MockPresignCall
-	UploadCalls  []MockUploadCall
-	authCount    atomic.Int64
-	presignCount atomic.Int64
-	uploadCount  atomic.Int64
+	AuthCalls     []AuthRequest
+	CertAuthCalls []CertificateAuthRequest
+	PresignCalls  []MockPresignCall
+	UploadCalls   []MockUploadCall
+	SummaryCalls  []MockSummaryCall
+	authCount     atomic.Int64
+	certAuthCount atomic.Int64
+	presignCount  atomic.Int64
+	uploadCount   atomic.Int64
 }
 
 // MockPresignCall records details of a presign call
@@ -45,14 +58,21 @@ type MockUploadCall struct {
 	PutURL  string
 	Headers map[string]string
 	Size    int64
+	Data    []byte // Body as received, to check it arrived intact
 	Time    time.Time
 }
 
+// MockSummaryCall records details of a session summary report
+type MockSummaryCall struct {
+	Token   string
+	Summary SessionSummaryRequest
+}
+
 // NewMockClient creates a new mock client with default success responses
 func NewMockClient() *MockClient {
 	return &MockClient{
 		AuthResponse: &AuthResponse{
-			Token:            "mock-jwt-token",
+			Token:            MockToken,
 			EventID:          "evt_test123",
 			EventName:        "Test Event",
 			UploadWindowEnd:  time.Now().Add(24 * time.Hour).Format(time.RFC3339),
@@ -65,9 +85,10 @@ func NewMockClient() *MockClient {
 			ExpiresAt:       time.Now().Add(5 * time.Minute).Format(time.RFC3339),
 			RequiredHeaders: map[string]string{"Content-Type": "image/jpeg"},
 		},
-		AuthCalls:    []AuthRequest{},
-		PresignCalls: []MockPresignCall{},
-		UploadCalls:  []MockUploadCall{},
+		AuthCalls:     []AuthRequest{},
+		CertAuthCalls: []CertificateAuthRequest{},
+		PresignCalls:  []MockPresignCall{},
+		UploadCalls:   []MockUploadCall{},
 	}
 }
 
@@ -85,6 +106,23 @@ func (m *MockClient) Authenticate(ctx context.Context, req AuthRequest) (*AuthRe
 	return m.AuthResponse, nil
 }
 
+// AuthenticateCertificate implements APIClient.AuthenticateCertificate
+func (m *MockClient) AuthenticateCertificate(ctx context.Context, req CertificateAuthRequest) (*AuthResponse, error) {
+	m.mu.Lock()
+	m.CertAuthCalls = append(m.CertAuthCalls, req)
+	m.mu.Unlock()
+	m.certAuthCount.Add(1)
+
+	if m.CertAuthError != nil {
+		return nil, m.CertAuthError
+	}
+
+	if m.CertAuthResponse != nil {
+		return m.CertAuthResponse, nil
+	}
+	return m.AuthResponse, nil
+}
+
 // Presign implements APIClient.Presign
 func (m *MockClient) Presign(ctx context.Context, token, filename, contentType string, contentLength *int64) (*PresignResponse, error) {
 	m.mu.Lock()
@@ -128,6 +166,14 @@ func (m *MockClient) PresignWithRetry(ctx context.Context, token, filename, cont
 
 // UploadToPresignedURL implements APIClient.UploadToPresignedURL
 func (m *MockClient) UploadToPresignedURL(ctx context.Context, putURL string, headers map[string]string, reader io.Reader) (*http.Response, error) {
+	if m.UploadDelay > 0 {
+		select {
+		case <-time.After(m.UploadDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
 	// Read all data to get size
 	data, err := io.ReadAll(reader)
 	if err != nil {
@@ -139,6 +185,7 @@ func (m *MockClient) UploadToPresignedURL(ctx context.Context, putURL string, he
 		PutURL:  putURL,
 		Headers: headers,
 		Size:    int64(len(data)),
+		Data:    data,
 		Time:    time.Now(),
 	})
 	m.mu.Unlock()
@@ -160,11 +207,41 @@ func (m *MockClient) UploadToPresignedURL(ctx context.Context, putURL string, he
 	return mockResp, nil
 }
 
+// ReportSessionSummary implements APIClient.ReportSessionSummary
+func (m *MockClient) ReportSessionSummary(ctx context.Context, token string, summary SessionSummaryRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.SummaryCalls = append(m.SummaryCalls, MockSummaryCall{Token: token, Summary: summary})
+	return nil
+}
+
+// GetSummaryCalls returns the recorded session summary reports (thread-safe)
+func (m *MockClient) GetSummaryCalls() []MockSummaryCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MockSummaryCall(nil), m.SummaryCalls...)
+}
+
 // GetAuthCallCount returns the number of auth calls (thread-safe)
 func (m *MockClient) GetAuthCallCount() int {
 	return int(m.authCount.Load())
 }
 
+// GetCertAuthCallCount returns the number of certificate auth calls (thread-safe)
+func (m *MockClient) GetCertAuthCallCount() int {
+	return int(m.certAuthCount.Load())
+}
+
+// GetLastCertAuthCall returns the last certificate auth call (thread-safe)
+func (m *MockClient) GetLastCertAuthCall() *CertificateAuthRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.CertAuthCalls) == 0 {
+		return nil
+	}
+	return &m.CertAuthCalls[len(m.CertAuthCalls)-1]
+}
+
 // GetPresignCallCount returns the number of presign calls (thread-safe)
 func (m *MockClient) GetPresignCallCount() int {
 	return int(m.presignCount.Load())
@@ -200,14 +277,18 @@ func (m *MockClient) Reset() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.AuthCalls = []AuthRequest{}
+	m.CertAuthCalls = []CertificateAuthRequest{}
 	m.PresignCalls = []MockPresignCall{}
 	m.UploadCalls = []MockUploadCall{}
+	m.SummaryCalls = nil
 	m.AuthError = nil
+	m.CertAuthError = nil
 	m.PresignError = nil
 	m.PresignHTTPStatus = 0
 	m.UploadError = nil
 	m.UploadHTTPStatus = 0
 	m.authCount.Store(0)
+	m.certAuthCount.Store(0)
 	m.presignCount.Store(0)
 	m.uploadCount.Store(0)
 }
@@ -217,6 +298,11 @@ func (m *MockClient) SetAuthFailure(err error) {
 	m.AuthError = err
 }
 
+// SetCertAuthFailure configures the mock to reject client certificates
+func (m *MockClient) SetCertAuthFailure(err error) {
+	m.CertAuthError = err
+}
+
 // SetPresignFailure configures the mock to return a presign error with status
 func (m *MockClient) SetPresignFailure(err error, httpStatus int) {
 	m.PresignError = err
@@ -229,5 +315,10 @@ func (m *MockClient) SetUploadFailure(err error, httpStatus int) {
 	m.UploadHTTPStatus = httpStatus
 }
 
+// SetUploadDelay makes every R2 PUT take d (or until its context is cancelled)
+func (m *MockClient) SetUploadDelay(d time.Duration) {
+	m.UploadDelay = d
+}
+
 // Ensure MockClient implements APIClient
 var _ APIClient = (*MockClient)(nil)
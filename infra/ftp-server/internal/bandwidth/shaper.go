@@ -0,0 +1,336 @@
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+)
+
+// Direction is the leg of an upload being shaped (also the metric attribute)
+type Direction int
+
+const (
+	// Inbound is client data written to the upload buffer
+	Inbound Direction = iota
+	// Outbound is the buffered file read into the R2 PUT
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "out"
+	}
+	return "in"
+}
+
+// Scopes a limit applies to (also the metric attributes)
+const (
+	ScopeSession = "session"
+	ScopeEvent   = "event"
+	ScopeGlobal  = "global"
+)
+
+// minBurst lets a limited bucket pass at least one typical data connection read at once
+const minBurst = 64 << 10
+
+// Limits are token bucket rates in bytes per second; 0 means unlimited
+// Each applies to the inbound and the outbound leg separately.
+type Limits struct {
+	Session int64 `json:"session_bps"`
+	Event   int64 `json:"event_bps"`
+	Global  int64 `json:"global_bps"`
+}
+
+// FromConfig reads the BANDWIDTH_* settings
+func FromConfig(cfg *config.Config) Limits {
+	return Limits{
+		Session: int64(cfg.BandwidthSessionBPS),
+		Event:   int64(cfg.BandwidthEventBPS),
+		Global:  int64(cfg.BandwidthGlobalBPS),
+	}
+}
+
+// Validate rejects negative rates
+func (l Limits) Validate() error {
+	if l.Session < 0 || l.Event < 0 || l.Global < 0 {
+		return fmt.Errorf("BANDWIDTH_SESSION_BPS, BANDWIDTH_EVENT_BPS and BANDWIDTH_GLOBAL_BPS must be 0 (unlimited) or positive")
+	}
+	return nil
+}
+
+// buckets holds one limiter per direction
+type buckets [2]*rate.Limiter
+
+func newBuckets(bps int64) buckets {
+	return buckets{newLimiter(bps), newLimiter(bps)}
+}
+
+func (b buckets) set(bps int64) {
+	for _, l := range b {
+		l.SetLimit(limitOf(bps))
+		l.SetBurst(burstOf(bps))
+	}
+}
+
+func newLimiter(bps int64) *rate.Limiter {
+	return rate.NewLimiter(limitOf(bps), burstOf(bps))
+}
+
+func limitOf(bps int64) rate.Limit {
+	if bps <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(bps)
+}
+
+// burstOf allows one second of traffic at once
+func burstOf(bps int64) int {
+	return int(max(bps, minBurst))
+}
+
+// shared buckets are kept while an upload of their session or event is open
+type shared struct {
+	buckets buckets
+	refs    int
+}
+
+// Shaper rate-limits upload traffic per session, per event and globally
+// Limits and per-event/per-session overrides can be changed while uploads are running.
+type Shaper struct {
+	mu       sync.Mutex
+	limits   Limits
+	global   buckets
+	events   map[string]*shared
+	sessions map[uint32]*shared
+
+	eventOverrides   map[string]int64
+	sessionOverrides map[uint32]int64
+}
+
+// New creates a Shaper
+func New(limits Limits) *Shaper {
+	return &Shaper{
+		limits:           limits,
+		global:           newBuckets(limits.Global),
+		events:           make(map[string]*shared),
+		sessions:         make(map[uint32]*shared),
+		eventOverrides:   make(map[string]int64),
+		sessionOverrides: make(map[uint32]int64),
+	}
+}
+
+// Limits returns the default limits
+func (s *Shaper) Limits() Limits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits
+}
+
+// SetLimits changes the default limits; running uploads pick them up immediately
+// Sessions and events with an override keep it.
+func (s *Shaper) SetLimits(limits Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits = limits
+	s.global.set(limits.Global)
+	for eventID, b := range s.events {
+		b.buckets.set(s.eventRate(eventID))
+	}
+	for clientID, b := range s.sessions {
+		b.buckets.set(s.sessionRate(clientID))
+	}
+}
+
+// SetEventLimit overrides the per-event limit for one event (0 restores the default)
+func (s *Shaper) SetEventLimit(eventID string, bps int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bps > 0 {
+		s.eventOverrides[eventID] = bps
+	} else {
+		delete(s.eventOverrides, eventID)
+	}
+	if b, ok := s.events[eventID]; ok {
+		b.buckets.set(s.eventRate(eventID))
+	}
+}
+
+// SetSessionLimit overrides the per-session limit for one session (0 restores the default)
+func (s *Shaper) SetSessionLimit(clientID uint32, bps int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bps > 0 {
+		s.sessionOverrides[clientID] = bps
+	} else {
+		delete(s.sessionOverrides, clientID)
+	}
+	if b, ok := s.sessions[clientID]; ok {
+		b.buckets.set(s.sessionRate(clientID))
+	}
+}
+
+// Overrides returns a copy of the per-event and per-session overrides
+func (s *Shaper) Overrides() (events map[string]int64, sessions map[uint32]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events = make(map[string]int64, len(s.eventOverrides))
+	for k, v := range s.eventOverrides {
+		events[k] = v
+	}
+	sessions = make(map[uint32]int64, len(s.sessionOverrides))
+	for k, v := range s.sessionOverrides {
+		sessions[k] = v
+	}
+	return events, sessions
+}
+
+// eventRate and sessionRate resolve overrides; callers hold mu
+func (s *Shaper) eventRate(eventID string) int64 {
+	if bps, ok := s.eventOverrides[eventID]; ok {
+		return bps
+	}
+	return s.limits.Event
+}
+
+func (s *Shaper) sessionRate(clientID uint32) int64 {
+	if bps, ok := s.sessionOverrides[clientID]; ok {
+		return bps
+	}
+	return s.limits.Session
+}
+
+// Open returns the flow of one upload; Close it when the upload ends
+func (s *Shaper) Open(clientID uint32, eventID string) *Flow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[clientID]
+	if !ok {
+		session = &shared{buckets: newBuckets(s.sessionRate(clientID))}
+		s.sessions[clientID] = session
+	}
+	session.refs++
+
+	event, ok := s.events[eventID]
+	if !ok {
+		event = &shared{buckets: newBuckets(s.eventRate(eventID))}
+		s.events[eventID] = event
+	}
+	event.refs++
+
+	return &Flow{shaper: s, clientID: clientID, eventID: eventID, session: session, event: event}
+}
+
+// ForgetSession drops a session's override once it has ended
+func (s *Shaper) ForgetSession(clientID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessionOverrides, clientID)
+}
+
+// Flow is one upload's view of the buckets it is shaped by
+// A nil Flow passes everything through unshaped.
+type Flow struct {
+	shaper   *Shaper
+	clientID uint32
+	eventID  string
+	session  *shared
+	event    *shared
+	once     sync.Once
+}
+
+// Wait blocks until n bytes may pass in dir through the session, event and global buckets
+// It fails when ctx is done first.
+func (f *Flow) Wait(ctx context.Context, dir Direction, n int) error {
+	if f == nil || n <= 0 {
+		return nil
+	}
+	observability.RecordBandwidthBytes(dir.String(), int64(n))
+
+	for _, step := range []struct {
+		scope   string
+		limiter *rate.Limiter
+	}{
+		{ScopeSession, f.session.buckets[dir]},
+		{ScopeEvent, f.event.buckets[dir]},
+		{ScopeGlobal, f.shaper.global[dir]},
+	} {
+		if step.limiter.Limit() == rate.Inf {
+			continue
+		}
+		start := time.Now()
+		if err := waitN(ctx, step.limiter, n); err != nil {
+			return err
+		}
+		if waited := time.Since(start); waited > time.Millisecond {
+			observability.RecordBandwidthWait(dir.String(), step.scope, waited)
+		}
+	}
+	return nil
+}
+
+// waitN takes n tokens in burst-sized steps (WaitN refuses more than the burst at once)
+func waitN(ctx context.Context, limiter *rate.Limiter, n int) error {
+	for n > 0 {
+		step := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, step); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// Reader shapes reads from r as outbound traffic
+func (f *Flow) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if f == nil {
+		return r
+	}
+	return &shapedReader{ctx: ctx, flow: f, r: r}
+}
+
+// Close releases the flow's session and event buckets
+func (f *Flow) Close() {
+	if f == nil {
+		return
+	}
+	f.once.Do(func() {
+		s := f.shaper
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if f.session.refs--; f.session.refs == 0 {
+			delete(s.sessions, f.clientID)
+		}
+		if f.event.refs--; f.event.refs == 0 {
+			delete(s.events, f.eventID)
+		}
+	})
+}
+
+type shapedReader struct {
+	ctx  context.Context
+	flow *Flow
+	r    io.Reader
+}
+
+func (r *shapedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.flow.Wait(r.ctx, Outbound, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,147 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+const testRate = 256 << 10 // bytes per second; the burst is one second of it
+
+// send pushes n bytes through flow in 32 KiB writes, as a data connection does
+func send(t *testing.T, flow *Flow, dir Direction, n int) time.Duration {
+	t.Helper()
+	start := time.Now()
+	for n > 0 {
+		chunk := min(n, 32<<10)
+		if err := flow.Wait(context.Background(), dir, chunk); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+		n -= chunk
+	}
+	return time.Since(start)
+}
+
+func TestFlow_LimitsEachScope(t *testing.T) {
+	for name, limits := range map[string]Limits{
+		"session": {Session: testRate},
+		"event":   {Event: testRate},
+		"global":  {Global: testRate},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := New(limits)
+			flow := s.Open(1, "evt_1")
+			defer flow.Close()
+
+			// The burst passes at once, the next half second of traffic has to wait
+			if elapsed := send(t, flow, Inbound, testRate); elapsed > 100*time.Millisecond {
+				t.Errorf("Burst took %v", elapsed)
+			}
+			if elapsed := send(t, flow, Inbound, testRate/2); elapsed < 400*time.Millisecond {
+				t.Errorf("Half a second of traffic over the limit took only %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestFlow_LegsAreShapedSeparately(t *testing.T) {
+	s := New(Limits{Session: testRate})
+	flow := s.Open(1, "evt_1")
+	defer flow.Close()
+
+	send(t, flow, Inbound, testRate)
+	if elapsed := send(t, flow, Outbound, testRate); elapsed > 100*time.Millisecond {
+		t.Errorf("Outbound burst waited %v for the inbound leg", elapsed)
+	}
+}
+
+func TestFlow_EventBucketIsShared(t *testing.T) {
+	s := New(Limits{Event: testRate})
+	first := s.Open(1, "evt_1")
+	defer first.Close()
+	second := s.Open(2, "evt_1")
+	defer second.Close()
+	other := s.Open(3, "evt_2")
+	defer other.Close()
+
+	send(t, first, Inbound, testRate)
+	if elapsed := send(t, second, Inbound, testRate/2); elapsed < 400*time.Millisecond {
+		t.Errorf("Second session of the event was not held back (%v)", elapsed)
+	}
+	if elapsed := send(t, other, Inbound, testRate); elapsed > 100*time.Millisecond {
+		t.Errorf("Another event waited %v", elapsed)
+	}
+}
+
+func TestShaper_RuntimeChanges(t *testing.T) {
+	s := New(Limits{Session: testRate})
+	flow := s.Open(1, "evt_1")
+	defer flow.Close()
+	send(t, flow, Inbound, testRate)
+
+	// Lifting the default applies to the open flow
+	s.SetLimits(Limits{})
+	if elapsed := send(t, flow, Inbound, 4*testRate); elapsed > 100*time.Millisecond {
+		t.Errorf("Unlimited flow took %v", elapsed)
+	}
+
+	// A session override wins over the default until it is cleared
+	s.SetSessionLimit(1, testRate)
+	send(t, flow, Inbound, testRate)
+	if elapsed := send(t, flow, Inbound, testRate/2); elapsed < 400*time.Millisecond {
+		t.Errorf("Session override not applied (%v)", elapsed)
+	}
+	_, sessions := s.Overrides()
+	if sessions[1] != testRate {
+		t.Errorf("Overrides = %v, want session 1 at %d", sessions, testRate)
+	}
+	s.SetSessionLimit(1, 0)
+	if _, sessions := s.Overrides(); len(sessions) != 0 {
+		t.Errorf("Override not cleared: %v", sessions)
+	}
+}
+
+func TestFlow_CloseReleasesBuckets(t *testing.T) {
+	s := New(Limits{Session: testRate, Event: testRate})
+	first := s.Open(1, "evt_1")
+	second := s.Open(1, "evt_1")
+	first.Close()
+	first.Close() // idempotent
+	if len(s.sessions) != 1 || len(s.events) != 1 {
+		t.Fatalf("Buckets released while an upload is still open")
+	}
+	second.Close()
+	if len(s.sessions) != 0 || len(s.events) != 0 {
+		t.Errorf("Buckets kept after every upload closed: %d sessions, %d events", len(s.sessions), len(s.events))
+	}
+}
+
+func TestFlow_ReaderAndCancellation(t *testing.T) {
+	s := New(Limits{Global: testRate})
+	flow := s.Open(1, "evt_1")
+	defer flow.Close()
+
+	data := bytes.Repeat([]byte("x"), testRate+testRate/2)
+	start := time.Now()
+	got, err := io.ReadAll(flow.Reader(context.Background(), bytes.NewReader(data)))
+	if err != nil || len(got) != len(data) {
+		t.Fatalf("ReadAll = %d bytes, %v", len(got), err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Shaped read took only %v", elapsed)
+	}
+
+	// The bucket is empty now; a cancelled upload stops waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := flow.Wait(ctx, Outbound, testRate); err == nil {
+		t.Error("Wait should fail once the context is done")
+	}
+
+	var unshaped *Flow
+	if err := unshaped.Wait(context.Background(), Inbound, 1<<30); err != nil {
+		t.Errorf("nil Flow should pass everything: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package certmgr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+)
+
+// Manager serves the current TLS certificate and reloads it when the files change
+// Certbot renewals are picked up by polling the cert/key files or on SIGHUP.
+// A failed reload keeps serving the previous certificate.
+type Manager struct {
+	certPath string
+	keyPath  string
+	interval time.Duration
+
+	current atomic.Pointer[tls.Certificate]
+
+	// lastSeen is the file state of the last reload attempt (successful or not)
+	// so a bad renewal is only retried once the files change again
+	lastSeen fileState
+
+	reloadMu sync.Mutex
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// fileState identifies a version of the cert/key pair on disk
+type fileState struct {
+	certModTime time.Time
+	certSize    int64
+	keyModTime  time.Time
+	keySize     int64
+}
+
+// New loads the certificate pair and returns a manager serving it
+// The initial load must succeed - there is no previous certificate to fall back to
+func New(certPath, keyPath string, interval time.Duration) (*Manager, error) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	m := &Manager{
+		certPath: certPath,
+		keyPath:  keyPath,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+
+	state, _ := m.stat()
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	m.lastSeen = state
+
+	return m, nil
+}
+
+// GetCertificate returns the current certificate (for tls.Config.GetCertificate)
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.current.Load(), nil
+}
+
+// Start begins watching the certificate files and listening for SIGHUP
+func (m *Manager) Start() error {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	m.wg.Add(1)
+	go m.run(sighup)
+	return nil
+}
+
+// Stop stops watching for certificate changes
+func (m *Manager) Stop() {
+	close(m.stopChan)
+	m.wg.Wait()
+}
+
+// Reload loads the certificate pair from disk and swaps it in
+// On error the previous certificate keeps being served
+func (m *Manager) Reload() error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	if state, err := m.stat(); err == nil {
+		m.lastSeen = state
+	}
+
+	if err := m.load(); err != nil {
+		observability.RecordCertReload("error")
+		log.Printf("tls_cert_reload_failed cert=%s error=%v action=keep_previous", m.certPath, err)
+		return err
+	}
+
+	observability.RecordCertReload("ok")
+	return nil
+}
+
+func (m *Manager) run(sighup chan os.Signal) {
+	defer m.wg.Done()
+	defer signal.Stop(sighup)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopChan:
+			return
+
+		case <-sighup:
+			log.Printf("tls_cert_reload_requested trigger=sighup")
+			_ = m.Reload()
+
+		case <-ticker.C:
+			state, err := m.stat()
+			if err != nil {
+				// Files can briefly disappear while certbot swaps symlinks
+				continue
+			}
+			m.reloadMu.Lock()
+			changed := state != m.lastSeen
+			m.reloadMu.Unlock()
+			if changed {
+				log.Printf("tls_cert_reload_requested trigger=file_change")
+				_ = m.Reload()
+			}
+		}
+	}
+}
+
+// load parses the pair and swaps it in only if it is usable
+func (m *Manager) load() error {
+	cert, err := tls.LoadX509KeyPair(m.certPath, m.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse TLS certificate: %w", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		// Never swap a working certificate for an expired one; at startup serve it anyway
+		if m.current.Load() != nil {
+			return fmt.Errorf("TLS certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+		}
+		log.Printf("tls_cert_expired not_after=%s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+
+	m.current.Store(&cert)
+	observability.SetCertExpiry(leaf.NotAfter)
+	log.Printf("tls_cert_loaded subject=%s not_after=%s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func (m *Manager) stat() (fileState, error) {
+	certInfo, err := os.Stat(m.certPath)
+	if err != nil {
+		return fileState{}, err
+	}
+	keyInfo, err := os.Stat(m.keyPath)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{
+		certModTime: certInfo.ModTime(),
+		certSize:    certInfo.Size(),
+		keyModTime:  keyInfo.ModTime(),
+		keySize:     keyInfo.Size(),
+	}, nil
+}
@@ -0,0 +1,121 @@
+package certmgr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestPair writes a self-signed cert/key pair valid until notAfter
+func writeTestPair(t *testing.T, certPath, keyPath, commonName string, notAfter time.Time) {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatalf("Failed to write cert: %v", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+func servedCommonName(t *testing.T, m *Manager) string {
+	t.Helper()
+	cert, err := m.GetCertificate(nil)
+	if err != nil || cert == nil || cert.Leaf == nil {
+		t.Fatalf("GetCertificate() = %v, %v", cert, err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestManager_ReloadKeepsPreviousOnBadRenewal(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "fullchain.pem")
+	keyPath := filepath.Join(dir, "privkey.pem")
+	writeTestPair(t, certPath, keyPath, "original", time.Now().Add(24*time.Hour))
+
+	m, err := New(certPath, keyPath, time.Minute)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Half-written renewal: garbage certificate
+	if err := os.WriteFile(certPath, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err == nil {
+		t.Fatal("Reload() with garbage certificate should fail")
+	}
+	if got := servedCommonName(t, m); got != "original" {
+		t.Errorf("served certificate = %q after bad renewal, want original", got)
+	}
+
+	// Expired renewal must not replace a working certificate
+	writeTestPair(t, certPath, keyPath, "expired", time.Now().Add(-time.Minute))
+	if err := m.Reload(); err == nil {
+		t.Fatal("Reload() with expired certificate should fail")
+	}
+	if got := servedCommonName(t, m); got != "original" {
+		t.Errorf("served certificate = %q after expired renewal, want original", got)
+	}
+
+	writeTestPair(t, certPath, keyPath, "renewed", time.Now().Add(48*time.Hour))
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := servedCommonName(t, m); got != "renewed" {
+		t.Errorf("served certificate = %q, want renewed", got)
+	}
+}
+
+func TestManager_WatchPicksUpRenewal(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "fullchain.pem")
+	keyPath := filepath.Join(dir, "privkey.pem")
+	writeTestPair(t, certPath, keyPath, "original", time.Now().Add(24*time.Hour))
+
+	m, err := New(certPath, keyPath, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	m.Start()
+	defer m.Stop()
+
+	writeTestPair(t, certPath, keyPath, "renewed", time.Now().Add(48*time.Hour))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for servedCommonName(t, m) != "renewed" {
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate was not picked up by the file watcher")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/limits"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/mime"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/transfer"
 	"github.com/spf13/afero"
@@ -19,34 +20,59 @@ import (
 // Logging is the responsibility of MainDriver at application boundaries
 type ClientDriver struct {
 	eventID   string
+	eventName string
 	jwtToken  string
-	clientIP  string // Client IP address for upload transaction context
-	clientID  uint32 // Client ID for event reporting to hub
-	clientMgr *clientmgr.Manager
-	apiClient apiclient.APIClient
-	config    *config.Config
+	// uploadWindowEnd and creditsRemaining are snapshots from the auth response
+	uploadWindowEnd  string
+	creditsRemaining int
+	certFingerprint  string // Set when the session authenticated by client certificate
+	clientIP         string // Client IP address for upload transaction context
+	clientID         uint32 // Client ID for event reporting to hub
+	clientMgr        *clientmgr.Manager
+	apiClient        apiclient.APIClient
+	config           *config.Config
+	limiter          *limits.Limiter // Caps in-flight uploads (nil = unlimited)
 }
 
-// NewClientDriver creates a new ClientDriver instance with JWT token, API client, and client manager
-func NewClientDriver(eventID, jwtToken, clientIP string, clientID uint32, clientMgr *clientmgr.Manager, apiClient apiclient.APIClient, cfg *config.Config) *ClientDriver {
+// NewClientDriver creates a new ClientDriver instance from the auth response, API client, and client manager
+func NewClientDriver(auth *apiclient.AuthResponse, clientIP string, clientID uint32, clientMgr *clientmgr.Manager, apiClient apiclient.APIClient, cfg *config.Config) *ClientDriver {
 	return &ClientDriver{
-		eventID:   eventID,
-		jwtToken:  jwtToken,
-		clientIP:  clientIP,
-		clientID:  clientID,
-		clientMgr: clientMgr,
-		apiClient: apiClient,
-		config:    cfg,
+		eventID:          auth.EventID,
+		eventName:        auth.EventName,
+		jwtToken:         auth.Token,
+		uploadWindowEnd:  auth.UploadWindowEnd,
+		creditsRemaining: auth.CreditsRemaining,
+		clientIP:         clientIP,
+		clientID:         clientID,
+		clientMgr:        clientMgr,
+		apiClient:        apiClient,
+		config:           cfg,
 	}
 }
 
+// SetCertificateFingerprint records that the session authenticated by client certificate
+func (d *ClientDriver) SetCertificateFingerprint(fingerprint string) {
+	d.certFingerprint = fingerprint
+}
+
+// CertificateFingerprint returns the client certificate fingerprint, or "" for USER/PASS sessions
+func (d *ClientDriver) CertificateFingerprint() string {
+	return d.certFingerprint
+}
+
+// SetLimiter caps the session's in-flight uploads (FTP_MAX_UPLOADS*)
+func (d *ClientDriver) SetLimiter(limiter *limits.Limiter) {
+	d.limiter = limiter
+}
+
 // Name returns the name of this driver
 func (d *ClientDriver) Name() string {
 	return "UploadOnlyDriver"
 }
 
 // OpenFile opens a file for writing (STOR command)
-// Returning an error here causes the FTP server to reply with a 550 error before any data is sent.
+// Returning an error here causes the FTP server to reply with a 550 error (the error's own
+// ReplyCode when it has one, e.g. 452 when the upload buffer is low on disk space) before any data is sent.
 func (d *ClientDriver) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
 	// Check if this is a write operation
 	if flag&os.O_WRONLY == 0 && flag&os.O_RDWR == 0 {
@@ -61,6 +87,16 @@ func (d *ClientDriver) OpenFile(name string, flag int, perm os.FileMode) (afero.
 		return nil, err
 	}
 
+	// Over a cap the STOR fails with 450 (limits.LimitError.ReplyCode), so the camera retries
+	release := func() {}
+	if d.limiter != nil {
+		release, err = d.limiter.AcquireUpload(limits.HostOf(d.clientIP), d.eventID)
+		if err != nil {
+			fmt.Printf("WARN: Rejected upload: %s (%v)\n", name, err)
+			return nil, err
+		}
+	}
+
 	uploadCtx := context.Background()
 	if ctx, ok := d.clientMgr.GetUploadContext(d.clientID); ok {
 		uploadCtx = ctx
@@ -78,8 +114,10 @@ func (d *ClientDriver) OpenFile(name string, flag int, perm os.FileMode) (afero.
 		d.apiClient,
 	)
 	if err != nil {
+		release()
 		return nil, err
 	}
+	uploadTransfer.ReleaseOnClose(release)
 
 	return uploadTransfer, nil
 }
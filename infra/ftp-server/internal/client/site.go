@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ftpserver "github.com/fclairamb/ftpserverlib"
+)
+
+// WelcomeMessage returns the post-login reply so photographers can confirm which
+// event the camera is bound to
+func (d *ClientDriver) WelcomeMessage() string {
+	return fmt.Sprintf("Logged in to event %q (%s), %d credits remaining",
+		d.displayEventName(), d.eventID, d.creditsRemaining)
+}
+
+// Site handles the custom SITE STATUS / SITE CREDITS / SITE EVENT commands
+// Returning nil lets ftpserverlib handle its built-in SITE subcommands (CHMOD, MKDIR, ...)
+func (d *ClientDriver) Site(param string) *ftpserver.AnswerCommand {
+	cmd := strings.ToUpper(strings.TrimSpace(strings.SplitN(param, " ", 2)[0]))
+
+	switch cmd {
+	case "STATUS":
+		return &ftpserver.AnswerCommand{
+			Code:    ftpserver.StatusOK,
+			Message: strings.Join(append(append(d.eventLines(), d.creditsLine()), d.sessionLines()...), "\n"),
+		}
+	case "CREDITS":
+		return &ftpserver.AnswerCommand{Code: ftpserver.StatusOK, Message: d.creditsLine()}
+	case "EVENT":
+		return &ftpserver.AnswerCommand{Code: ftpserver.StatusOK, Message: strings.Join(d.eventLines(), "\n")}
+	default:
+		return nil
+	}
+}
+
+func (d *ClientDriver) eventLines() []string {
+	windowEnd := d.uploadWindowEnd
+	if windowEnd == "" {
+		windowEnd = "none"
+	}
+	return []string{
+		fmt.Sprintf("Event: %s (%s)", d.displayEventName(), d.eventID),
+		fmt.Sprintf("Upload window ends: %s", windowEnd),
+	}
+}
+
+func (d *ClientDriver) creditsLine() string {
+	return fmt.Sprintf("Credits remaining at login: %d", d.creditsRemaining)
+}
+
+func (d *ClientDriver) sessionLines() []string {
+	stats, _ := d.clientMgr.GetStats(d.clientID)
+	lines := []string{fmt.Sprintf("Session: %d uploaded, %d bytes, %d failed, avg %.2f MB/s",
+		stats.FilesOK, stats.Bytes, stats.FilesFailed, stats.ThroughputMBps())}
+
+	if stats.LastUploadAt.IsZero() {
+		return append(lines, "Last upload: none")
+	}
+	lines = append(lines, fmt.Sprintf("Last upload: %s", stats.LastUploadAt.UTC().Format(time.RFC3339)))
+	if stats.LastError != "" {
+		lines = append(lines, fmt.Sprintf("Last error: %s", stats.LastError))
+	}
+	return lines
+}
+
+func (d *ClientDriver) displayEventName() string {
+	if d.eventName == "" {
+		return d.eventID
+	}
+	return d.eventName
+}
@@ -2,12 +2,29 @@ package clientmgr
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"sort"
 	"sync"
-
-	ftpserver "github.com/fclairamb/ftpserverlib"
+	"sync/atomic"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/bandwidth"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/scheduler"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/spool"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/uploadbuf"
 )
 
+// ErrDraining is returned for uploads started after the server began shutting down
+var ErrDraining = errors.New("server is restarting, try again shortly")
+
+// reportWait bounds how long Stop waits for session summaries still being reported
+// (the API call itself times out after 10s)
+var reportWait = 15 * time.Second
+
 // EventType represents the type of client event
 type EventType int
 
@@ -16,8 +33,35 @@ const (
 	EventAuthExpired EventType = iota
 	// EventUploadFailed indicates an upload failed (non-auth error)
 	EventUploadFailed
+	// EventKicked indicates an operator asked to disconnect the client (admin API)
+	EventKicked
+	// EventUploadSucceeded indicates an upload reached storage
+	EventUploadSucceeded
+	// EventEventExpired indicates the API refused an upload because the event has ended
+	EventEventExpired
+	// EventCreditsExhausted indicates the API refused an upload for lack of credits
+	EventCreditsExhausted
 )
 
+func (t EventType) String() string {
+	switch t {
+	case EventAuthExpired:
+		return "auth_expired"
+	case EventUploadFailed:
+		return "upload_failed"
+	case EventKicked:
+		return "kicked"
+	case EventUploadSucceeded:
+		return "upload_succeeded"
+	case EventEventExpired:
+		return "event_expired"
+	case EventCreditsExhausted:
+		return "credits_exhausted"
+	default:
+		return fmt.Sprintf("event_%d", int(t))
+	}
+}
+
 // ClientEvent represents an event reported by upload transfers
 // The hub receives these events and decides what action to take
 type ClientEvent struct {
@@ -26,32 +70,123 @@ type ClientEvent struct {
 	Reason   string // Optional context about the event
 }
 
-// ManagedClient holds the client context and metadata
+// SessionStats holds running upload totals for a client session
+type SessionStats struct {
+	FilesOK      int64
+	FilesFailed  int64
+	Bytes        int64         // Bytes delivered to R2 by successful uploads
+	UploadTime   time.Duration // Time spent on successful uploads (receive + R2 PUT)
+	LastUploadAt time.Time     // When the last upload finished, successful or not
+	LastError    string        // Error of the most recent failed upload
+
+	ConsecutiveFailures int64 // Failed uploads since the last successful one
+}
+
+// ThroughputMBps returns the average rate of successful uploads
+func (s SessionStats) ThroughputMBps() float64 {
+	if s.UploadTime <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.UploadTime.Seconds() / 1024 / 1024
+}
+
+// SessionSummary describes a session that has ended (logged and optionally reported to the API)
+type SessionSummary struct {
+	ClientID       uint32
+	ClientIP       string
+	Protocol       string
+	EventID        string
+	Token          string // Upload JWT of the session, for reporting; never logged
+	ConnectedAt    time.Time
+	DisconnectedAt time.Time
+	Stats          SessionStats
+}
+
+// Session is a connected client on any frontend (FTP/FTPS client context, SFTP connection)
+type Session interface {
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// UploadProgress is an upload in flight (implemented by transfer.UploadTransfer)
+type UploadProgress interface {
+	Name() string
+	BytesReceived() int64
+	StartedAt() time.Time
+}
+
+// ReplyCloser is a session that can tell its client why it is being closed (FTP control connections)
+type ReplyCloser interface {
+	// CloseWithReply sends the reply and disconnects once the transfer in progress is done
+	CloseWithReply(code int, message string)
+}
+
+// ManagedClient holds the client session and metadata
 type ManagedClient struct {
 	ID           uint32
-	Context      ftpserver.ClientContext
+	Session      Session
 	ClientIP     string
+	Protocol     string // Set at login: ftp, ftps, sftp, tus, webdav
+	EventID      string // Set at login
+	token        string // Set at login
+	ConnectedAt  time.Time
 	UploadCtx    context.Context
 	UploadCancel context.CancelFunc
+	Stats        SessionStats
+	uploads      map[UploadProgress]struct{}
+	maxFileSize  int64  // Per-session override of the largest accepted file (0 = default)
+	degraded     string // Reason a policy marked the session degraded
 }
 
 // Manager centralizes client management and decision-making
-// Upload transfers report events, the manager decides actions
+// Upload transfers report events, the manager's policies decide actions
 type Manager struct {
 	clients   map[uint32]*ManagedClient
 	clientsMu sync.RWMutex
-	eventChan chan ClientEvent
+	lastID    atomic.Uint32 // IDs are assigned here so every frontend shares one ID space
+	reporter  func(SessionSummary)
+	reports   sync.WaitGroup // summary reporters still running, waited for by Stop
+	observer  func(UploadResult)
+	spool     *spool.Spool
+	buffer    *uploadbuf.Dir
+	bandwidth *bandwidth.Shaper
+	scheduler *scheduler.Scheduler
+	maxFile   atomic.Int64     // Largest accepted file in bytes unless a session overrides it (0 = unlimited)
+	eventChan chan ClientEvent // informational events
+	draining  atomic.Bool
+	inFlight  atomic.Int64 // uploads in flight on every frontend, including sessions already unregistered
 	ctx       context.Context
 	cancel    context.CancelFunc
 	wg        sync.WaitGroup
+
+	// Policies decide actions on events (see policy.go)
+	policiesMu sync.RWMutex
+	policies   []Policy
+	webhook    WebhookSink
+	bansMu     sync.Mutex
+	bans       map[string]time.Time // banned host -> end of the ban
+
+	// Coalescing lanes next to eventChan (see SendEvent)
+	pendingMu sync.Mutex
+	critical  map[eventKey]ClientEvent
+	overflow  map[eventKey]ClientEvent
+	wake      chan struct{}
+	dropped   atomic.Int64
 }
 
 // NewManager creates a new client manager
+// Sessions whose upload token expired are disconnected; other policies are added with AddPolicy.
 func NewManager() *Manager {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Manager{
 		clients:   make(map[uint32]*ManagedClient),
 		eventChan: make(chan ClientEvent, 100), // Buffered channel
+		policies:  []Policy{DisconnectOn(EventAuthExpired)},
+		bans:      make(map[string]time.Time),
+		bandwidth: bandwidth.New(bandwidth.Limits{}),
+		critical:  make(map[eventKey]ClientEvent),
+		overflow:  make(map[eventKey]ClientEvent),
+		wake:      make(chan struct{}, 1),
 		ctx:       ctx,
 		cancel:    cancel,
 	}
@@ -64,40 +199,283 @@ func (m *Manager) Start() {
 }
 
 // Stop gracefully shuts down the manager
+// Session summaries still being reported get up to reportWait to finish.
 func (m *Manager) Stop() {
 	m.cancel()
 	m.wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		m.reports.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(reportWait):
+		log.Printf("session_summary_reports_abandoned wait=%s", reportWait)
+	}
 }
 
-// RegisterClient adds a client to the manager
-func (m *Manager) RegisterClient(cc ftpserver.ClientContext) {
+// RegisterClient adds a session to the manager and returns its client ID
+func (m *Manager) RegisterClient(session Session) uint32 {
 	m.clientsMu.Lock()
 	defer m.clientsMu.Unlock()
 
 	uploadCtx, uploadCancel := context.WithCancel(m.ctx)
 
 	client := &ManagedClient{
-		ID:           cc.ID(),
-		Context:      cc,
-		ClientIP:     cc.RemoteAddr().String(),
+		ID:           m.lastID.Add(1),
+		Session:      session,
+		ClientIP:     session.RemoteAddr().String(),
+		ConnectedAt:  time.Now(),
 		UploadCtx:    uploadCtx,
 		UploadCancel: uploadCancel,
+		uploads:      make(map[UploadProgress]struct{}),
 	}
-	m.clients[cc.ID()] = client
+	m.clients[client.ID] = client
 
-	log.Printf("client_registered id=%d ip=%s", cc.ID(), client.ClientIP)
+	log.Printf("client_registered id=%d ip=%s", client.ID, client.ClientIP)
+	return client.ID
+}
+
+// SetSummaryReporter sets a func called (in its own goroutine) with the summary of every
+// authenticated session that ends; Stop waits for the calls still running.
+// Set it before sessions are registered.
+func (m *Manager) SetSummaryReporter(reporter func(SessionSummary)) {
+	m.reporter = reporter
 }
 
 // UnregisterClient removes a client from the manager
+// Authenticated sessions log a session_summary line and are passed to the summary reporter.
 func (m *Manager) UnregisterClient(clientID uint32) {
+	m.clientsMu.Lock()
+	client, exists := m.clients[clientID]
+	if !exists {
+		m.clientsMu.Unlock()
+		return
+	}
+	client.UploadCancel()
+	log.Printf("client_unregistered id=%d ip=%s", clientID, client.ClientIP)
+	delete(m.clients, clientID)
+	m.bandwidth.ForgetSession(clientID)
+	summary := SessionSummary{
+		ClientID:       client.ID,
+		ClientIP:       client.ClientIP,
+		Protocol:       client.Protocol,
+		EventID:        client.EventID,
+		Token:          client.token,
+		ConnectedAt:    client.ConnectedAt,
+		DisconnectedAt: time.Now(),
+		Stats:          client.Stats,
+	}
+	m.clientsMu.Unlock()
+
+	// Connections that never logged in (probes, failed logins) have nothing to summarize
+	if summary.Protocol == "" {
+		return
+	}
+
+	stats := summary.Stats
+	log.Printf("session_summary id=%d ip=%s protocol=%s event=%s duration_s=%.0f files_ok=%d files_failed=%d bytes=%d throughput_mbps=%.2f last_error=%q",
+		summary.ClientID, summary.ClientIP, summary.Protocol, summary.EventID,
+		summary.DisconnectedAt.Sub(summary.ConnectedAt).Seconds(),
+		stats.FilesOK, stats.FilesFailed, stats.Bytes, stats.ThroughputMBps(), stats.LastError)
+
+	if m.reporter != nil {
+		m.reports.Add(1)
+		go func() {
+			defer m.reports.Done()
+			m.reporter(summary)
+		}()
+	}
+}
+
+// SetLogin records the protocol, event and upload token of an authenticated session
+func (m *Manager) SetLogin(clientID uint32, protocol, eventID, token string) {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+
+	if client, exists := m.clients[clientID]; exists {
+		client.Protocol = protocol
+		client.EventID = eventID
+		client.token = token
+	}
+}
+
+// TrackUpload lists an upload as in flight until the returned func is called
+func (m *Manager) TrackUpload(clientID uint32, upload UploadProgress) (done func()) {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+
+	m.inFlight.Add(1)
+	client, exists := m.clients[clientID]
+	if exists {
+		client.uploads[upload] = struct{}{}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.inFlight.Add(-1)
+			if !exists {
+				return
+			}
+			m.clientsMu.Lock()
+			defer m.clientsMu.Unlock()
+			delete(client.uploads, upload)
+		})
+	}
+}
+
+// UploadResult is a finished upload, passed to the upload observer
+type UploadResult struct {
+	ClientID uint32
+	EventID  string
+	Filename string
+	Bytes    int64
+	Duration time.Duration
+	Err      error // nil when the file reached storage
+}
+
+// SetUploadObserver sets a func called with every finished upload (e.g. live progress streams)
+// It runs on the upload's goroutine and must not block. Set it before sessions are registered.
+func (m *Manager) SetUploadObserver(observer func(UploadResult)) {
+	m.observer = observer
+}
+
+// FinishUpload passes a finished upload to the upload observer
+func (m *Manager) FinishUpload(result UploadResult) {
+	if m.observer != nil {
+		m.observer(result)
+	}
+}
+
+// Bandwidth returns the shaper every upload's traffic goes through (unlimited until configured)
+func (m *Manager) Bandwidth() *bandwidth.Shaper {
+	return m.bandwidth
+}
+
+// SetScheduler sets the queue for R2 upload slots (nil = every upload goes at once)
+func (m *Manager) SetScheduler(s *scheduler.Scheduler) {
+	m.scheduler = s
+}
+
+// Scheduler returns the R2 upload queue, or nil
+func (m *Manager) Scheduler() *scheduler.Scheduler {
+	return m.scheduler
+}
+
+// SetSpool sets where uploads cut off by Stop are kept for the next start (nil = they are lost)
+func (m *Manager) SetSpool(s *spool.Spool) {
+	m.spool = s
+}
+
+// Spool returns the shutdown spool, or nil
+func (m *Manager) Spool() *spool.Spool {
+	return m.spool
+}
+
+// SetUploadBuffer sets the directory uploads are buffered in (nil = system temp directory, no space check)
+func (m *Manager) SetUploadBuffer(d *uploadbuf.Dir) {
+	m.buffer = d
+}
+
+// UploadBuffer returns the upload buffer directory (nil-safe, see uploadbuf.Dir)
+func (m *Manager) UploadBuffer() *uploadbuf.Dir {
+	return m.buffer
+}
+
+// SetMaxFileSize sets the largest file a session may upload, in bytes (0 = unlimited)
+func (m *Manager) SetMaxFileSize(bytes int64) {
+	m.maxFile.Store(bytes)
+}
+
+// SetSessionMaxFileSize overrides the largest file for one session (0 restores the default)
+// Uploads already running keep the limit they started with.
+func (m *Manager) SetSessionMaxFileSize(clientID uint32, bytes int64) {
 	m.clientsMu.Lock()
 	defer m.clientsMu.Unlock()
 
 	if client, exists := m.clients[clientID]; exists {
-		client.UploadCancel()
-		log.Printf("client_unregistered id=%d ip=%s", clientID, client.ClientIP)
-		delete(m.clients, clientID)
+		client.maxFileSize = bytes
+	}
+}
+
+// MaxFileSize returns the largest file the session may upload (0 = unlimited)
+// Sessions that are not registered (yet) get the default.
+func (m *Manager) MaxFileSize(clientID uint32) int64 {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+
+	if client, exists := m.clients[clientID]; exists && client.maxFileSize > 0 {
+		return client.maxFileSize
+	}
+	return m.maxFile.Load()
+}
+
+// BeginDrain refuses new uploads on every frontend (ErrDraining); uploads in flight continue
+func (m *Manager) BeginDrain() {
+	m.draining.Store(true)
+}
+
+// CloseWithReply warns every session that supports it (see ReplyCloser) and closes it after its
+// current transfer. Other sessions are left alone. Returns the IDs of the sessions warned.
+func (m *Manager) CloseWithReply(code int, message string) []uint32 {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+
+	var warned []uint32
+	for id, client := range m.clients {
+		if closer, ok := client.Session.(ReplyCloser); ok {
+			closer.CloseWithReply(code, message)
+			warned = append(warned, id)
+		}
+	}
+	sort.Slice(warned, func(i, j int) bool { return warned[i] < warned[j] })
+	return warned
+}
+
+// Draining reports whether BeginDrain was called
+func (m *Manager) Draining() bool {
+	return m.draining.Load()
+}
+
+// Stopped reports whether Stop has cancelled the upload contexts
+func (m *Manager) Stopped() bool {
+	return m.ctx.Err() != nil
+}
+
+// UploadCount returns the number of uploads in flight (receiving data or being sent to storage)
+func (m *Manager) UploadCount() int {
+	return int(m.inFlight.Load())
+}
+
+// WaitForUploads blocks until no upload is in flight or ctx is done
+func (m *Manager) WaitForUploads(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for m.UploadCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
 	}
+	return nil
+}
+
+// WaitForSessions blocks until every client has disconnected or ctx is done
+func (m *Manager) WaitForSessions(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for m.ClientCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 
 // GetUploadContext returns the upload context for a client
@@ -113,31 +491,210 @@ func (m *Manager) GetUploadContext(clientID uint32) (context.Context, bool) {
 	return client.UploadCtx, true
 }
 
+// RecordUpload adds the outcome of a finished upload to the client's session totals
+func (m *Manager) RecordUpload(clientID uint32, bytes int64, duration time.Duration, err error) {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+
+	client, exists := m.clients[clientID]
+	if !exists {
+		return
+	}
+
+	client.Stats.LastUploadAt = time.Now()
+	if err != nil {
+		client.Stats.FilesFailed++
+		client.Stats.ConsecutiveFailures++
+		client.Stats.LastError = err.Error()
+		return
+	}
+	client.Stats.FilesOK++
+	client.Stats.ConsecutiveFailures = 0
+	client.Stats.Bytes += bytes
+	client.Stats.UploadTime += duration
+}
+
+// GetStats returns a snapshot of the client's session totals
+func (m *Manager) GetStats(clientID uint32) (SessionStats, bool) {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+
+	client, exists := m.clients[clientID]
+	if !exists {
+		return SessionStats{}, false
+	}
+
+	return client.Stats, true
+}
+
+// ClientCount returns the number of connected sessions across all frontends
+func (m *Manager) ClientCount() int {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+	return len(m.clients)
+}
+
+// ClientInfo is a read-only snapshot of a connected session
+type ClientInfo struct {
+	ID          uint32
+	ClientIP    string
+	Protocol    string
+	EventID     string
+	ConnectedAt time.Time
+	Stats       SessionStats
+	Uploads     []UploadInfo
+	Degraded    string // Why a policy marked the session degraded (empty = healthy)
+	MaxFileSize int64  // Per-session override of the largest accepted file (0 = default)
+}
+
+// UploadInfo is a snapshot of an upload in flight
+type UploadInfo struct {
+	Filename  string
+	Bytes     int64
+	StartedAt time.Time
+}
+
+// Clients returns a snapshot of every connected session, ordered by ID
+func (m *Manager) Clients() []ClientInfo {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+
+	clients := make([]ClientInfo, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client.info())
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// GetClient returns a snapshot of one session
+func (m *Manager) GetClient(clientID uint32) (ClientInfo, bool) {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+
+	client, exists := m.clients[clientID]
+	if !exists {
+		return ClientInfo{}, false
+	}
+	return client.info(), true
+}
+
+// info snapshots the client; callers hold clientsMu
+func (c *ManagedClient) info() ClientInfo {
+	uploads := make([]UploadInfo, 0, len(c.uploads))
+	for upload := range c.uploads {
+		uploads = append(uploads, UploadInfo{
+			Filename:  upload.Name(),
+			Bytes:     upload.BytesReceived(),
+			StartedAt: upload.StartedAt(),
+		})
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].StartedAt.Before(uploads[j].StartedAt) })
+
+	return ClientInfo{
+		ID:          c.ID,
+		ClientIP:    c.ClientIP,
+		Protocol:    c.Protocol,
+		EventID:     c.EventID,
+		ConnectedAt: c.ConnectedAt,
+		Stats:       c.Stats,
+		Uploads:     uploads,
+		Degraded:    c.degraded,
+		MaxFileSize: c.maxFileSize,
+	}
+}
+
+// eventKey identifies the events of one type for one client, which are coalesced
+type eventKey struct {
+	clientID uint32
+	typ      EventType
+}
+
+// critical reports whether the event can lead to a disconnect regardless of policy state
+// These are never dropped.
+func (t EventType) critical() bool {
+	switch t {
+	case EventAuthExpired, EventKicked, EventEventExpired, EventCreditsExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
 // SendEvent sends an event to the manager for processing
-// This is non-blocking - events are buffered
+// This is non-blocking. Critical events (auth/event expiry, credits, kicks) are coalesced per
+// client and type and always delivered first. Informational events are buffered; when the buffer
+// is full they are coalesced too, and only an older event replaced that way counts as dropped.
+// Policies read the session totals, so a coalesced failure still sees every failed upload.
 func (m *Manager) SendEvent(event ClientEvent) {
+	key := eventKey{clientID: event.ClientID, typ: event.Type}
+
+	if event.Type.critical() {
+		m.pendingMu.Lock()
+		m.critical[key] = event
+		m.pendingMu.Unlock()
+		m.wakeLoop()
+		return
+	}
+
 	select {
 	case m.eventChan <- event:
-		// Event sent successfully
+		return
+	default:
+	}
+
+	m.pendingMu.Lock()
+	_, replaced := m.overflow[key]
+	m.overflow[key] = event
+	m.pendingMu.Unlock()
+	if replaced {
+		m.dropped.Add(1)
+		observability.RecordClientEventDropped(event.Type.String())
+	}
+	m.wakeLoop()
+}
+
+// DroppedEvents returns how many informational events were replaced by a newer one of the same
+// client and type because the event buffer was full
+func (m *Manager) DroppedEvents() int64 {
+	return m.dropped.Load()
+}
+
+func (m *Manager) wakeLoop() {
+	select {
+	case m.wake <- struct{}{}:
 	default:
-		// Channel full, log warning but don't block
-		log.Printf("client_event_dropped reason=channel_full client_id=%d", event.ClientID)
 	}
 }
 
-// EventChan returns the event channel for sending events
-func (m *Manager) EventChan() chan<- ClientEvent {
-	return m.eventChan
+// takePending empties a coalescing lane; callers pass m.critical or m.overflow
+func (m *Manager) takePending(lane *map[eventKey]ClientEvent) []ClientEvent {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+
+	if len(*lane) == 0 {
+		return nil
+	}
+	events := make([]ClientEvent, 0, len(*lane))
+	for _, event := range *lane {
+		events = append(events, event)
+	}
+	*lane = make(map[eventKey]ClientEvent)
+	return events
 }
 
 // run is the main event processing loop (read pump)
-// This is where decisions are made based on events
+// This is where decisions are made based on events; critical events go before the buffer
 func (m *Manager) run() {
 	defer m.wg.Done()
 
 	log.Printf("client_event_loop_started")
 
 	for {
+		for _, event := range m.takePending(&m.critical) {
+			m.handleEvent(event)
+		}
+
 		select {
 		case <-m.ctx.Done():
 			log.Printf("client_event_loop_stopped")
@@ -145,27 +702,29 @@ func (m *Manager) run() {
 
 		case event := <-m.eventChan:
 			m.handleEvent(event)
+
+		case <-m.wake:
+			for _, event := range m.takePending(&m.overflow) {
+				m.handleEvent(event)
+			}
 		}
 	}
 }
 
 // handleEvent processes a single event and decides what action to take
-// All client management decisions are centralized here
+// Operator kicks always disconnect; everything else is up to the registered policies
 func (m *Manager) handleEvent(event ClientEvent) {
 	switch event.Type {
-	case EventAuthExpired:
-		// Decision: Disconnect client when auth expires
-		log.Printf("client_auth_expired client_id=%d action=disconnect", event.ClientID)
-		m.disconnectClient(event.ClientID, "authentication expired")
+	case EventKicked:
+		log.Printf("client_kicked client_id=%d reason=%s action=disconnect", event.ClientID, event.Reason)
+		m.disconnectClient(event.ClientID, event.Reason)
+		return
 
 	case EventUploadFailed:
-		// Decision: Log the failure but keep connection open
-		// Client can retry or upload other files
 		log.Printf("client_upload_failed client_id=%d reason=%s", event.ClientID, event.Reason)
-
-	default:
-		log.Printf("client_event_unknown type=%d client_id=%d", event.Type, event.ClientID)
 	}
+
+	m.evaluatePolicies(event)
 }
 
 // disconnectClient closes the connection for a specific client
@@ -181,7 +740,7 @@ func (m *Manager) disconnectClient(clientID uint32, reason string) {
 
 	log.Printf("client_disconnecting id=%d ip=%s reason=%s", clientID, client.ClientIP, reason)
 
-	if err := client.Context.Close(); err != nil {
+	if err := client.Session.Close(); err != nil {
 		log.Printf("client_disconnect_error id=%d error=%v", clientID, err)
 	}
 }
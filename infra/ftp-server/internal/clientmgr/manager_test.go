@@ -0,0 +1,149 @@
+package clientmgr
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSendEvent_BurstOfFailingUploads floods the event loop from thousands of concurrent
+// failing uploads while a slow policy keeps the buffer full. Every session whose token
+// expired must still be disconnected and every session's failures must reach the policies.
+func TestSendEvent_BurstOfFailingUploads(t *testing.T) {
+	const (
+		clients           = 200
+		uploadsPerClient  = 25 // 5000 uploads
+		expiredEveryNth   = 2  // half of the sessions also see their token expire
+		policyProcessTime = 100 * time.Microsecond
+	)
+
+	m := startManager(t)
+
+	var mu sync.Mutex
+	failuresSeen := make(map[uint32]int64) // highest failure count a policy saw per client
+	m.AddPolicy(policyFunc(func(event ClientEvent, session ClientInfo) []Action {
+		time.Sleep(policyProcessTime)
+		if event.Type == EventUploadFailed {
+			mu.Lock()
+			failuresSeen[session.ID] = max(failuresSeen[session.ID], session.Stats.FilesFailed)
+			mu.Unlock()
+		}
+		return nil
+	}))
+
+	sessions := make([]*fakeSession, clients)
+	ids := make([]uint32, clients)
+	for i := range sessions {
+		sessions[i] = &fakeSession{addr: fmt.Sprintf("192.0.2.%d:40000", i%250+1)}
+		ids[i] = m.RegisterClient(sessions[i])
+	}
+
+	// Same sequence as a failing upload's Close: record the totals, then report the events
+	var wg sync.WaitGroup
+	for i := range ids {
+		for u := 0; u < uploadsPerClient; u++ {
+			wg.Add(1)
+			go func(i, u int) {
+				defer wg.Done()
+				m.RecordUpload(ids[i], 0, time.Millisecond, errors.New("upload failed: r2 unavailable"))
+				if i%expiredEveryNth == 0 && u == uploadsPerClient/2 {
+					m.SendEvent(ClientEvent{Type: EventAuthExpired, ClientID: ids[i], Reason: "authentication expired"})
+				}
+				m.SendEvent(ClientEvent{Type: EventUploadFailed, ClientID: ids[i], Reason: "r2 unavailable"})
+			}(i, u)
+		}
+	}
+	wg.Wait()
+
+	for i, session := range sessions {
+		if i%expiredEveryNth != 0 {
+			continue
+		}
+		waitFor(t, fmt.Sprintf("session %d to be disconnected", ids[i]), session.closed.Load)
+	}
+	for i, session := range sessions {
+		if i%expiredEveryNth != 0 && session.closed.Load() {
+			t.Errorf("Session %d disconnected without its token expiring", ids[i])
+		}
+	}
+
+	// Coalesced failures carry the session totals, so policies still see every failed upload
+	waitFor(t, "every session's failures to reach the policies", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, id := range ids {
+			if failuresSeen[id] != uploadsPerClient {
+				return false
+			}
+		}
+		return true
+	})
+
+	if m.DroppedEvents() == 0 {
+		t.Error("Expected the burst to overflow the buffer and coalesce events")
+	}
+	t.Logf("%d uploads, %d informational events coalesced away", clients*uploadsPerClient, m.DroppedEvents())
+}
+
+func TestSendEvent_CriticalEventsCoalescePerClient(t *testing.T) {
+	// Not started: events stay queued
+	m := NewManager()
+	for i := 0; i < 500; i++ {
+		m.SendEvent(ClientEvent{Type: EventAuthExpired, ClientID: 1})
+		m.SendEvent(ClientEvent{Type: EventKicked, ClientID: 1})
+		m.SendEvent(ClientEvent{Type: EventAuthExpired, ClientID: uint32(i + 2)})
+	}
+	if got := len(m.takePending(&m.critical)); got != 502 {
+		t.Errorf("Pending critical events = %d, want 502 (one per client and type)", got)
+	}
+	if m.DroppedEvents() != 0 {
+		t.Errorf("Critical events counted as dropped: %d", m.DroppedEvents())
+	}
+}
+
+func TestStop_WaitsForSessionSummaries(t *testing.T) {
+	m := NewManager()
+	m.Start()
+
+	reported := make(chan SessionSummary, 1)
+	m.SetSummaryReporter(func(summary SessionSummary) {
+		time.Sleep(50 * time.Millisecond)
+		reported <- summary
+	})
+	id := m.RegisterClient(&fakeSession{addr: "192.0.2.1:40000"})
+	m.SetLogin(id, "ftp", "evt_1", "token")
+	m.UnregisterClient(id)
+	m.Stop()
+
+	select {
+	case summary := <-reported:
+		if summary.ClientID != id {
+			t.Errorf("Reported summary of session %d, want %d", summary.ClientID, id)
+		}
+	default:
+		t.Fatal("Stop returned before the session summary was reported")
+	}
+}
+
+func TestStop_BoundsSessionSummaryWait(t *testing.T) {
+	defer func(wait time.Duration) { reportWait = wait }(reportWait)
+	reportWait = 50 * time.Millisecond
+
+	m := NewManager()
+	m.Start()
+
+	release := make(chan struct{})
+	defer close(release)
+	m.SetSummaryReporter(func(SessionSummary) { <-release })
+	id := m.RegisterClient(&fakeSession{addr: "192.0.2.1:40000"})
+	m.SetLogin(id, "ftp", "evt_1", "token")
+	m.UnregisterClient(id)
+
+	start := time.Now()
+	m.Stop()
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Stop waited %s for a stuck reporter, want about %s", waited, reportWait)
+	}
+}
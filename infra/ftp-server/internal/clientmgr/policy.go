@@ -0,0 +1,207 @@
+package clientmgr
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// ErrBanned refuses sessions and uploads from an address a policy banned
+var ErrBanned = errors.New("address temporarily banned")
+
+// ActionType is what a policy asks the manager to do with a session
+type ActionType int
+
+const (
+	// ActionDisconnect closes the session
+	ActionDisconnect ActionType = iota
+	// ActionThrottle limits the session's upload rate to BytesPerSecond (0 lifts it)
+	ActionThrottle
+	// ActionBanIP refuses new sessions and uploads from the session's IP for Duration
+	ActionBanIP
+	// ActionWebhook passes the event to the webhook sink
+	ActionWebhook
+	// ActionDegrade marks the session degraded (shown by the admin API)
+	ActionDegrade
+)
+
+func (a ActionType) String() string {
+	switch a {
+	case ActionDisconnect:
+		return "disconnect"
+	case ActionThrottle:
+		return "throttle"
+	case ActionBanIP:
+		return "ban_ip"
+	case ActionWebhook:
+		return "webhook"
+	case ActionDegrade:
+		return "degrade"
+	default:
+		return fmt.Sprintf("action_%d", int(a))
+	}
+}
+
+// Action is one decision of a policy
+type Action struct {
+	Type           ActionType
+	Reason         string
+	BytesPerSecond int64         // ActionThrottle
+	Duration       time.Duration // ActionBanIP
+}
+
+// Policy decides what happens to a session when one of its events arrives
+// Policies run on the manager's event loop, in the order they were added, and every
+// returned action is applied. Evaluate must not block.
+type Policy interface {
+	Name() string
+	Evaluate(event ClientEvent, session ClientInfo) []Action
+}
+
+// WebhookSink receives events a policy asked to publish (ActionWebhook)
+type WebhookSink func(event ClientEvent, session ClientInfo, reason string)
+
+// AddPolicy registers a policy; safe while the event loop runs
+func (m *Manager) AddPolicy(policy Policy) {
+	m.policiesMu.Lock()
+	defer m.policiesMu.Unlock()
+	m.policies = append(m.policies, policy)
+}
+
+// SetWebhookSink sets where ActionWebhook events go (nil = logged only)
+func (m *Manager) SetWebhookSink(sink WebhookSink) {
+	m.policiesMu.Lock()
+	defer m.policiesMu.Unlock()
+	m.webhook = sink
+}
+
+// CheckBan returns ErrBanned when a policy banned the address (host or host:port)
+func (m *Manager) CheckBan(addr string) error {
+	host := hostOf(addr)
+
+	m.bansMu.Lock()
+	defer m.bansMu.Unlock()
+
+	until, banned := m.bans[host]
+	if !banned {
+		return nil
+	}
+	if time.Now().After(until) {
+		delete(m.bans, host)
+		return nil
+	}
+	return ErrBanned
+}
+
+// evaluatePolicies runs every policy on the event and applies their actions
+func (m *Manager) evaluatePolicies(event ClientEvent) {
+	session, exists := m.GetClient(event.ClientID)
+	if !exists {
+		return
+	}
+
+	m.policiesMu.RLock()
+	policies := m.policies
+	webhook := m.webhook
+	m.policiesMu.RUnlock()
+
+	for _, policy := range policies {
+		for _, action := range policy.Evaluate(event, session) {
+			log.Printf("client_policy_action policy=%s event=%s client_id=%d action=%s reason=%q",
+				policy.Name(), event.Type, event.ClientID, action.Type, action.Reason)
+			m.applyAction(event, session, action, webhook)
+		}
+	}
+}
+
+func (m *Manager) applyAction(event ClientEvent, session ClientInfo, action Action, webhook WebhookSink) {
+	switch action.Type {
+	case ActionDisconnect:
+		m.disconnectClient(session.ID, action.Reason)
+
+	case ActionThrottle:
+		m.bandwidth.SetSessionLimit(session.ID, action.BytesPerSecond)
+
+	case ActionBanIP:
+		host := hostOf(session.ClientIP)
+		m.bansMu.Lock()
+		m.bans[host] = time.Now().Add(action.Duration)
+		m.bansMu.Unlock()
+		log.Printf("client_ip_banned ip=%s until=%s", host, time.Now().Add(action.Duration).Format(time.RFC3339))
+
+	case ActionWebhook:
+		if webhook == nil {
+			log.Printf("client_policy_webhook_unconfigured client_id=%d event=%s", session.ID, event.Type)
+			return
+		}
+		webhook(event, session, action.Reason)
+
+	case ActionDegrade:
+		m.clientsMu.Lock()
+		if client, exists := m.clients[session.ID]; exists {
+			client.degraded = action.Reason
+		}
+		m.clientsMu.Unlock()
+	}
+}
+
+// hostOf strips the port from a client address
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// DisconnectOn disconnects sessions when one of the event types arrives
+func DisconnectOn(types ...EventType) Policy {
+	return disconnectOn{types: types}
+}
+
+type disconnectOn struct {
+	types []EventType
+}
+
+func (p disconnectOn) Name() string {
+	return "disconnect_on_event"
+}
+
+func (p disconnectOn) Evaluate(event ClientEvent, _ ClientInfo) []Action {
+	for _, t := range p.types {
+		if event.Type == t {
+			return []Action{{Type: ActionDisconnect, Reason: event.Reason}}
+		}
+	}
+	return nil
+}
+
+// ConsecutiveFailures disconnects a session after limit failed uploads in a row, and bans
+// its IP for banFor when banFor is set (a camera stuck resending a file it can't deliver)
+func ConsecutiveFailures(limit int, banFor time.Duration) Policy {
+	return consecutiveFailures{limit: int64(limit), banFor: banFor}
+}
+
+type consecutiveFailures struct {
+	limit  int64
+	banFor time.Duration
+}
+
+func (p consecutiveFailures) Name() string {
+	return "consecutive_failures"
+}
+
+func (p consecutiveFailures) Evaluate(event ClientEvent, session ClientInfo) []Action {
+	if event.Type != EventUploadFailed || session.Stats.ConsecutiveFailures < p.limit {
+		return nil
+	}
+
+	reason := fmt.Sprintf("%d consecutive failed uploads", session.Stats.ConsecutiveFailures)
+	actions := []Action{{Type: ActionDisconnect, Reason: reason}}
+	if p.banFor > 0 {
+		actions = append(actions, Action{Type: ActionBanIP, Reason: reason, Duration: p.banFor})
+	}
+	return actions
+}
@@ -0,0 +1,156 @@
+package clientmgr
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeSession struct {
+	addr   string
+	closed atomic.Bool
+}
+
+func (s *fakeSession) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", s.addr)
+	return addr
+}
+
+func (s *fakeSession) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+// policyFunc adapts a func to Policy
+type policyFunc func(event ClientEvent, session ClientInfo) []Action
+
+func (f policyFunc) Name() string { return "test" }
+
+func (f policyFunc) Evaluate(event ClientEvent, session ClientInfo) []Action {
+	return f(event, session)
+}
+
+func startManager(t *testing.T) *Manager {
+	t.Helper()
+	m := NewManager()
+	m.Start()
+	t.Cleanup(m.Stop)
+	return m
+}
+
+func waitFor(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManager_DisconnectsOnAuthExpiredByDefault(t *testing.T) {
+	m := startManager(t)
+	session := &fakeSession{addr: "192.0.2.1:40000"}
+	id := m.RegisterClient(session)
+
+	m.SendEvent(ClientEvent{Type: EventUploadFailed, ClientID: id, Reason: "network"})
+	m.SendEvent(ClientEvent{Type: EventEventExpired, ClientID: id, Reason: "event expired"})
+	m.SendEvent(ClientEvent{Type: EventUploadSucceeded, ClientID: id})
+	time.Sleep(50 * time.Millisecond)
+	if session.closed.Load() {
+		t.Fatal("Session disconnected without a policy asking for it")
+	}
+
+	m.SendEvent(ClientEvent{Type: EventAuthExpired, ClientID: id, Reason: "authentication expired"})
+	waitFor(t, "disconnect on auth expiry", session.closed.Load)
+}
+
+func TestDisconnectOn_EventEnd(t *testing.T) {
+	m := startManager(t)
+	m.AddPolicy(DisconnectOn(EventEventExpired, EventCreditsExhausted))
+
+	expired := &fakeSession{addr: "192.0.2.1:40000"}
+	broke := &fakeSession{addr: "192.0.2.2:40000"}
+	m.SendEvent(ClientEvent{Type: EventEventExpired, ClientID: m.RegisterClient(expired)})
+	m.SendEvent(ClientEvent{Type: EventCreditsExhausted, ClientID: m.RegisterClient(broke)})
+
+	waitFor(t, "disconnect on event expiry", expired.closed.Load)
+	waitFor(t, "disconnect on exhausted credits", broke.closed.Load)
+}
+
+func TestConsecutiveFailures_DisconnectsAndBans(t *testing.T) {
+	m := startManager(t)
+	m.AddPolicy(ConsecutiveFailures(3, time.Minute))
+
+	session := &fakeSession{addr: "192.0.2.1:40000"}
+	id := m.RegisterClient(session)
+	fail := func() {
+		m.RecordUpload(id, 0, time.Second, errors.New("upload failed: network"))
+		m.SendEvent(ClientEvent{Type: EventUploadFailed, ClientID: id})
+	}
+
+	// A success in between resets the streak
+	fail()
+	fail()
+	m.RecordUpload(id, 100, time.Second, nil)
+	m.SendEvent(ClientEvent{Type: EventUploadSucceeded, ClientID: id})
+	fail()
+	fail()
+	time.Sleep(50 * time.Millisecond)
+	if session.closed.Load() {
+		t.Fatal("Disconnected before 3 failures in a row")
+	}
+
+	fail()
+	waitFor(t, "disconnect after 3 failures in a row", session.closed.Load)
+	waitFor(t, "IP ban", func() bool { return errors.Is(m.CheckBan("192.0.2.1"), ErrBanned) })
+	if err := m.CheckBan("192.0.2.1:51234"); !errors.Is(err, ErrBanned) {
+		t.Errorf("Ban should apply to any port of the host, got %v", err)
+	}
+	if err := m.CheckBan("192.0.2.2:40000"); err != nil {
+		t.Errorf("Other hosts should not be banned, got %v", err)
+	}
+}
+
+func TestManager_AppliesPolicyActions(t *testing.T) {
+	m := startManager(t)
+
+	var webhooks atomic.Int32
+	m.SetWebhookSink(func(event ClientEvent, session ClientInfo, reason string) {
+		if event.Type == EventUploadFailed && reason == "slow storage" {
+			webhooks.Add(1)
+		}
+	})
+	m.AddPolicy(policyFunc(func(event ClientEvent, session ClientInfo) []Action {
+		if event.Type != EventUploadFailed {
+			return nil
+		}
+		return []Action{
+			{Type: ActionThrottle, BytesPerSecond: 1 << 20},
+			{Type: ActionDegrade, Reason: "slow storage"},
+			{Type: ActionWebhook, Reason: "slow storage"},
+			{Type: ActionBanIP, Duration: 10 * time.Millisecond},
+		}
+	}))
+
+	session := &fakeSession{addr: "192.0.2.1:40000"}
+	id := m.RegisterClient(session)
+	m.SendEvent(ClientEvent{Type: EventUploadFailed, ClientID: id})
+
+	waitFor(t, "webhook", func() bool { return webhooks.Load() == 1 })
+	if _, sessions := m.Bandwidth().Overrides(); sessions[id] != 1<<20 {
+		t.Errorf("Throttle not applied to the bandwidth shaper: %v", sessions)
+	}
+	if info, _ := m.GetClient(id); info.Degraded != "slow storage" {
+		t.Errorf("Degraded = %q, want the policy's reason", info.Degraded)
+	}
+	if session.closed.Load() {
+		t.Error("Session disconnected without a disconnect action")
+	}
+
+	// Bans expire
+	waitFor(t, "ban expiry", func() bool { return m.CheckBan("192.0.2.1:40000") == nil })
+}
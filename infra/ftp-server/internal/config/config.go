@@ -3,8 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
-	"strings"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -18,14 +18,131 @@ type Config struct {
 	FTPIdleTimeout      int  // seconds
 	FTPDebug            bool // Enable FTP protocol command/response logging
 
+	// Passive mode behind NAT: address advertised in PASV replies (empty = control connection's local IP)
+	FTPPublicHost        string // Static IPv4, hostname (re-resolved), or "auto" (probe)
+	FTPPublicHostRefresh int    // seconds between hostname/probe refreshes
+	FTPPublicIPProbeURL  string // IP echo service used by FTP_PUBLIC_HOST=auto
+	FTPPassiveAllowAnyIP bool   // Accept data connections from any IP (default: must match control connection)
+	FTPEPSVEnabled       bool   // Answer EPSV; off replies 502 so clients fall back to PASV
+
+	// Active mode (PORT/EPRT) for transmitters without passive support
+	FTPActiveModeEnabled bool // Disabled by default; PORT/EPRT targets must be the control connection's IP
+	FTPActiveSourcePort  int  // Local port of active data connections: 20 (RFC 959) or 0 (any free port)
+	FTPEPRTEnabled       bool // Answer EPRT when active mode is on; off replies 502 so clients fall back to PORT
+
+	// PROXY protocol v1/v2 on the FTP control and implicit FTPS listeners (behind a TCP load balancer)
+	ProxyProtocolTrustedCIDRs string // Comma-separated CIDRs/IPs allowed to send PROXY headers (empty = disabled)
+
+	// Concurrency caps across both FTP listeners (0 = unlimited)
+	FTPMaxSessions         int // Control connections on the whole server
+	FTPMaxSessionsPerIP    int // Control connections from one client IP
+	FTPMaxSessionsPerEvent int // Logged-in sessions for one event
+	FTPMaxUploads          int // In-flight uploads on the whole server
+	FTPMaxUploadsPerIP     int // In-flight uploads from one client IP
+	FTPMaxUploadsPerEvent  int // In-flight uploads for one event
+
+	// Bandwidth shaping on every frontend, bytes per second (0 = unlimited)
+	// Each limit applies to the inbound leg and to the R2 leg separately; the admin API can change them.
+	BandwidthSessionBPS int
+	BandwidthEventBPS   int
+	BandwidthGlobalBPS  int
+
+	// R2 upload scheduling: at most UploadSlots PUTs run at once (0 = no queue), waiting
+	// uploads take turns per event
+	UploadSlots int
+
+	// Upload buffer: files are written to SpoolDir before the R2 PUT (empty = system temp directory)
+	// New uploads get 452 while its filesystem has less than SpoolMinFreeBytes available (0 = no check).
+	SpoolDir          string
+	SpoolMinFreeBytes int
+	MaxFileSize       int // Largest file a session may upload, in bytes (0 = unlimited); the admin API can override it per session
+
+	// Encryption at rest for buffered and spooled uploads (AES-GCM chunks, one key per file)
+	// Keys live in memory unless SpoolEncryptionKey (32 bytes, base64) is set to derive them from,
+	// which SHUTDOWN_SPOOL_DIR and crash recovery need.
+	SpoolEncryption    bool
+	SpoolEncryptionKey string
+
 	// TLS settings (optional)
-	TLSCertPath string
-	TLSKeyPath  string
+	TLSCertPath           string
+	TLSKeyPath            string
+	TLSCertReloadInterval int // seconds between checks for renewed cert/key files
+
+	// TLS profiles: modern, compatible, legacy-camera
+	TLSProfile             string // Default for both listeners
+	FTPTLSProfile          string // Explicit FTPS listener override (empty = TLSProfile)
+	ImplicitFTPSTLSProfile string // Implicit FTPS listener override (empty = TLSProfile)
+
+	// TLS policy of the explicit listener: clear, tls-control, tls-all (implicit FTPS is always tls-all)
+	FTPTLSPolicy string
+
+	// ACME certificate provisioning (optional) - replaces TLS_CERT_PATH/TLS_KEY_PATH
+	ACMEEnabled                bool
+	ACMEHostname               string // Hostname the certificate is issued for (cameras without SNI get this one)
+	ACMEEmail                  string
+	ACMEDirectoryURL           string
+	ACMEDirectoryCAPath        string // Root CA for a private ACME directory (empty = system roots)
+	ACMECacheDir               string // Issued certificates and account key are cached here
+	ACMEChallenge              string // tls-alpn-01 or http-01
+	ACMEChallengeListenAddress string // Defaults to :443 (tls-alpn-01) or :80 (http-01)
+
+	// Client certificate auth (optional) - registered camera devices log in without USER/PASS
+	TLSClientAuthEnabled bool   // Request client certificates on both FTPS listeners
+	TLSClientCAPath      string // CA bundle that device certificates must chain to (empty = fingerprint only)
 
 	// Implicit FTPS settings (optional)
 	ImplicitFTPSEnabled bool   // Enable implicit FTPS server on port 990
 	ImplicitFTPSPort    string // Port for implicit FTPS (default: 0.0.0.0:990)
 
+	// SFTP settings (optional) - same credentials and upload pipeline as FTP
+	SFTPEnabled       bool   // Enable SSH/SFTP listener
+	SFTPListenAddress string // SFTP listen address (default: 0.0.0.0:2222)
+	SFTPHostKeyPath   string // SSH host key, generated on first start if missing (empty = ephemeral)
+
+	// tus resumable HTTP upload endpoint (optional) - for the iOS/desktop apps and tethering tools
+	TUSEnabled       bool
+	TUSListenAddress string // HTTP(S) listen address (default: 0.0.0.0:8080)
+	TUSMaxSize       int    // Largest accepted Upload-Length in bytes
+
+	// WebDAV frontend (optional) - write-only network drive for laptops
+	WebDAVEnabled       bool
+	WebDAVListenAddress string // HTTP(S) listen address (default: 0.0.0.0:8081)
+
+	// Live upload progress over Server-Sent Events (optional) - authorised with the event's FTP JWT
+	ProgressEnabled       bool
+	ProgressListenAddress string // HTTP(S) listen address (default: 0.0.0.0:8082)
+
+	// Admin HTTP API (optional) - list, inspect and disconnect live sessions
+	AdminEnabled       bool
+	AdminListenAddress string // Default 127.0.0.1:8090; other addresses require AdminToken
+	AdminToken         string // Bearer token required on every admin request (empty = localhost only)
+
+	// Post each ended session's totals to the API (/api/ftp/session-summary)
+	SessionSummaryReportEnabled bool
+
+	// Graceful shutdown: in-flight uploads are awaited up to ShutdownTimeout
+	ShutdownTimeout  int    // seconds (default 30)
+	ShutdownSpoolDir string // Keeps uploads still unsent at the deadline for the next start (empty = lost)
+
+	// Graceful restart (SIGUSR2): sessions left on the old process get HandoffTimeout to finish
+	HandoffTimeout int // seconds (default 600)
+
+	// Client manager policies (the token-expired disconnect is always on)
+	PolicyDisconnectOnEventEnd   bool // Disconnect sessions whose event expired or ran out of credits
+	PolicyMaxConsecutiveFailures int  // Disconnect after N failed uploads in a row (0 = off)
+	PolicyFailureBan             int  // seconds the IP is then banned (0 = no ban)
+
+	// Outbound webhooks (signed JSON for upload and session lifecycle events)
+	WebhookURLs        string // Comma-separated receiver URLs (empty = disabled)
+	WebhookSecret      string // HMAC-SHA256 signing key (required with WebhookURLs)
+	WebhookEvents      string // Comma-separated subset of events (empty = all)
+	WebhookQueueSize   int    // Pending deliveries before new ones are dropped (default 1000)
+	WebhookMaxAttempts int    // Attempts per delivery, with exponential backoff (default 5)
+
+	// FTP upload JWT verification for HTTP bearer auth (same secrets as the API)
+	FTPJWTSecret         string
+	FTPJWTSecretPrevious string // Accepted during key rotation
+
 	// API settings - FTP server proxies uploads to this API
 	APIURL string // Base URL for SabaiPics API (e.g., https://api.sabaipics.com)
 
@@ -62,14 +179,114 @@ func Load() (*Config, error) {
 		FTPIdleTimeout:      getEnvInt("FTP_IDLE_TIMEOUT", 300),
 		FTPDebug:            getEnvBool("FTP_DEBUG", false),
 
+		FTPPublicHost:        getEnv("FTP_PUBLIC_HOST", ""),
+		FTPPublicHostRefresh: getEnvInt("FTP_PUBLIC_HOST_REFRESH", 300),
+		FTPPublicIPProbeURL:  getEnv("FTP_PUBLIC_IP_PROBE_URL", "https://checkip.amazonaws.com"),
+		FTPPassiveAllowAnyIP: getEnvBool("FTP_PASSIVE_ALLOW_ANY_IP", false),
+		FTPEPSVEnabled:       getEnvBool("FTP_EPSV_ENABLED", true),
+
+		FTPActiveModeEnabled: getEnvBool("FTP_ACTIVE_MODE_ENABLED", false),
+		FTPActiveSourcePort:  getEnvInt("FTP_ACTIVE_SOURCE_PORT", 0),
+		FTPEPRTEnabled:       getEnvBool("FTP_EPRT_ENABLED", true),
+
+		ProxyProtocolTrustedCIDRs: getEnv("PROXY_PROTOCOL_TRUSTED_CIDRS", ""),
+
+		FTPMaxSessions:         getEnvInt("FTP_MAX_SESSIONS", 0),
+		FTPMaxSessionsPerIP:    getEnvInt("FTP_MAX_SESSIONS_PER_IP", 0),
+		FTPMaxSessionsPerEvent: getEnvInt("FTP_MAX_SESSIONS_PER_EVENT", 0),
+		FTPMaxUploads:          getEnvInt("FTP_MAX_UPLOADS", 0),
+		FTPMaxUploadsPerIP:     getEnvInt("FTP_MAX_UPLOADS_PER_IP", 0),
+		FTPMaxUploadsPerEvent:  getEnvInt("FTP_MAX_UPLOADS_PER_EVENT", 0),
+
+		BandwidthSessionBPS: getEnvInt("BANDWIDTH_SESSION_BPS", 0),
+		BandwidthEventBPS:   getEnvInt("BANDWIDTH_EVENT_BPS", 0),
+		BandwidthGlobalBPS:  getEnvInt("BANDWIDTH_GLOBAL_BPS", 0),
+
+		UploadSlots: getEnvInt("UPLOAD_SLOTS", 0),
+
+		SpoolDir:          getEnv("SPOOL_DIR", ""),
+		SpoolMinFreeBytes: getEnvInt("SPOOL_MIN_FREE_BYTES", 0),
+		MaxFileSize:       getEnvInt("MAX_FILE_SIZE", 0),
+
+		SpoolEncryption:    getEnvBool("SPOOL_ENCRYPTION", true),
+		SpoolEncryptionKey: getEnv("SPOOL_ENCRYPTION_KEY", ""),
+
 		// TLS (optional)
-		TLSCertPath: getEnv("TLS_CERT_PATH", ""),
-		TLSKeyPath:  getEnv("TLS_KEY_PATH", ""),
+		TLSCertPath:           getEnv("TLS_CERT_PATH", ""),
+		TLSKeyPath:            getEnv("TLS_KEY_PATH", ""),
+		TLSCertReloadInterval: getEnvInt("TLS_CERT_RELOAD_INTERVAL", 60),
+
+		// TLS profiles
+		TLSProfile:             getEnv("TLS_PROFILE", "modern"),
+		FTPTLSProfile:          getEnv("FTP_TLS_PROFILE", ""),
+		ImplicitFTPSTLSProfile: getEnv("IMPLICIT_FTPS_TLS_PROFILE", ""),
+
+		// TLS policy
+		FTPTLSPolicy: getEnv("FTP_TLS_POLICY", "clear"),
+
+		// ACME (optional)
+		ACMEEnabled:                getEnvBool("ACME_ENABLED", false),
+		ACMEHostname:               getEnv("ACME_HOSTNAME", ""),
+		ACMEEmail:                  getEnv("ACME_EMAIL", ""),
+		ACMEDirectoryURL:           getEnv("ACME_DIRECTORY_URL", "https://acme-v02.api.letsencrypt.org/directory"),
+		ACMEDirectoryCAPath:        getEnv("ACME_DIRECTORY_CA_PATH", ""),
+		ACMECacheDir:               getEnv("ACME_CACHE_DIR", "acme-cache"),
+		ACMEChallenge:              getEnv("ACME_CHALLENGE", "tls-alpn-01"),
+		ACMEChallengeListenAddress: getEnv("ACME_CHALLENGE_LISTEN_ADDRESS", ""),
+
+		// Client certificate auth (optional)
+		TLSClientAuthEnabled: getEnvBool("TLS_CLIENT_AUTH_ENABLED", false),
+		TLSClientCAPath:      getEnv("TLS_CLIENT_CA_PATH", ""),
 
 		// Implicit FTPS (optional)
 		ImplicitFTPSEnabled: getEnvBool("IMPLICIT_FTPS_ENABLED", true),
 		ImplicitFTPSPort:    getEnv("IMPLICIT_FTPS_PORT", "0.0.0.0:990"),
 
+		// SFTP (optional)
+		SFTPEnabled:       getEnvBool("SFTP_ENABLED", false),
+		SFTPListenAddress: getEnv("SFTP_LISTEN_ADDRESS", "0.0.0.0:2222"),
+		SFTPHostKeyPath:   getEnv("SFTP_HOST_KEY_PATH", "sftp_host_key"),
+
+		// tus (optional)
+		TUSEnabled:       getEnvBool("TUS_ENABLED", false),
+		TUSListenAddress: getEnv("TUS_LISTEN_ADDRESS", "0.0.0.0:8080"),
+		TUSMaxSize:       getEnvInt("TUS_MAX_SIZE", 2<<30),
+
+		// WebDAV (optional)
+		WebDAVEnabled:       getEnvBool("WEBDAV_ENABLED", false),
+		WebDAVListenAddress: getEnv("WEBDAV_LISTEN_ADDRESS", "0.0.0.0:8081"),
+
+		// Upload progress stream (optional)
+		ProgressEnabled:       getEnvBool("PROGRESS_ENABLED", false),
+		ProgressListenAddress: getEnv("PROGRESS_LISTEN_ADDRESS", "0.0.0.0:8082"),
+
+		SessionSummaryReportEnabled: getEnvBool("SESSION_SUMMARY_REPORT_ENABLED", false),
+
+		// Graceful shutdown
+		ShutdownTimeout:  getEnvInt("SHUTDOWN_TIMEOUT", 30),
+		ShutdownSpoolDir: getEnv("SHUTDOWN_SPOOL_DIR", ""),
+		HandoffTimeout:   getEnvInt("HANDOFF_TIMEOUT", 600),
+
+		// Client manager policies
+		PolicyDisconnectOnEventEnd:   getEnvBool("POLICY_DISCONNECT_ON_EVENT_END", false),
+		PolicyMaxConsecutiveFailures: getEnvInt("POLICY_MAX_CONSECUTIVE_FAILURES", 0),
+		PolicyFailureBan:             getEnvInt("POLICY_FAILURE_BAN", 0),
+
+		// Outbound webhooks
+		WebhookURLs:        getEnv("WEBHOOK_URLS", ""),
+		WebhookSecret:      getEnv("WEBHOOK_SECRET", ""),
+		WebhookEvents:      getEnv("WEBHOOK_EVENTS", ""),
+		WebhookQueueSize:   getEnvInt("WEBHOOK_QUEUE_SIZE", 1000),
+		WebhookMaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+
+		// Admin API (optional)
+		AdminEnabled:       getEnvBool("ADMIN_ENABLED", false),
+		AdminListenAddress: getEnv("ADMIN_LISTEN_ADDRESS", "127.0.0.1:8090"),
+		AdminToken:         getEnv("ADMIN_TOKEN", ""),
+
+		FTPJWTSecret:         getEnv("FTP_JWT_SECRET", ""),
+		FTPJWTSecretPrevious: getEnv("FTP_JWT_SECRET_PREVIOUS", ""),
+
 		// API settings
 		APIURL: getEnv("API_URL", ""),
 
@@ -0,0 +1,115 @@
+package driver
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/tlsprofile"
+)
+
+// handshakeWait bounds how long a handshake on a connection we can't watch (active mode data
+// connections, dialled by ftpserverlib) may take before it is logged as failed
+const handshakeWait = 30 * time.Second
+
+// newHandshake records what the client hello offered (the hello itself is only valid during the callback)
+func newHandshake(hello *tls.ClientHelloInfo, listener, profile string) *handshake {
+	return &handshake{
+		clientIP: hello.Conn.RemoteAddr().String(),
+		listener: listener,
+		profile:  profile,
+		versions: tlsprofile.VersionNames(hello.SupportedVersions),
+		ciphers:  tlsprofile.CipherSuiteNames(hello.CipherSuites),
+		sni:      hello.ServerName,
+		started:  time.Now(),
+	}
+}
+
+// handshake is a client hello the profile accepted, logged as failed unless the handshake completes
+type handshake struct {
+	clientIP string
+	listener string
+	profile  string
+	versions string // offered in the client hello
+	ciphers  string
+	sni      string
+	started  time.Time
+	done     atomic.Bool // completed, or already logged as failed
+}
+
+// complete marks the handshake as successful
+func (h *handshake) complete() {
+	h.done.Store(true)
+}
+
+// fail logs the client hello of a handshake that never completed (bad certificate, timeout, alert)
+func (h *handshake) fail(reason string) {
+	if !h.done.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("tls_handshake_failed client=%s listener=%s profile=%s offered_versions=%s offered_ciphers=%s server_name=%q reason=%q waited_ms=%d",
+		h.clientIP, h.listener, h.profile, h.versions, h.ciphers, h.sni, reason, time.Since(h.started).Milliseconds())
+}
+
+// watch reports h as failed if its connection closes first, or after handshakeWait for
+// connections that didn't come through a watched listener
+func (h *handshake) watch(conn net.Conn) {
+	if watched, ok := conn.(*handshakeConn); ok {
+		watched.setPending(h)
+		return
+	}
+	time.AfterFunc(handshakeWait, func() { h.fail("no handshake completion within " + handshakeWait.String()) })
+}
+
+// watchHandshakes wraps a listener under the TLS layer so a connection closed mid-handshake
+// reports the hello it offered
+func watchHandshakes(inner net.Listener) net.Listener {
+	return &handshakeListener{Listener: inner}
+}
+
+type handshakeListener struct {
+	net.Listener
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &handshakeConn{Conn: conn}, nil
+}
+
+// handshakeConn holds the handshake in progress on it (AUTH TLS, implicit TLS or a PROT P data connection)
+type handshakeConn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	pending *handshake
+}
+
+func (c *handshakeConn) setPending(h *handshake) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = h
+}
+
+func (c *handshakeConn) Close() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	if pending != nil {
+		pending.fail("connection closed before the handshake completed")
+	}
+	return c.Conn.Close()
+}
+
+// WrapPassiveListener watches handshakes on passive data connections
+// (implements MainDriverExtensionPassiveWrapper; ftpserverlib adds TLS above it for PROT P)
+func (d *MainDriver) WrapPassiveListener(listener net.Listener) (net.Listener, error) {
+	return watchHandshakes(listener), nil
+}
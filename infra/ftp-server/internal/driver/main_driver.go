@@ -2,9 +2,16 @@ package driver
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"sync"
 	"time"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
@@ -12,8 +19,24 @@ import (
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/client"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/handoff"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/limits"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/proxyproto"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/tlspolicy"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/tlsprofile"
 )
 
+// CertificateSource serves the current TLS certificate (e.g. certmgr.Manager)
+type CertificateSource interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// PublicHostSource supplies the IPv4 advertised in PASV replies (e.g. publichost.Resolver)
+type PublicHostSource interface {
+	PublicIP() (string, error)
+}
+
 // MainDriver implements the ftpserverlib.MainDriver interface
 // Logs application flow events at FTP protocol boundaries
 type MainDriver struct {
@@ -24,15 +47,37 @@ type MainDriver struct {
 	tlsMode ftpserver.TLSRequirement
 	// tlsConfig is an optional TLS config (for testing with self-signed certs)
 	tlsConfig *tls.Config
+	// certs serves the current certificate so renewals apply without a restart (nil = plain FTP)
+	certs CertificateSource
+	// publicHost overrides the PASV address behind NAT (nil = control connection's local IP)
+	publicHost PublicHostSource
+
+	// limiter caps concurrent sessions and uploads, shared with the other FTP listener (nil = unlimited)
+	limiter *limits.Limiter
+
+	// socket is the bare TCP listener under the protocol layers, passed on by graceful restarts
+	socketMu sync.Mutex
+	socket   net.Listener
+
+	// sessionIDs maps each connected client context to its client manager ID
+	sessionIDs sync.Map
+	// eventSlots maps each logged-in client context to the release func of its per-event session slot
+	eventSlots sync.Map
+
+	// The TLS config is built once and shared by every AUTH TLS / implicit handshake
+	tlsOnce   sync.Once
+	tlsResult *tls.Config
+	tlsErr    error
 }
 
 // NewMainDriver creates a new MainDriver instance for explicit FTPS (AUTH TLS)
-func NewMainDriver(cfg *config.Config, clientMgr *clientmgr.Manager) *MainDriver {
+func NewMainDriver(cfg *config.Config, clientMgr *clientmgr.Manager, certs CertificateSource) *MainDriver {
 	return &MainDriver{
 		config:    cfg,
 		apiClient: apiclient.NewClient(cfg.APIURL),
 		clientMgr: clientMgr,
 		tlsMode:   ftpserver.ClearOrEncrypted, // Explicit FTPS (optional TLS via AUTH TLS)
+		certs:     certs,
 	}
 }
 
@@ -58,15 +103,26 @@ func NewMainDriverWithTLS(cfg *config.Config, clientMgr *clientmgr.Manager, apiC
 }
 
 // NewMainDriverImplicit creates a new MainDriver instance for implicit FTPS
-func NewMainDriverImplicit(cfg *config.Config, clientMgr *clientmgr.Manager) *MainDriver {
+func NewMainDriverImplicit(cfg *config.Config, clientMgr *clientmgr.Manager, certs CertificateSource) *MainDriver {
 	return &MainDriver{
 		config:    cfg,
 		apiClient: apiclient.NewClient(cfg.APIURL),
 		clientMgr: clientMgr,
 		tlsMode:   ftpserver.ImplicitEncryption, // Implicit FTPS (immediate TLS)
+		certs:     certs,
 	}
 }
 
+// SetPublicHost sets the address advertised in PASV replies (FTP_PUBLIC_HOST)
+func (d *MainDriver) SetPublicHost(source PublicHostSource) {
+	d.publicHost = source
+}
+
+// SetLimiter sets the concurrency caps (FTP_MAX_*); the limiter is shared by both FTP listeners
+func (d *MainDriver) SetLimiter(limiter *limits.Limiter) {
+	d.limiter = limiter
+}
+
 // GetSettings returns FTP server settings
 func (d *MainDriver) GetSettings() (*ftpserver.Settings, error) {
 	listenAddr := d.config.FTPListenAddress
@@ -76,7 +132,7 @@ func (d *MainDriver) GetSettings() (*ftpserver.Settings, error) {
 		listenAddr = d.config.ImplicitFTPSPort
 	}
 
-	return &ftpserver.Settings{
+	settings := &ftpserver.Settings{
 		ListenAddr: listenAddr,
 		PassiveTransferPortRange: &ftpserver.PortRange{
 			Start: d.config.FTPPassivePortStart,
@@ -84,46 +140,181 @@ func (d *MainDriver) GetSettings() (*ftpserver.Settings, error) {
 		},
 		IdleTimeout: d.config.FTPIdleTimeout,
 		TLSRequired: d.tlsMode, // Set TLS requirement mode
-	}, nil
+	}
+
+	// Behind Docker/cloud NAT the local IP is private; advertise the public one instead
+	if d.publicHost != nil {
+		settings.PublicIPResolver = func(ftpserver.ClientContext) (string, error) {
+			return d.publicHost.PublicIP()
+		}
+	}
+
+	// Clients behind NAT pools may open data connections from a different IP than the control one
+	if d.config.FTPPassiveAllowAnyIP {
+		settings.PasvConnectionsCheck = ftpserver.IPMatchDisabled
+	}
+
+	// Active mode: the server connects back to the client, so PORT/EPRT may only name the
+	// control connection's IP - otherwise a client could aim our data connections at a third host (FXP/bounce)
+	settings.DisableActiveMode = !d.config.FTPActiveModeEnabled
+	settings.ActiveConnectionsCheck = ftpserver.IPMatchRequired
+	settings.ActiveTransferPortNon20 = d.config.FTPActiveSourcePort != 20
+
+	listener, err := d.listener(listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	settings.Listener = listener
+
+	return settings, nil
+}
+
+// listener builds the control listener; we always own the socket so a graceful restart can
+// hand it to the next process (inherited when this process is that next one).
+// Layers, innermost first: PROXY header, session caps, then implicit TLS or the cleartext-login guard,
+// with failed handshakes watched right under the TLS layer.
+func (d *MainDriver) listener(listenAddr string) (net.Listener, error) {
+	guardLogins := d.tlsPolicy().RequiresControlTLS()
+
+	listener, err := handoff.Listen(d.handoffName(), listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	d.socketMu.Lock()
+	d.socket = listener
+	d.socketMu.Unlock()
+
+	// Behind a TCP load balancer the real client address arrives in a PROXY header
+	if d.config.ProxyProtocolTrustedCIDRs != "" {
+		trusted, err := proxyproto.ParseCIDRs(d.config.ProxyProtocolTrustedCIDRs)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		listener = proxyproto.NewListener(listener, trusted)
+	}
+
+	var implicitTLS *tls.Config
+	if d.tlsMode == ftpserver.ImplicitEncryption {
+		implicitTLS, err = d.GetTLSConfig()
+		if err != nil || implicitTLS == nil {
+			listener.Close()
+			return nil, fmt.Errorf("cannot get TLS config for implicit FTPS: %w", err)
+		}
+	}
+
+	// Connections over the server-wide or per-IP session cap get 421 (counted per real client address)
+	if d.limiter != nil {
+		listener = limits.NewListener(listener, d.limiter, implicitTLS)
+	}
+
+	// ftpserverlib only adds implicit TLS to listeners it creates itself
+	if implicitTLS != nil {
+		return tls.NewListener(watchHandshakes(listener), implicitTLS), nil
+	}
+
+	// Refuse cleartext USER/PASS with 534 before the server ever sees them
+	if guardLogins {
+		listener = tlspolicy.NewListener(listener)
+	}
+	// AUTH TLS wraps the connection we return here
+	return watchHandshakes(listener), nil
+}
+
+// tlsPolicy returns the listener's TLS policy (FTP_TLS_POLICY, validated at startup)
+// The policy governs the explicit listener; implicit FTPS is TLS for control and data by itself.
+func (d *MainDriver) tlsPolicy() tlspolicy.Policy {
+	if d.tlsMode == ftpserver.ImplicitEncryption || d.config.FTPTLSPolicy == "" {
+		return tlspolicy.Clear
+	}
+	return tlspolicy.Policy(d.config.FTPTLSPolicy)
 }
 
 // ClientConnected is called when a client connects (application boundary)
 func (d *MainDriver) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 	clientIP := cc.RemoteAddr().String()
-	clientID := cc.ID()
 
 	// Enable FTP protocol debug mode if configured
 	if d.config.FTPDebug {
 		cc.SetDebug(true)
 	}
 
+	// Transfers without PROT P are refused by the server when the policy requires TLS data
+	if d.tlsPolicy().RequiresDataTLS() {
+		if err := cc.SetTLSRequirement(ftpserver.MandatoryEncryption); err != nil {
+			return "", err
+		}
+	}
+
 	// Register client with manager for centralized management
-	d.clientMgr.RegisterClient(cc)
+	clientID := d.clientMgr.RegisterClient(cc)
+	d.sessionIDs.Store(cc, clientID)
 
 	// Log at application boundary (no transaction - uploads create their own)
-	log.Printf("client_connected ip=%s id=%d", clientIP, clientID)
+	observability.EmitLog(context.Background(), "info", "client_connected", map[string]any{
+		"client_ip":  clientIP,
+		"session_id": clientID,
+		"protocol":   "ftp",
+		"listener":   d.listenerName(),
+	})
 
 	return fmt.Sprintf("Welcome to SabaiPics FTP Server (Client: %s)", clientIP), nil
 }
 
+// FilterCommand refuses commands the listener's policy doesn't allow
+// (implements MainDriverExtensionCommandFilter; runs after AUTH TLS, so it sees encrypted sessions too)
+func (d *MainDriver) FilterCommand(cc ftpserver.ClientContext, command, param string) *ftpserver.AnswerCommand {
+	if d.tlsPolicy().RequiresDataTLS() && tlspolicy.OpensDataConnection(command) && !cc.HasTLSForTransfers() {
+		log.Printf("tls_policy_refused client=%s command=%s reply=521", cc.RemoteAddr(), command)
+		return &ftpserver.AnswerCommand{Code: tlspolicy.ReplyDataTLSRequired, Message: "Policy requires a protected data connection: send PBSZ 0 and PROT P first"}
+	}
+	// Some NAT gateways and camera firmwares mishandle the extended commands
+	if command == "EPSV" && !d.config.FTPEPSVEnabled {
+		return &ftpserver.AnswerCommand{Code: ftpserver.StatusCommandNotImplemented, Message: "EPSV is disabled, use PASV"}
+	}
+	if command == "EPRT" && !d.config.FTPEPRTEnabled {
+		return &ftpserver.AnswerCommand{Code: ftpserver.StatusCommandNotImplemented, Message: "EPRT is disabled, use PORT"}
+	}
+	return nil
+}
+
 // ClientDisconnected is called when a client disconnects (application boundary)
 func (d *MainDriver) ClientDisconnected(cc ftpserver.ClientContext) {
-	clientID := cc.ID()
 	clientIP := cc.RemoteAddr().String()
 
 	// Unregister client from manager
+	clientID := d.sessionID(cc)
+	session, _ := d.clientMgr.GetClient(clientID)
+	d.sessionIDs.Delete(cc)
+	d.releaseEventSlot(cc)
 	d.clientMgr.UnregisterClient(clientID)
 
 	// Log at application boundary (no transaction cleanup needed)
-	log.Printf("client_disconnected ip=%s id=%d", clientIP, clientID)
+	observability.EmitLog(context.Background(), "info", "client_disconnected", map[string]any{
+		"client_ip":    clientIP,
+		"session_id":   clientID,
+		"protocol":     "ftp",
+		"event_id":     session.EventID,
+		"files_ok":     session.Stats.FilesOK,
+		"files_failed": session.Stats.FilesFailed,
+		"bytes":        session.Stats.Bytes,
+	})
 }
 
 // AuthUser validates FTP credentials via API and returns ClientDriver with JWT token
+// Sessions already authenticated by a client certificate skip the password check
 func (d *MainDriver) AuthUser(cc ftpserver.ClientContext, user, pass string) (ftpserver.ClientDriver, error) {
 	clientIP := cc.RemoteAddr().String()
+	secure := cc.HasTLSForControl()
+
+	// Cameras often send PASS even after USER was accepted via certificate
+	if clientDriver, ok := cc.Extra().(*client.ClientDriver); ok && clientDriver.CertificateFingerprint() != "" {
+		log.Printf("auth_ok user=%s client=%s method=certificate pass_ignored=true", user, clientIP)
+		return clientDriver, nil
+	}
 
 	// Log auth attempt at application boundary
-	log.Printf("auth_attempt user=%s client=%s", user, clientIP)
+	log.Printf("auth_attempt user=%s client=%s tls=%t", user, clientIP, secure)
 
 	// Create context with timeout for auth request
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -135,54 +326,292 @@ func (d *MainDriver) AuthUser(cc ftpserver.ClientContext, user, pass string) (ft
 		Password: pass,
 	})
 	if err != nil {
-		log.Printf("auth_failed user=%s client=%s error=%v", user, clientIP, err)
+		observability.EmitLog(ctx, "error", "auth_failed", map[string]any{
+			"user":      user,
+			"client_ip": clientIP,
+			"protocol":  "ftp",
+			"tls":       secure,
+			"error":     err.Error(),
+		})
 		return nil, fmt.Errorf("authentication failed") // FTP 530 response
 	}
 
-	log.Printf("auth_ok user=%s event=%s credits=%d",
-		user, authResp.EventID, authResp.CreditsRemaining)
+	// ftpserverlib closes the connection on every AuthUser error; the cap's LimitError replies 421
+	if err := d.acquireEventSlot(cc, authResp.EventID); err != nil {
+		log.Printf("auth_refused user=%s client=%s event=%s error=%v", user, clientIP, authResp.EventID, err)
+		return nil, err
+	}
+
+	log.Printf("auth_ok user=%s event=%s credits=%d tls=%t",
+		user, authResp.EventID, authResp.CreditsRemaining, secure)
+
+	// Track cameras still logging in over cleartext so they can be migrated
+	if !secure {
+		log.Printf("auth_cleartext user=%s client=%s event=%s listener=%s policy=%s",
+			user, clientIP, authResp.EventID, d.listenerName(), d.tlsPolicy())
+		observability.RecordCleartextSession(d.listenerName())
+	}
+
+	return d.newSessionDriver(cc, authResp), nil
+}
+
+// VerifyConnection authenticates registered camera devices by client certificate
+// (implements MainDriverExtensionTLSVerifier, called on USER once the control connection is TLS)
+// Returning nil, nil falls back to USER/PASS authentication
+func (d *MainDriver) VerifyConnection(cc ftpserver.ClientContext, user string, tlsConn *tls.Conn) (ftpserver.ClientDriver, error) {
+	if !d.config.TLSClientAuthEnabled {
+		return nil, nil
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, nil
+	}
+
+	clientIP := cc.RemoteAddr().String()
+	fingerprint := certificateFingerprint(state.PeerCertificates[0])
+
+	log.Printf("cert_auth_attempt user=%s client=%s fingerprint=%s", user, clientIP, fingerprint)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	authResp, err := d.apiClient.AuthenticateCertificate(ctx, apiclient.CertificateAuthRequest{
+		Fingerprint: fingerprint,
+	})
+	if err != nil {
+		// Unregistered certificates fall back to USER/PASS
+		log.Printf("cert_auth_failed user=%s client=%s fingerprint=%s error=%v", user, clientIP, fingerprint, err)
+		return nil, nil
+	}
+
+	if err := d.acquireEventSlot(cc, authResp.EventID); err != nil {
+		log.Printf("auth_refused user=%s client=%s event=%s method=certificate error=%v", user, clientIP, authResp.EventID, err)
+		return nil, err
+	}
+
+	log.Printf("auth_ok user=%s event=%s credits=%d method=certificate fingerprint=%s",
+		user, authResp.EventID, authResp.CreditsRemaining, fingerprint)
+
+	clientDriver := d.newSessionDriver(cc, authResp)
+	clientDriver.SetCertificateFingerprint(fingerprint)
+	return clientDriver, nil
+}
+
+// newSessionDriver creates the ClientDriver for an authenticated session
+func (d *MainDriver) newSessionDriver(cc ftpserver.ClientContext, authResp *apiclient.AuthResponse) *client.ClientDriver {
+	protocol := "ftp"
+	if cc.HasTLSForControl() {
+		protocol = "ftps"
+	}
+	d.clientMgr.SetLogin(d.sessionID(cc), protocol, authResp.EventID, authResp.Token)
 
 	// Create ClientDriver with JWT token, client manager (for event reporting), and API client
 	clientDriver := client.NewClientDriver(
-		authResp.EventID,
-		authResp.Token,
-		clientIP,
-		cc.ID(), // Pass client ID for event reporting
+		authResp,
+		cc.RemoteAddr().String(),
+		d.sessionID(cc), // Pass client ID for event reporting
 		d.clientMgr,
 		d.apiClient,
 		d.config,
 	)
 
-	return clientDriver, nil
+	clientDriver.SetLimiter(d.limiter)
+
+	// Keep the session driver on the client context for PostAuthMessage and PASS after cert auth
+	cc.SetExtra(clientDriver)
+
+	return clientDriver
+}
+
+// acquireEventSlot counts the session against its event's cap (FTP_MAX_SESSIONS_PER_EVENT)
+// A second login on the same connection gives up the slot of the first.
+func (d *MainDriver) acquireEventSlot(cc ftpserver.ClientContext, eventID string) error {
+	if d.limiter == nil {
+		return nil
+	}
+	d.releaseEventSlot(cc)
+	release, err := d.limiter.AcquireEventSession(eventID)
+	if err != nil {
+		return err
+	}
+	d.eventSlots.Store(cc, release)
+	return nil
+}
+
+func (d *MainDriver) releaseEventSlot(cc ftpserver.ClientContext) {
+	if release, ok := d.eventSlots.LoadAndDelete(cc); ok {
+		release.(func())()
+	}
+}
+
+// sessionID returns the client manager ID assigned in ClientConnected
+func (d *MainDriver) sessionID(cc ftpserver.ClientContext) uint32 {
+	if id, ok := d.sessionIDs.Load(cc); ok {
+		return id.(uint32)
+	}
+	return 0
+}
+
+// certificateFingerprint returns the lowercase hex SHA-256 of the certificate DER
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// PostAuthMessage returns the reply sent after PASS (implements MainDriverExtensionPostAuthMessage)
+// On success it names the bound event so photographers can confirm the camera setup
+func (d *MainDriver) PostAuthMessage(cc ftpserver.ClientContext, user string, authErr error) string {
+	if authErr != nil {
+		return ""
+	}
+	if clientDriver, ok := cc.Extra().(*client.ClientDriver); ok {
+		return clientDriver.WelcomeMessage()
+	}
+	return ""
 }
 
 // GetTLSConfig returns TLS configuration for FTPS
 func (d *MainDriver) GetTLSConfig() (*tls.Config, error) {
-	// If custom TLS config is provided (for testing), use it
-	if d.tlsConfig != nil {
-		return d.tlsConfig, nil
-	}
+	d.tlsOnce.Do(func() {
+		d.tlsResult, d.tlsErr = d.buildTLSConfig()
+	})
+	return d.tlsResult, d.tlsErr
+}
 
-	// If TLS cert/key paths are not configured, return nil (plain FTP)
-	if d.config.TLSCertPath == "" || d.config.TLSKeyPath == "" {
+func (d *MainDriver) buildTLSConfig() (*tls.Config, error) {
+	var base *tls.Config
+	switch {
+	case d.tlsConfig != nil:
+		// If custom TLS config is provided (for testing), use it
+		base = d.tlsConfig
+	case d.certs != nil:
+		// The certificate is looked up per handshake so renewals apply without a restart
+		base = &tls.Config{GetCertificate: d.certs.GetCertificate}
+	default:
+		// If no certificate is configured, return nil (plain FTP)
 		return nil, nil
 	}
 
-	// Load TLS certificate and private key
-	cert, err := tls.LoadX509KeyPair(d.config.TLSCertPath, d.config.TLSKeyPath)
+	profile, err := tlsprofile.Lookup(d.tlsProfileName())
 	if err != nil {
-		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
-	}
-
-	// Return TLS configuration for FTPS (explicit mode - AUTH TLS)
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS12, // Require TLS 1.2 or higher
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		},
-	}, nil
+		return nil, err
+	}
+
+	tlsConfig, err := d.withClientAuth(profile.Apply(base))
+	if err != nil {
+		return nil, err
+	}
+	return d.withHandshakeLogging(tlsConfig, profile), nil
+}
+
+// tlsProfileName returns the listener's TLS profile, falling back to the global TLS_PROFILE
+func (d *MainDriver) tlsProfileName() string {
+	if d.tlsMode == ftpserver.ImplicitEncryption && d.config.ImplicitFTPSTLSProfile != "" {
+		return d.config.ImplicitFTPSTLSProfile
+	}
+	if d.tlsMode != ftpserver.ImplicitEncryption && d.config.FTPTLSProfile != "" {
+		return d.config.FTPTLSProfile
+	}
+	if d.config.TLSProfile != "" {
+		return d.config.TLSProfile
+	}
+	return tlsprofile.Modern
+}
+
+// ListenerFile returns a duplicate of the control socket for a graceful restart
+// Only valid once the server is listening.
+func (d *MainDriver) ListenerFile() (string, *os.File, error) {
+	d.socketMu.Lock()
+	socket := d.socket
+	d.socketMu.Unlock()
+	if socket == nil {
+		return d.handoffName(), nil, errors.New("listener not started")
+	}
+	file, err := handoff.File(socket)
+	return d.handoffName(), file, err
+}
+
+// handoffName identifies the control socket between restarted processes
+func (d *MainDriver) handoffName() string {
+	if d.tlsMode == ftpserver.ImplicitEncryption {
+		return "ftps-implicit"
+	}
+	return "ftp"
+}
+
+// listenerName labels log lines with the listener the handshake arrived on
+func (d *MainDriver) listenerName() string {
+	if d.tlsMode == ftpserver.ImplicitEncryption {
+		return "implicit"
+	}
+	return "explicit"
+}
+
+// withHandshakeLogging logs the negotiated version and suite of every handshake, and the
+// client hello of every handshake that fails: cameras the profile can't serve (with the profile
+// they need) as well as bad certificates, alerts and timeouts
+func (d *MainDriver) withHandshakeLogging(tlsConfig *tls.Config, profile tlsprofile.Profile) *tls.Config {
+	listener := d.listenerName()
+
+	logged := tlsConfig.Clone()
+	logged.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		clientIP := hello.Conn.RemoteAddr().String()
+
+		if !profile.Accepts(hello) {
+			suggested := tlsprofile.Suggest(hello)
+			if suggested == "" {
+				suggested = "none"
+			}
+			log.Printf("tls_handshake_incompatible client=%s listener=%s profile=%s offered_versions=%s offered_ciphers=%s suggested_profile=%s",
+				clientIP, listener, profile.Name,
+				tlsprofile.VersionNames(hello.SupportedVersions),
+				tlsprofile.CipherSuiteNames(hello.CipherSuites),
+				suggested)
+			// Let the handshake fail with the usual alert
+			return nil, nil
+		}
+
+		pending := newHandshake(hello, listener, profile.Name)
+		pending.watch(hello.Conn)
+
+		perConn := tlsConfig.Clone()
+		perConn.VerifyConnection = func(state tls.ConnectionState) error {
+			pending.complete()
+			log.Printf("tls_handshake_ok client=%s listener=%s profile=%s version=%s cipher=%s",
+				clientIP, listener, profile.Name,
+				tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+			return nil
+		}
+		return perConn, nil
+	}
+	return logged
+}
+
+// withClientAuth enables optional client certificates when device auth is configured
+// Certificates are requested but never required, so USER/PASS cameras keep working
+func (d *MainDriver) withClientAuth(base *tls.Config) (*tls.Config, error) {
+	if !d.config.TLSClientAuthEnabled {
+		return base, nil
+	}
+
+	tlsConfig := base.Clone()
+	if d.config.TLSClientCAPath == "" {
+		// No CA: accept any certificate, the fingerprint registry is the trust anchor
+		tlsConfig.ClientAuth = tls.RequestClientCert
+		return tlsConfig, nil
+	}
+
+	caPEM, err := os.ReadFile(d.config.TLSClientCAPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in TLS client CA %s", d.config.TLSClientCAPath)
+	}
+
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	tlsConfig.ClientCAs = pool
+	return tlsConfig, nil
 }
@@ -0,0 +1,193 @@
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// envListenFDs names the listeners a restarted process inherits, e.g. "ftp=3,ftps-implicit=4"
+const envListenFDs = "SABAIPICS_LISTEN_FDS"
+
+// envSupervisorFD is the pipe a restarted process reports its own successor on, so a supervising
+// ancestor (see Supervise) learns which process serves next
+const envSupervisorFD = "SABAIPICS_SUPERVISOR_FD"
+
+// bindRetry is how long a restarted process retries addresses its parent is still releasing
+const bindRetry = 15 * time.Second
+
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   map[string]*os.File
+	isChild     bool
+	successorW  *os.File // successor reports go here: inherited, or the write end of successorR
+	successorR  *os.File // read by Supervise; nil when the pipe was inherited
+)
+
+// loadInherited reads the descriptors passed by the parent (once per process)
+func loadInherited() {
+	inheritOnce.Do(func() {
+		inherited = make(map[string]*os.File)
+		if fdText, ok := os.LookupEnv(envSupervisorFD); ok {
+			os.Unsetenv(envSupervisorFD)
+			if fd, err := strconv.Atoi(fdText); err == nil && fd >= 3 {
+				successorW = os.NewFile(uintptr(fd), "supervisor")
+			} else {
+				log.Printf("handoff_fd_invalid entry=%q", fdText)
+			}
+		}
+
+		spec, ok := os.LookupEnv(envListenFDs)
+		if !ok {
+			return
+		}
+		isChild = true
+		// Our own children get a fresh list
+		os.Unsetenv(envListenFDs)
+
+		for _, pair := range strings.Split(spec, ",") {
+			name, fdText, found := strings.Cut(pair, "=")
+			fd, err := strconv.Atoi(fdText)
+			if !found || err != nil || fd < 3 {
+				log.Printf("handoff_fd_invalid entry=%q", pair)
+				continue
+			}
+			inherited[name] = os.NewFile(uintptr(fd), name)
+		}
+	})
+}
+
+// IsRestartSignal reports whether sig asks for a graceful restart (SIGUSR2; none outside Unix)
+func IsRestartSignal(sig os.Signal) bool {
+	return slices.Contains(RestartSignals, sig)
+}
+
+// IsChild reports whether this process was started by a graceful restart
+func IsChild() bool {
+	loadInherited()
+	return isChild
+}
+
+// Listen returns the listener named name that the previous process handed over, or binds addr.
+// A restarted process retries addresses its parent may still hold (listeners the parent did
+// not pass on, e.g. after the new configuration enabled a frontend on another address).
+func Listen(name, addr string) (net.Listener, error) {
+	loadInherited()
+
+	inheritMu.Lock()
+	file, ok := inherited[name]
+	delete(inherited, name)
+	inheritMu.Unlock()
+
+	if ok {
+		listener, err := net.FileListener(file)
+		file.Close() // FileListener dups the descriptor
+		if err != nil {
+			return nil, fmt.Errorf("failed to use inherited %s listener: %w", name, err)
+		}
+		log.Printf("handoff_listener_inherited name=%s addr=%s", name, listener.Addr())
+		return listener, nil
+	}
+
+	deadline := time.Now().Add(bindRetry)
+	for {
+		listener, err := net.Listen("tcp", addr)
+		if err == nil || !isChild || !errors.Is(err, syscall.EADDRINUSE) || time.Now().After(deadline) {
+			return listener, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// File returns a descriptor for passing a listener to Spawn
+func File(listener net.Listener) (*os.File, error) {
+	tcpListener, ok := listener.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("cannot hand off %T listener", listener)
+	}
+	return tcpListener.File()
+}
+
+// Spawn starts argv (normally os.Args) as a child that inherits the named listeners
+// The child shares stdout/stderr; the files can be closed once Spawn returns.
+func Spawn(argv []string, listeners map[string]*os.File) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find own executable: %w", err)
+	}
+
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	specs := make([]string, 0, len(names))
+	for _, name := range names {
+		specs = append(specs, fmt.Sprintf("%s=%d", name, len(files)))
+		files = append(files, listeners[name])
+	}
+
+	reports, err := successorPipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create supervisor pipe: %w", err)
+	}
+	supervisorFD := len(files)
+	files = append(files, reports)
+
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envListenFDs+"=") && !strings.HasPrefix(kv, envSupervisorFD+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, envListenFDs+"="+strings.Join(specs, ","), fmt.Sprintf("%s=%d", envSupervisorFD, supervisorFD))
+
+	child, err := os.StartProcess(executable, argv, &os.ProcAttr{Env: env, Files: files})
+	if err != nil {
+		return nil, err
+	}
+	reportSuccessor(child.Pid)
+	return child, nil
+}
+
+// successorPipe returns the pipe successors are reported on, creating it in the first process
+func successorPipe() (*os.File, error) {
+	loadInherited()
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	if successorW == nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		successorR, successorW = r, w
+	}
+	return successorW, nil
+}
+
+// reportSuccessor tells a supervising ancestor that child takes over from this process
+// The first process needs no report: Supervise gets its child from Spawn directly. Without a
+// supervising ancestor nobody reads the pipe, and once its first process is gone writes fail with EPIPE.
+func reportSuccessor(child int) {
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	if successorR != nil {
+		return
+	}
+	if _, err := fmt.Fprintf(successorW, "%d %d\n", os.Getpid(), child); err != nil && !errors.Is(err, syscall.EPIPE) {
+		log.Printf("handoff_successor_report_failed pid=%d error=%v", child, err)
+	}
+}
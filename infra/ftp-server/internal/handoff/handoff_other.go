@@ -0,0 +1,21 @@
+//go:build !unix
+
+package handoff
+
+import (
+	"log"
+	"os"
+)
+
+// RestartSignals is empty: graceful restart passes listening sockets by descriptor, which needs Unix
+var RestartSignals []os.Signal
+
+// Supervise waits for the server and exits with its status
+func Supervise(child *os.Process) {
+	state, err := child.Wait()
+	if err != nil {
+		log.Printf("handoff_wait_failed error=%v", err)
+		os.Exit(1)
+	}
+	os.Exit(state.ExitCode())
+}
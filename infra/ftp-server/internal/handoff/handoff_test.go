@@ -0,0 +1,174 @@
+//go:build unix
+
+package handoff
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// resetInherited makes the next Listen read the environment again
+func resetInherited() {
+	inheritOnce = sync.Once{}
+	inherited = nil
+	isChild = false
+}
+
+func TestListen_InheritsNamedListener(t *testing.T) {
+	original, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer original.Close()
+	file, err := File(original)
+	if err != nil {
+		t.Fatalf("Failed to get listener file: %v", err)
+	}
+
+	// Listen takes ownership of the descriptor, as it would in a real child
+	fd, err := syscall.Dup(int(file.Fd()))
+	file.Close()
+	if err != nil {
+		t.Fatalf("Failed to dup listener: %v", err)
+	}
+
+	t.Setenv(envListenFDs, fmt.Sprintf("ftp=%d", fd))
+	resetInherited()
+	defer resetInherited()
+
+	listener, err := Listen("ftp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	if listener.Addr().String() != original.Addr().String() {
+		t.Errorf("Inherited listener on %s, want %s", listener.Addr(), original.Addr())
+	}
+	if !IsChild() {
+		t.Error("Process with inherited listeners should report IsChild")
+	}
+
+	// Names that weren't handed over bind normally
+	fresh, err := Listen("tus", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Fresh listen failed: %v", err)
+	}
+	fresh.Close()
+}
+
+func TestSpawn_ChildAcceptsOnHandedOffListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	file, err := File(listener)
+	if err != nil {
+		t.Fatalf("Failed to get listener file: %v", err)
+	}
+
+	t.Setenv("HANDOFF_TEST_CHILD", "1")
+	child, err := Spawn([]string{os.Args[0], "-test.run=^TestHelperChild$"}, map[string]*os.File{"ftp": file})
+	file.Close()
+	if err != nil {
+		t.Fatalf("Spawn failed: %v", err)
+	}
+
+	// The parent stops accepting; connections now reach the child
+	addr := listener.Addr().String()
+	listener.Close()
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Dial after handoff failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "child\n" {
+		t.Errorf("Read %q (%v), want the child's greeting", line, err)
+	}
+
+	state, err := child.Wait()
+	if err != nil || !state.Success() {
+		t.Errorf("Child exited with %v (%v)", state, err)
+	}
+}
+
+func TestSpawn_ReportsSuccessorToSupervisor(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	file, err := File(listener)
+	if err != nil {
+		t.Fatalf("Failed to get listener file: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	// The child restarts once more, as on a second SIGUSR2, then exits
+	t.Setenv("HANDOFF_TEST_CHILD", "relay")
+	child, err := Spawn([]string{os.Args[0], "-test.run=^TestHelperChild$"}, map[string]*os.File{"ftp": file})
+	file.Close()
+	if err != nil {
+		t.Fatalf("Spawn failed: %v", err)
+	}
+	if state, err := child.Wait(); err != nil || !state.Success() {
+		t.Fatalf("Relay child exited with %v (%v)", state, err)
+	}
+
+	// Like Supervise after the child exits: the grandchild's pid comes from the pipe
+	inheritMu.Lock()
+	reports := &successors{pipe: successorR, next: make(map[int]int)}
+	inheritMu.Unlock()
+	next := reports.of(child.Pid)
+	if next == 0 || next == child.Pid {
+		t.Fatalf("Successor of %d = %d, want the grandchild's pid", child.Pid, next)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Dial after the second handoff failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "child\n" {
+		t.Errorf("Read %q (%v), want the grandchild's greeting", line, err)
+	}
+}
+
+// TestHelperChild is the process started by the Spawn tests; as "relay" it hands its listener on
+func TestHelperChild(t *testing.T) {
+	role := os.Getenv("HANDOFF_TEST_CHILD")
+	if role != "1" && role != "relay" {
+		t.Skip("only runs as a spawned child")
+	}
+	listener, err := Listen("ftp", "127.0.0.1:1")
+	if err != nil {
+		os.Exit(2)
+	}
+	if role == "relay" {
+		file, err := File(listener)
+		if err != nil {
+			os.Exit(4)
+		}
+		os.Setenv("HANDOFF_TEST_CHILD", "1")
+		if _, err := Spawn(os.Args, map[string]*os.File{"ftp": file}); err != nil {
+			os.Exit(5)
+		}
+		os.Exit(0)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		os.Exit(3)
+	}
+	conn.Write([]byte("child\n"))
+	conn.Close()
+	os.Exit(0)
+}
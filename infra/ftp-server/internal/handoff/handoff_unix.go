@@ -0,0 +1,110 @@
+//go:build unix
+
+package handoff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// RestartSignals ask a running server for a graceful restart
+var RestartSignals = []os.Signal{syscall.SIGUSR2}
+
+// successorWait bounds reading the successor reports once the serving process exited
+// They are written long before it exits, so they are already in the pipe.
+const successorWait = 100 * time.Millisecond
+
+// Supervise keeps the parent alive as the server's supervisor: signals are forwarded and the
+// process exits with the server's status. Used when the parent is PID 1 (a container), where
+// exiting would take the child down with it. A later restart's process is re-parented to us
+// when its parent finishes; the parent reported it on the supervisor pipe (see Spawn), so it
+// is supervised in turn.
+func Supervise(child *os.Process) {
+	var current atomic.Int64
+	current.Store(int64(child.Pid))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			pid := int(current.Load())
+			if err := syscall.Kill(pid, sig.(syscall.Signal)); err != nil {
+				log.Printf("handoff_signal_forward_failed pid=%d signal=%v error=%v", pid, sig, err)
+			}
+		}
+	}()
+
+	inheritMu.Lock()
+	reports := &successors{pipe: successorR, next: make(map[int]int)}
+	inheritMu.Unlock()
+
+	log.Printf("handoff_supervising pid=%d", child.Pid)
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			log.Printf("handoff_wait_failed error=%v", err)
+			os.Exit(1)
+		}
+		if pid != int(current.Load()) {
+			continue
+		}
+
+		// Follow the chain of restarts to the process serving now (one may have exited already)
+		next := reports.of(pid)
+		for next != 0 && syscall.Kill(next, 0) != nil {
+			next = reports.of(next)
+		}
+		if next == 0 {
+			os.Exit(status.ExitStatus())
+		}
+		log.Printf("handoff_supervising pid=%d", next)
+		current.Store(int64(next))
+	}
+}
+
+// successors reads the "parent child" lines restarted processes write to the supervisor pipe
+type successors struct {
+	pipe    *os.File // nil when this process never spawned (nothing to read)
+	pending []byte
+	next    map[int]int
+}
+
+// of returns the process that took over from parent (0 = none was reported)
+func (s *successors) of(parent int) int {
+	if s.pipe != nil {
+		s.pipe.SetReadDeadline(time.Now().Add(successorWait))
+		buf := make([]byte, 512)
+		for {
+			n, err := s.pipe.Read(buf)
+			s.pending = append(s.pending, buf[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		for {
+			line, rest, ok := bytes.Cut(s.pending, []byte("\n"))
+			if !ok {
+				break
+			}
+			s.pending = rest
+			var from, to int
+			if _, err := fmt.Sscan(string(line), &from, &to); err != nil {
+				log.Printf("handoff_successor_invalid line=%q", line)
+				continue
+			}
+			s.next[from] = to
+		}
+	}
+	return s.next[parent]
+}
@@ -0,0 +1,283 @@
+package httpauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+)
+
+// tokenAudience is the audience of FTP upload JWTs issued by the API
+const tokenAudience = "ftp-upload"
+
+// basicAuthCacheTTL is how long a verified username/password is reused without calling the API
+// Resumable clients send credentials on every request, so this avoids one auth call per chunk.
+const basicAuthCacheTTL = 5 * time.Minute
+
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+)
+
+// Authenticator verifies HTTP upload requests with FTP event credentials or an FTP upload JWT
+// Basic auth is checked against the API like an FTP login; bearer tokens are verified locally
+// with FTP_JWT_SECRET (and FTP_JWT_SECRET_PREVIOUS during key rotation).
+type Authenticator struct {
+	apiClient apiclient.APIClient
+	secrets   [][]byte
+	now       func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cachedAuth
+}
+
+type cachedAuth struct {
+	resp    *apiclient.AuthResponse
+	expires time.Time
+}
+
+// New creates an Authenticator
+// Bearer tokens are rejected when no JWT secret is configured.
+func New(cfg *config.Config, apiClient apiclient.APIClient) *Authenticator {
+	var secrets [][]byte
+	for _, secret := range []string{cfg.FTPJWTSecret, cfg.FTPJWTSecretPrevious} {
+		if secret != "" {
+			secrets = append(secrets, []byte(secret))
+		}
+	}
+
+	return &Authenticator{
+		apiClient: apiClient,
+		secrets:   secrets,
+		now:       time.Now,
+		cache:     make(map[[sha256.Size]byte]cachedAuth),
+	}
+}
+
+// Authenticate resolves the request's Authorization header to an event session
+// protocol is used in log lines (e.g. "tus", "webdav").
+func (a *Authenticator) Authenticate(r *http.Request, protocol string) (*apiclient.AuthResponse, error) {
+	clientIP := r.RemoteAddr
+
+	if user, pass, ok := r.BasicAuth(); ok {
+		return a.authenticateBasic(r.Context(), user, pass, clientIP, protocol)
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrMissingCredentials
+	}
+
+	claims, err := a.verifyToken(strings.TrimSpace(token))
+	if err != nil {
+		observability.EmitLog(r.Context(), "error", "auth_failed", map[string]any{
+			"method":    "bearer",
+			"client_ip": clientIP,
+			"protocol":  protocol,
+			"error":     err.Error(),
+		})
+		return nil, err
+	}
+
+	return &apiclient.AuthResponse{
+		Token:   strings.TrimSpace(token),
+		EventID: claims.EventID,
+	}, nil
+}
+
+// Challenge sets the WWW-Authenticate header for a 401 response
+func Challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="SabaiPics", charset="UTF-8"`)
+}
+
+func (a *Authenticator) authenticateBasic(ctx context.Context, user, pass, clientIP, protocol string) (*apiclient.AuthResponse, error) {
+	key := sha256.Sum256([]byte(user + "\x00" + pass))
+
+	a.mu.Lock()
+	cached, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok && a.now().Before(cached.expires) {
+		return cached.resp, nil
+	}
+
+	log.Printf("auth_attempt user=%s client=%s protocol=%s", user, clientIP, protocol)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := a.apiClient.Authenticate(ctx, apiclient.AuthRequest{
+		Username: user,
+		Password: pass,
+	})
+	if err != nil {
+		observability.EmitLog(ctx, "error", "auth_failed", map[string]any{
+			"user":      user,
+			"client_ip": clientIP,
+			"protocol":  protocol,
+			"error":     err.Error(),
+		})
+		return nil, ErrInvalidCredentials
+	}
+
+	log.Printf("auth_ok user=%s event=%s credits=%d protocol=%s", user, resp.EventID, resp.CreditsRemaining, protocol)
+
+	a.mu.Lock()
+	// Drop expired entries while we hold the lock so the cache stays bounded by active users
+	now := a.now()
+	for k, v := range a.cache {
+		if !now.Before(v.expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[key] = cachedAuth{resp: resp, expires: now.Add(basicAuthCacheTTL)}
+	a.mu.Unlock()
+
+	return resp, nil
+}
+
+// TokenClaims are the claims of an FTP upload JWT
+type TokenClaims struct {
+	EventID        string          `json:"eventId"`
+	PhotographerID string          `json:"photographerId"`
+	Audience       json.RawMessage `json:"aud"`
+	ExpiresAt      int64           `json:"exp"`
+}
+
+// verifyToken checks an HS256 JWT against the configured secrets
+func (a *Authenticator) verifyToken(token string) (*TokenClaims, error) {
+	if len(a.secrets) == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if !validSignature(signed, signature, a.secrets) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims TokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.EventID == "" || claims.PhotographerID == "" {
+		return nil, ErrInvalidToken
+	}
+	if claims.ExpiresAt == 0 || !a.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrInvalidToken
+	}
+	if !hasAudience(claims.Audience, tokenAudience) {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// IssueToken signs an FTP upload JWT the way the API's signFtpToken does (HS256, aud ftp-upload)
+// Used to re-authenticate spooled uploads after a restart, so their session token never hits the disk.
+func IssueToken(secret, eventID, photographerID string, ttl time.Duration) (string, error) {
+	if secret == "" {
+		return "", errors.New("FTP_JWT_SECRET is not set")
+	}
+	if eventID == "" || photographerID == "" {
+		return "", ErrInvalidToken
+	}
+
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(map[string]any{
+		"eventId":        eventID,
+		"photographerId": photographerID,
+		"sub":            photographerID,
+		"aud":            tokenAudience,
+		"iat":            now.Unix(),
+		"exp":            now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// PeekClaims decodes a token's claims without verifying it
+// Only for tokens the API handed us directly (e.g. at login), never for client-supplied ones.
+func PeekClaims(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var claims TokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+func validSignature(signed, signature []byte, secrets [][]byte) bool {
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// hasAudience matches the aud claim, which may be a string or an array of strings
+func hasAudience(raw json.RawMessage, want string) bool {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return single == want
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		for _, aud := range list {
+			if aud == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package httpauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+)
+
+const testSecret = "test-ftp-jwt-secret"
+
+// signToken builds an HS256 JWT the way the API's signFtpToken does
+func signToken(t *testing.T, secret string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "HS256"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to encode claims: %v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"eventId":        "evt_jwt",
+		"photographerId": "ph_1",
+		"sub":            "ph_1",
+		"aud":            "ftp-upload",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestAuthenticate_BearerToken(t *testing.T) {
+	a := New(&config.Config{FTPJWTSecret: testSecret, FTPJWTSecretPrevious: "old-secret"}, apiclient.NewMockClient())
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = []string{"clerk"}
+	listAudience := validClaims()
+	listAudience["aud"] = []string{"other", "ftp-upload"}
+	missingEvent := validClaims()
+	delete(missingEvent, "eventId")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", signToken(t, testSecret, validClaims()), false},
+		{"previous secret", signToken(t, "old-secret", validClaims()), false},
+		{"audience list", signToken(t, testSecret, listAudience), false},
+		{"wrong secret", signToken(t, "other-secret", validClaims()), true},
+		{"expired", signToken(t, testSecret, expired), true},
+		{"wrong audience", signToken(t, testSecret, wrongAudience), true},
+		{"missing eventId", signToken(t, testSecret, missingEvent), true},
+		{"malformed", "not.a-jwt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/files/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			auth, err := a.Authenticate(r, "test")
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Expected ErrInvalidToken, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate failed: %v", err)
+			}
+			if auth.EventID != "evt_jwt" || auth.Token != tt.token {
+				t.Errorf("Unexpected auth response: %+v", auth)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_BearerRejectedWithoutSecret(t *testing.T) {
+	a := New(&config.Config{}, apiclient.NewMockClient())
+
+	r := httptest.NewRequest("POST", "/files/", nil)
+	r.Header.Set("Authorization", "Bearer "+signToken(t, testSecret, validClaims()))
+	if _, err := a.Authenticate(r, "test"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestAuthenticate_BasicCachesVerifiedCredentials(t *testing.T) {
+	mockAPI := apiclient.NewMockClient()
+	a := New(&config.Config{}, mockAPI)
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("PATCH", "/files/x", nil)
+		r.SetBasicAuth("test", "pass")
+		if _, err := a.Authenticate(r, "test"); err != nil {
+			t.Fatalf("Authenticate failed: %v", err)
+		}
+	}
+	if got := mockAPI.GetAuthCallCount(); got != 1 {
+		t.Errorf("Expected 1 API auth call for repeated credentials, got %d", got)
+	}
+
+	// The cache entry expires and the credentials are checked again
+	a.now = func() time.Time { return time.Now().Add(basicAuthCacheTTL + time.Second) }
+	r := httptest.NewRequest("PATCH", "/files/x", nil)
+	r.SetBasicAuth("test", "pass")
+	if _, err := a.Authenticate(r, "test"); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if got := mockAPI.GetAuthCallCount(); got != 2 {
+		t.Errorf("Expected 2 API auth calls after cache expiry, got %d", got)
+	}
+}
+
+func TestAuthenticate_BasicFailureAndMissing(t *testing.T) {
+	mockAPI := apiclient.NewMockClient()
+	mockAPI.SetAuthFailure(errors.New("invalid credentials"))
+	a := New(&config.Config{}, mockAPI)
+
+	r := httptest.NewRequest("POST", "/files/", nil)
+	r.SetBasicAuth("test", "wrong")
+	if _, err := a.Authenticate(r, "test"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+
+	r = httptest.NewRequest("POST", "/files/", nil)
+	if _, err := a.Authenticate(r, "test"); !errors.Is(err, ErrMissingCredentials) {
+		t.Errorf("Expected ErrMissingCredentials, got %v", err)
+	}
+}
+
+func TestIssueToken_VerifiesLikeAnAPIToken(t *testing.T) {
+	token, err := IssueToken(testSecret, "evt_spool", "ph_1", time.Minute)
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+
+	a := New(&config.Config{FTPJWTSecret: testSecret}, apiclient.NewMockClient())
+	claims, err := a.verifyToken(token)
+	if err != nil || claims.EventID != "evt_spool" || claims.PhotographerID != "ph_1" {
+		t.Fatalf("verifyToken = %+v, %v", claims, err)
+	}
+	if peeked, err := PeekClaims(token); err != nil || peeked.EventID != claims.EventID || peeked.PhotographerID != claims.PhotographerID {
+		t.Errorf("PeekClaims = %+v, %v, want %+v", peeked, err, claims)
+	}
+
+	if _, err := IssueToken(testSecret, "evt_spool", "", time.Minute); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("IssueToken without a photographer = %v, want ErrInvalidToken", err)
+	}
+}
@@ -0,0 +1,250 @@
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+)
+
+// Scopes and kinds a cap applies to (also the metric attributes)
+const (
+	ScopeServer = "server"
+	ScopeIP     = "ip"
+	ScopeEvent  = "event"
+
+	KindSessions = "sessions"
+	KindUploads  = "uploads"
+)
+
+// ErrDraining refuses new sessions once the server is shutting down
+var ErrDraining = errors.New("server is restarting")
+
+// Limits are the concurrency caps; 0 means unlimited
+type Limits struct {
+	Sessions         int
+	SessionsPerIP    int
+	SessionsPerEvent int
+	Uploads          int
+	UploadsPerIP     int
+	UploadsPerEvent  int
+}
+
+// FromConfig reads the FTP_MAX_* settings
+func FromConfig(cfg *config.Config) Limits {
+	return Limits{
+		Sessions:         cfg.FTPMaxSessions,
+		SessionsPerIP:    cfg.FTPMaxSessionsPerIP,
+		SessionsPerEvent: cfg.FTPMaxSessionsPerEvent,
+		Uploads:          cfg.FTPMaxUploads,
+		UploadsPerIP:     cfg.FTPMaxUploadsPerIP,
+		UploadsPerEvent:  cfg.FTPMaxUploadsPerEvent,
+	}
+}
+
+// Validate rejects negative caps
+func (l Limits) Validate() error {
+	for name, v := range map[string]int{
+		"FTP_MAX_SESSIONS":           l.Sessions,
+		"FTP_MAX_SESSIONS_PER_IP":    l.SessionsPerIP,
+		"FTP_MAX_SESSIONS_PER_EVENT": l.SessionsPerEvent,
+		"FTP_MAX_UPLOADS":            l.Uploads,
+		"FTP_MAX_UPLOADS_PER_IP":     l.UploadsPerIP,
+		"FTP_MAX_UPLOADS_PER_EVENT":  l.UploadsPerEvent,
+	} {
+		if v < 0 {
+			return fmt.Errorf("%s must be 0 (unlimited) or positive, got %d", name, v)
+		}
+	}
+	return nil
+}
+
+// SessionCapped reports whether connections must be counted at accept time
+func (l Limits) SessionCapped() bool {
+	return l.Sessions > 0 || l.SessionsPerIP > 0
+}
+
+// LimitError is returned when a cap is reached
+type LimitError struct {
+	Kind  string // sessions or uploads
+	Scope string // server, ip or event
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	switch e.Scope {
+	case ScopeIP:
+		return fmt.Sprintf("too many concurrent %s from this address (limit %d)", e.Kind, e.Limit)
+	case ScopeEvent:
+		return fmt.Sprintf("too many concurrent %s for this event (limit %d)", e.Kind, e.Limit)
+	default:
+		return fmt.Sprintf("too many concurrent %s on this server (limit %d)", e.Kind, e.Limit)
+	}
+}
+
+// ReplyCode is the FTP reply for the refusal (implements ftpserver.ReplyCoder): a session over
+// its cap gets 421 and is closed, an upload gets 450 so the camera retries it later
+func (e *LimitError) ReplyCode() int {
+	if e.Kind == KindUploads {
+		return 450
+	}
+	return 421
+}
+
+// Usage is one gauge sample: for per-IP/per-event scopes InUse is the busiest key
+type Usage struct {
+	Kind  string
+	Scope string
+	InUse int
+	Limit int
+}
+
+// counter tracks one kind of slot (sessions or uploads)
+type counter struct {
+	total   int
+	byIP    map[string]int
+	byEvent map[string]int
+}
+
+func newCounter() *counter {
+	return &counter{byIP: make(map[string]int), byEvent: make(map[string]int)}
+}
+
+// add moves the counts; total is false for slots already counted elsewhere
+func (c *counter) add(total bool, ip, eventID string, delta int) {
+	if total {
+		c.total += delta
+	}
+	if ip != "" {
+		addKey(c.byIP, ip, delta)
+	}
+	if eventID != "" {
+		addKey(c.byEvent, eventID, delta)
+	}
+}
+
+func addKey(m map[string]int, key string, delta int) {
+	if m[key]+delta <= 0 {
+		delete(m, key)
+		return
+	}
+	m[key] += delta
+}
+
+func busiest(m map[string]int) int {
+	most := 0
+	for _, n := range m {
+		most = max(most, n)
+	}
+	return most
+}
+
+// Limiter counts concurrent sessions and uploads, shared by the FTP listeners
+// Every successful Acquire returns a release func that is safe to call more than once.
+type Limiter struct {
+	limits   Limits
+	draining atomic.Bool
+	banCheck func(ip string) error
+
+	mu       sync.Mutex
+	sessions *counter
+	uploads  *counter
+}
+
+// New creates a Limiter
+func New(limits Limits) *Limiter {
+	return &Limiter{
+		limits:   limits,
+		sessions: newCounter(),
+		uploads:  newCounter(),
+	}
+}
+
+// Limits returns the configured caps
+func (l *Limiter) Limits() Limits {
+	return l.limits
+}
+
+// Drain refuses every new session from now on (ErrDraining); slots already held are kept
+func (l *Limiter) Drain() {
+	l.draining.Store(true)
+}
+
+// SetBanCheck refuses sessions from addresses check returns an error for (e.g. clientmgr.Manager.CheckBan)
+// Set it before the listeners start.
+func (l *Limiter) SetBanCheck(check func(ip string) error) {
+	l.banCheck = check
+}
+
+// AcquireSession takes a connection slot for a client IP (server-wide and per-IP caps)
+func (l *Limiter) AcquireSession(ip string) (release func(), err error) {
+	if l.draining.Load() {
+		return nil, ErrDraining
+	}
+	if l.banCheck != nil {
+		if err := l.banCheck(ip); err != nil {
+			return nil, err
+		}
+	}
+	return l.acquire(l.sessions, KindSessions, true, ip, "", l.limits.Sessions, l.limits.SessionsPerIP, 0)
+}
+
+// AcquireEventSession takes a logged-in session slot for an event (per-event cap)
+// The connection already holds its server-wide slot from AcquireSession.
+func (l *Limiter) AcquireEventSession(eventID string) (release func(), err error) {
+	if l.draining.Load() {
+		return nil, ErrDraining
+	}
+	return l.acquire(l.sessions, KindSessions, false, "", eventID, 0, 0, l.limits.SessionsPerEvent)
+}
+
+// AcquireUpload takes an in-flight upload slot (server-wide, per-IP and per-event caps)
+func (l *Limiter) AcquireUpload(ip, eventID string) (release func(), err error) {
+	return l.acquire(l.uploads, KindUploads, true, ip, eventID, l.limits.Uploads, l.limits.UploadsPerIP, l.limits.UploadsPerEvent)
+}
+
+func (l *Limiter) acquire(c *counter, kind string, total bool, ip, eventID string, serverCap, ipCap, eventCap int) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var refused *LimitError
+	switch {
+	case total && serverCap > 0 && c.total >= serverCap:
+		refused = &LimitError{Kind: kind, Scope: ScopeServer, Limit: serverCap}
+	case ip != "" && ipCap > 0 && c.byIP[ip] >= ipCap:
+		refused = &LimitError{Kind: kind, Scope: ScopeIP, Limit: ipCap}
+	case eventID != "" && eventCap > 0 && c.byEvent[eventID] >= eventCap:
+		refused = &LimitError{Kind: kind, Scope: ScopeEvent, Limit: eventCap}
+	}
+	if refused != nil {
+		observability.RecordLimitRejection(refused.Kind, refused.Scope)
+		return nil, refused
+	}
+	c.add(total, ip, eventID, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			c.add(total, ip, eventID, -1)
+		})
+	}, nil
+}
+
+// Usage returns the current counts for the limit gauges
+func (l *Limiter) Usage() []Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return []Usage{
+		{Kind: KindSessions, Scope: ScopeServer, InUse: l.sessions.total, Limit: l.limits.Sessions},
+		{Kind: KindSessions, Scope: ScopeIP, InUse: busiest(l.sessions.byIP), Limit: l.limits.SessionsPerIP},
+		{Kind: KindSessions, Scope: ScopeEvent, InUse: busiest(l.sessions.byEvent), Limit: l.limits.SessionsPerEvent},
+		{Kind: KindUploads, Scope: ScopeServer, InUse: l.uploads.total, Limit: l.limits.Uploads},
+		{Kind: KindUploads, Scope: ScopeIP, InUse: busiest(l.uploads.byIP), Limit: l.limits.UploadsPerIP},
+		{Kind: KindUploads, Scope: ScopeEvent, InUse: busiest(l.uploads.byEvent), Limit: l.limits.UploadsPerEvent},
+	}
+}
@@ -0,0 +1,102 @@
+package limits
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLimiter_SessionCaps(t *testing.T) {
+	l := New(Limits{Sessions: 3, SessionsPerIP: 2, SessionsPerEvent: 1})
+
+	a1, err := l.AcquireSession("192.0.2.1")
+	if err != nil {
+		t.Fatalf("First session refused: %v", err)
+	}
+	if _, err := l.AcquireSession("192.0.2.1"); err != nil {
+		t.Fatalf("Second session refused: %v", err)
+	}
+
+	var limitErr *LimitError
+	if _, err := l.AcquireSession("192.0.2.1"); !errors.As(err, &limitErr) || limitErr.Scope != ScopeIP {
+		t.Errorf("Third session from one IP = %v, want the per-IP cap", err)
+	}
+	if _, err := l.AcquireSession("192.0.2.2"); err != nil {
+		t.Fatalf("Session from another IP refused: %v", err)
+	}
+	if _, err := l.AcquireSession("192.0.2.3"); !errors.As(err, &limitErr) || limitErr.Scope != ScopeServer {
+		t.Errorf("Fourth session on the server = %v, want the server-wide cap", err)
+	}
+
+	// Event slots don't count twice against the server-wide cap
+	if _, err := l.AcquireEventSession("evt_1"); err != nil {
+		t.Fatalf("Event session refused: %v", err)
+	}
+	if _, err := l.AcquireEventSession("evt_1"); !errors.As(err, &limitErr) || limitErr.Scope != ScopeEvent {
+		t.Errorf("Second event session = %v, want the per-event cap", err)
+	}
+
+	// Releasing twice frees one slot only
+	a1()
+	a1()
+	if _, err := l.AcquireSession("192.0.2.3"); err != nil {
+		t.Fatalf("Session after release refused: %v", err)
+	}
+	if _, err := l.AcquireSession("192.0.2.4"); err == nil {
+		t.Error("Double release freed a second slot")
+	}
+}
+
+func TestLimiter_UploadCaps(t *testing.T) {
+	l := New(Limits{UploadsPerIP: 1, UploadsPerEvent: 2})
+
+	release, err := l.AcquireUpload("192.0.2.1", "evt_1")
+	if err != nil {
+		t.Fatalf("First upload refused: %v", err)
+	}
+	if _, err := l.AcquireUpload("192.0.2.1", "evt_2"); err == nil {
+		t.Error("Second upload from one IP should hit the per-IP cap")
+	}
+	if _, err := l.AcquireUpload("192.0.2.2", "evt_1"); err != nil {
+		t.Fatalf("Upload from another IP refused: %v", err)
+	}
+	_, err = l.AcquireUpload("192.0.2.3", "evt_1")
+	if err == nil || err.Error() != "too many concurrent uploads for this event (limit 2)" {
+		t.Errorf("Third upload for the event = %v, want the per-event cap", err)
+	}
+
+	release()
+	if _, err := l.AcquireUpload("192.0.2.1", "evt_2"); err != nil {
+		t.Errorf("Upload after release refused: %v", err)
+	}
+}
+
+func TestLimiter_Usage(t *testing.T) {
+	l := New(Limits{SessionsPerIP: 5})
+	l.AcquireSession("192.0.2.1")
+	l.AcquireSession("192.0.2.1")
+	l.AcquireSession("192.0.2.2")
+	l.AcquireUpload("192.0.2.1", "evt_1")
+
+	want := map[[2]string][2]int{
+		{KindSessions, ScopeServer}: {3, 0},
+		{KindSessions, ScopeIP}:     {2, 5}, // busiest IP
+		{KindSessions, ScopeEvent}:  {0, 0},
+		{KindUploads, ScopeServer}:  {1, 0},
+		{KindUploads, ScopeIP}:      {1, 0},
+		{KindUploads, ScopeEvent}:   {1, 0},
+	}
+	for _, u := range l.Usage() {
+		if got := [2]int{u.InUse, u.Limit}; got != want[[2]string{u.Kind, u.Scope}] {
+			t.Errorf("%s/%s = %v, want %v", u.Kind, u.Scope, got, want[[2]string{u.Kind, u.Scope}])
+		}
+	}
+}
+
+func TestLimits_Validate(t *testing.T) {
+	if err := (Limits{}).Validate(); err != nil {
+		t.Errorf("Zero limits (unlimited) rejected: %v", err)
+	}
+	if err := (Limits{SessionsPerEvent: -1}).Validate(); err == nil {
+		t.Error("Negative limit accepted")
+	}
+}
@@ -0,0 +1,89 @@
+package limits
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// rejectTimeout bounds how long a refused peer may hold the 421 reply (and TLS handshake)
+const rejectTimeout = 5 * time.Second
+
+// NewListener counts control connections against the server-wide and per-IP session caps
+// Connections over a cap (or arriving while the server drains) get "421" and are closed
+// before the FTP server sees them:
+// ftpserverlib answers a ClientConnected error with 500, not 421.
+// tlsConfig is set for implicit FTPS listeners, whose peers expect a handshake before the reply;
+// inner must then be the plain listener, so accepted connections can still be wrapped in TLS above it.
+func NewListener(inner net.Listener, limiter *Limiter, tlsConfig *tls.Config) net.Listener {
+	return &limitListener{Listener: inner, limiter: limiter, tlsConfig: tlsConfig}
+}
+
+type limitListener struct {
+	net.Listener
+	limiter   *Limiter
+	tlsConfig *tls.Config
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := hostOf(conn.RemoteAddr())
+		release, err := l.limiter.AcquireSession(ip)
+		if err != nil {
+			go l.reject(conn, err)
+			continue
+		}
+		return &limitConn{Conn: conn, release: release}, nil
+	}
+}
+
+// reject sends 421 off the accept loop, so a silent peer can't stall other clients
+func (l *limitListener) reject(conn net.Conn, err error) {
+	log.Printf("session_limit_rejected client=%s reason=%q reply=421", conn.RemoteAddr(), err)
+
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+	if l.tlsConfig != nil {
+		conn = tls.Server(conn, l.tlsConfig)
+	}
+	defer conn.Close()
+	conn.Write([]byte("421 " + capitalize(err.Error()) + ", try again later\r\n"))
+}
+
+// limitConn frees its session slot when closed
+type limitConn struct {
+	net.Conn
+	release   func()
+	closeOnce sync.Once
+}
+
+func (c *limitConn) Close() error {
+	c.closeOnce.Do(c.release)
+	return c.Conn.Close()
+}
+
+// HostOf returns the IP a connection counts against (PROXY headers already applied)
+func HostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func hostOf(addr net.Addr) string {
+	return HostOf(addr.String())
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return string(s[0]-'a'+'A') + s[1:]
+}
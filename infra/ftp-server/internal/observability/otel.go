@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
@@ -36,6 +37,33 @@ var (
 	uploadCount      metric.Int64Counter
 	uploadBytes      metric.Int64Histogram
 	uploadDurationMs metric.Float64Histogram
+	certReloads      metric.Int64Counter
+	cleartextLogins  metric.Int64Counter
+	limitRejections  metric.Int64Counter
+	eventsDropped    metric.Int64Counter
+	webhookResults   metric.Int64Counter
+	bandwidthBytes   metric.Int64Counter
+	bandwidthWait    metric.Float64Counter
+	queueWaitMs      metric.Float64Histogram
+	bufferRejections metric.Int64Counter
+
+	// certExpiryUnix is observed by the TLS certificate expiry gauge (0 = no certificate)
+	certExpiryUnix atomic.Int64
+
+	// limitUsage reports the concurrency limit gauges (nil = no limiter running)
+	limitUsage atomic.Pointer[func() []LimitUsage]
+
+	// bandwidthLimits reports the configured bandwidth limits by scope (nil = no shaper)
+	bandwidthLimits atomic.Pointer[func() map[string]int64]
+
+	// uploadQueue reports uploads waiting for an R2 slot by category (nil = no scheduler)
+	uploadQueue atomic.Pointer[func() map[string]int64]
+
+	// uploadBuffer reports the upload buffer directory's disk usage by state (nil = not configured)
+	uploadBuffer atomic.Pointer[func() map[string]int64]
+
+	// logHook receives every EmitLog event (nil = none), e.g. the webhook dispatcher
+	logHook atomic.Pointer[func(event string, fields map[string]any)]
 
 	lokiPushURL string
 	lokiAuth    string
@@ -143,6 +171,143 @@ func RecordUpload(status string, bytes int64, duration time.Duration) {
 	}
 }
 
+// RecordCertReload counts TLS certificate reload attempts by status (ok/error)
+func RecordCertReload(status string) {
+	initInstruments()
+	if certReloads != nil {
+		certReloads.Add(context.Background(), 1, metric.WithAttributes(attribute.String("status", status)))
+	}
+}
+
+// RecordCleartextSession counts sessions that logged in without TLS, by listener
+func RecordCleartextSession(listener string) {
+	initInstruments()
+	if cleartextLogins != nil {
+		cleartextLogins.Add(context.Background(), 1, metric.WithAttributes(attribute.String("listener", listener)))
+	}
+}
+
+// SetCertExpiry sets the NotAfter of the certificate currently being served
+func SetCertExpiry(notAfter time.Time) {
+	initInstruments()
+	certExpiryUnix.Store(notAfter.Unix())
+}
+
+// LimitUsage is one sample of the concurrency limit gauges
+// For per-IP and per-event scopes InUse is the busiest IP or event.
+type LimitUsage struct {
+	Kind  string // sessions or uploads
+	Scope string // server, ip or event
+	InUse int64
+	Limit int64 // 0 = unlimited
+}
+
+// SetLimitUsageSource sets the func observed by the concurrency limit gauges
+func SetLimitUsageSource(source func() []LimitUsage) {
+	initInstruments()
+	limitUsage.Store(&source)
+}
+
+// RecordLimitRejection counts sessions and uploads refused by a concurrency cap
+func RecordLimitRejection(kind, scope string) {
+	initInstruments()
+	if limitRejections != nil {
+		limitRejections.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("kind", kind),
+			attribute.String("scope", scope),
+		))
+	}
+}
+
+// RecordClientEventDropped counts an informational client manager event lost to a full buffer
+func RecordClientEventDropped(eventType string) {
+	initInstruments()
+	if eventsDropped != nil {
+		eventsDropped.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("type", eventType),
+		))
+	}
+}
+
+// RecordWebhookDelivery counts a webhook delivery outcome (ok, failed, dropped)
+func RecordWebhookDelivery(status string) {
+	initInstruments()
+	if webhookResults != nil {
+		webhookResults.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("status", status),
+		))
+	}
+}
+
+// RecordBandwidthBytes counts upload bytes passed through the bandwidth shaper (direction in or out)
+func RecordBandwidthBytes(direction string, bytes int64) {
+	initInstruments()
+	if bandwidthBytes != nil {
+		bandwidthBytes.Add(context.Background(), bytes, metric.WithAttributes(
+			attribute.String("direction", direction),
+		))
+	}
+}
+
+// RecordBandwidthWait adds time an upload waited for a bandwidth bucket of scope
+func RecordBandwidthWait(direction, scope string, waited time.Duration) {
+	initInstruments()
+	if bandwidthWait != nil {
+		bandwidthWait.Add(context.Background(), waited.Seconds(), metric.WithAttributes(
+			attribute.String("direction", direction),
+			attribute.String("scope", scope),
+		))
+	}
+}
+
+// SetBandwidthLimitSource sets the func observed by the bandwidth limit gauge (bytes per second by scope)
+func SetBandwidthLimitSource(source func() map[string]int64) {
+	initInstruments()
+	bandwidthLimits.Store(&source)
+}
+
+// RecordUploadQueueWait records how long an upload waited for an R2 slot
+func RecordUploadQueueWait(category string, waited time.Duration) {
+	initInstruments()
+	if queueWaitMs != nil {
+		queueWaitMs.Record(context.Background(), float64(waited.Milliseconds()), metric.WithAttributes(
+			attribute.String("category", category),
+		))
+	}
+}
+
+// SetUploadQueueSource sets the func observed by the R2 queue gauge (waiting uploads by category)
+func SetUploadQueueSource(source func() map[string]int64) {
+	initInstruments()
+	uploadQueue.Store(&source)
+}
+
+// RecordUploadBufferRejection counts an upload refused for disk space or file size
+func RecordUploadBufferRejection(reason string) {
+	initInstruments()
+	if bufferRejections != nil {
+		bufferRejections.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("reason", reason),
+		))
+	}
+}
+
+// SetUploadBufferSource sets the func observed by the upload buffer disk gauge (bytes by state)
+func SetUploadBufferSource(source func() map[string]int64) {
+	initInstruments()
+	uploadBuffer.Store(&source)
+}
+
+// SetLogHook passes every EmitLog event and its fields to hook (nil removes it)
+// The hook runs synchronously on the caller's goroutine and must not block or keep fields.
+func SetLogHook(hook func(event string, fields map[string]any)) {
+	if hook == nil {
+		logHook.Store(nil)
+		return
+	}
+	logHook.Store(&hook)
+}
+
 func EmitLog(ctx context.Context, level string, event string, fields map[string]any) {
 	body := map[string]any{
 		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
@@ -168,6 +333,10 @@ func EmitLog(ctx context.Context, level string, event string, fields map[string]
 
 	log.Printf("%s", line)
 
+	if hook := logHook.Load(); hook != nil {
+		(*hook)(event, fields)
+	}
+
 	if lokiPushURL == "" || lokiAuth == "" {
 		return
 	}
@@ -230,9 +399,142 @@ func initInstruments() {
 		if err != nil {
 			log.Printf("[observability] create duration histogram failed: %v", err)
 		}
+		certReloads, err = meter.Int64Counter("framefast_ftp_tls_cert_reloads_total")
+		if err != nil {
+			log.Printf("[observability] create cert reload counter failed: %v", err)
+		}
+		cleartextLogins, err = meter.Int64Counter("framefast_ftp_cleartext_sessions_total")
+		if err != nil {
+			log.Printf("[observability] create cleartext session counter failed: %v", err)
+		}
+		_, err = meter.Int64ObservableGauge(
+			"framefast_ftp_tls_cert_expiry_seconds",
+			metric.WithDescription("Seconds until the served TLS certificate expires"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				if expiry := certExpiryUnix.Load(); expiry > 0 {
+					o.Observe(expiry - time.Now().Unix())
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			log.Printf("[observability] create cert expiry gauge failed: %v", err)
+		}
+		limitRejections, err = meter.Int64Counter("framefast_ftp_limit_rejections_total")
+		if err != nil {
+			log.Printf("[observability] create limit rejection counter failed: %v", err)
+		}
+		eventsDropped, err = meter.Int64Counter("framefast_ftp_client_events_dropped_total")
+		if err != nil {
+			log.Printf("[observability] create client event drop counter failed: %v", err)
+		}
+		webhookResults, err = meter.Int64Counter("framefast_ftp_webhook_deliveries_total")
+		if err != nil {
+			log.Printf("[observability] create webhook delivery counter failed: %v", err)
+		}
+		bandwidthBytes, err = meter.Int64Counter("framefast_ftp_bandwidth_bytes_total")
+		if err != nil {
+			log.Printf("[observability] create bandwidth bytes counter failed: %v", err)
+		}
+		bandwidthWait, err = meter.Float64Counter("framefast_ftp_bandwidth_wait_seconds_total")
+		if err != nil {
+			log.Printf("[observability] create bandwidth wait counter failed: %v", err)
+		}
+		_, err = meter.Int64ObservableGauge(
+			"framefast_ftp_bandwidth_limit_bps",
+			metric.WithDescription("Default bandwidth limit per leg in bytes per second (0 = unlimited)"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				source := bandwidthLimits.Load()
+				if source == nil {
+					return nil
+				}
+				for scope, bps := range (*source)() {
+					o.Observe(bps, metric.WithAttributes(attribute.String("scope", scope)))
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			log.Printf("[observability] create bandwidth limit gauge failed: %v", err)
+		}
+		queueWaitMs, err = meter.Float64Histogram("framefast_ftp_upload_queue_wait_ms")
+		if err != nil {
+			log.Printf("[observability] create upload queue wait histogram failed: %v", err)
+		}
+		_, err = meter.Int64ObservableGauge(
+			"framefast_ftp_upload_queue_waiting",
+			metric.WithDescription("Uploads waiting for an R2 upload slot by file category"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				source := uploadQueue.Load()
+				if source == nil {
+					return nil
+				}
+				for category, n := range (*source)() {
+					o.Observe(n, metric.WithAttributes(attribute.String("category", category)))
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			log.Printf("[observability] create upload queue gauge failed: %v", err)
+		}
+		bufferRejections, err = meter.Int64Counter("framefast_ftp_upload_buffer_rejections_total")
+		if err != nil {
+			log.Printf("[observability] create upload buffer rejection counter failed: %v", err)
+		}
+		_, err = meter.Int64ObservableGauge(
+			"framefast_ftp_upload_buffer_bytes",
+			metric.WithDescription("Upload buffer directory disk usage: bytes buffered, free and total on its filesystem"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				source := uploadBuffer.Load()
+				if source == nil {
+					return nil
+				}
+				for state, n := range (*source)() {
+					o.Observe(n, metric.WithAttributes(attribute.String("state", state)))
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			log.Printf("[observability] create upload buffer gauge failed: %v", err)
+		}
+		_, err = meter.Int64ObservableGauge(
+			"framefast_ftp_limit_in_use",
+			metric.WithDescription("Concurrent sessions/uploads counted against each cap (busiest IP or event for per-key scopes)"),
+			metric.WithInt64Callback(observeLimits(func(u LimitUsage) int64 { return u.InUse })),
+		)
+		if err != nil {
+			log.Printf("[observability] create limit usage gauge failed: %v", err)
+		}
+		_, err = meter.Int64ObservableGauge(
+			"framefast_ftp_limit_max",
+			metric.WithDescription("Configured concurrency caps (0 = unlimited)"),
+			metric.WithInt64Callback(observeLimits(func(u LimitUsage) int64 { return u.Limit })),
+		)
+		if err != nil {
+			log.Printf("[observability] create limit max gauge failed: %v", err)
+		}
 	})
 }
 
+// observeLimits reports one field of every limit sample
+func observeLimits(value func(LimitUsage) int64) metric.Int64Callback {
+	return func(_ context.Context, o metric.Int64Observer) error {
+		source := limitUsage.Load()
+		if source == nil {
+			return nil
+		}
+		for _, u := range (*source)() {
+			o.Observe(value(u), metric.WithAttributes(
+				attribute.String("kind", u.Kind),
+				attribute.String("scope", u.Scope),
+			))
+		}
+		return nil
+	}
+}
+
 func configureLoki(cfg *config.Config) {
 	lokiPushURL = ""
 	lokiAuth = ""
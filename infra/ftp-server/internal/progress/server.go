@@ -0,0 +1,344 @@
+package progress
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/handoff"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/httpauth"
+)
+
+// tickInterval is how often in-flight uploads are sampled for progress events
+const tickInterval = time.Second
+
+// keepaliveInterval is the longest a stream stays silent (proxies close idle connections)
+const keepaliveInterval = 15 * time.Second
+
+// subscriberBuffer bounds the finished uploads queued for one slow stream
+const subscriberBuffer = 64
+
+// Server streams live upload progress of one event over Server-Sent Events
+// GET /events/{eventID}/progress needs the event's FTP upload JWT, as a bearer token or as
+// ?access_token= (EventSource cannot set headers). Streams carry progress events for every
+// upload in flight on any session of the event, then completed or failed when it finishes.
+type Server struct {
+	config    *config.Config
+	clientMgr *clientmgr.Manager
+	auth      *httpauth.Authenticator
+	tlsConfig *tls.Config // nil serves plain HTTP (e.g. behind a TLS-terminating proxy)
+
+	httpServer *http.Server
+	stopping   chan struct{} // closed by Stop so open streams end
+
+	// socket is the TCP listener under TLS, passed on by graceful restarts
+	socketMu sync.Mutex
+	socket   net.Listener
+
+	mu          sync.Mutex
+	subscribers map[string]map[chan clientmgr.UploadResult]struct{} // event ID -> streams
+}
+
+// ValidateConfig refuses to start without a JWT secret, since no request could be authorised
+func ValidateConfig(cfg *config.Config) error {
+	if cfg.FTPJWTSecret == "" && cfg.FTPJWTSecretPrevious == "" {
+		return fmt.Errorf("PROGRESS_ENABLED requires FTP_JWT_SECRET (streams are authorised with the event's FTP JWT)")
+	}
+	return nil
+}
+
+// New creates the progress server
+func New(cfg *config.Config, clientMgr *clientmgr.Manager, apiClient apiclient.APIClient, tlsConfig *tls.Config) *Server {
+	s := &Server{
+		config:      cfg,
+		clientMgr:   clientMgr,
+		auth:        httpauth.New(cfg, apiClient),
+		tlsConfig:   tlsConfig,
+		stopping:    make(chan struct{}),
+		subscribers: make(map[string]map[chan clientmgr.UploadResult]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events/{eventID}/progress", s.handleStream)
+
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.httpServer.RegisterOnShutdown(func() { close(s.stopping) })
+	return s
+}
+
+// ListenAndServe serves progress streams until Stop is called
+func (s *Server) ListenAndServe() error {
+	listener, err := handoff.Listen("progress", s.config.ProgressListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.ProgressListenAddress, err)
+	}
+	s.socketMu.Lock()
+	s.socket = listener
+	s.socketMu.Unlock()
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	log.Printf("progress_listening addr=%s tls=%t", listener.Addr(), s.tlsConfig != nil)
+
+	if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ListenerFile returns a duplicate of the listening socket for a graceful restart
+func (s *Server) ListenerFile() (string, *os.File, error) {
+	s.socketMu.Lock()
+	socket := s.socket
+	s.socketMu.Unlock()
+	if socket == nil {
+		return "progress", nil, errors.New("listener not started")
+	}
+	file, err := handoff.File(socket)
+	return "progress", file, err
+}
+
+// Stop closes the listener and ends every open stream once its current write is done
+// (EventSource clients reconnect on their own, to the next process after a restart)
+func (s *Server) Stop(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.httpServer.Close()
+	}
+	return err
+}
+
+// Publish passes a finished upload to the streams of its event (set with clientmgr.SetUploadObserver)
+// A stream that is too slow to keep up misses the result rather than holding up the upload.
+func (s *Server) Publish(result clientmgr.UploadResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.subscribers[result.EventID] {
+		select {
+		case ch <- result:
+		default:
+			log.Printf("progress_result_dropped event=%s file=%s", result.EventID, result.Filename)
+		}
+	}
+}
+
+func (s *Server) subscribe(eventID string) (<-chan clientmgr.UploadResult, func()) {
+	ch := make(chan clientmgr.UploadResult, subscriberBuffer)
+
+	s.mu.Lock()
+	if s.subscribers[eventID] == nil {
+		s.subscribers[eventID] = make(map[chan clientmgr.UploadResult]struct{})
+	}
+	s.subscribers[eventID][ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers[eventID], ch)
+		if len(s.subscribers[eventID]) == 0 {
+			delete(s.subscribers, eventID)
+		}
+	}
+}
+
+// authenticate accepts only the event's FTP upload JWT; Basic credentials are not enough to watch
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*apiclient.AuthResponse, bool) {
+	if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="SabaiPics"`)
+		http.Error(w, httpauth.ErrMissingCredentials.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	auth, err := s.auth.Authenticate(r, "progress")
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="SabaiPics", error="invalid_token"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	return auth, true
+}
+
+type progressJSON struct {
+	SessionID uint32    `json:"session_id"`
+	Protocol  string    `json:"protocol"`
+	File      string    `json:"file"`
+	Bytes     int64     `json:"bytes"`
+	Rate      int64     `json:"rate_bps"` // bytes per second since the previous tick
+	StartedAt time.Time `json:"started_at"`
+}
+
+type resultJSON struct {
+	SessionID  uint32 `json:"session_id"`
+	File       string `json:"file"`
+	Bytes      int64  `json:"bytes"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// sampleKey identifies one upload across ticks
+type sampleKey struct {
+	sessionID uint32
+	file      string
+	startedAt time.Time
+}
+
+type sample struct {
+	bytes int64
+	at    time.Time
+}
+
+// handleStream writes the event's progress until the client goes away or the server stops
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	auth, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	eventID := r.PathValue("eventID")
+	if auth.EventID != eventID {
+		http.Error(w, "token is not valid for this event", http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	results, unsubscribe := s.subscribe(eventID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would otherwise buffer the stream
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	log.Printf("progress_stream_opened event=%s client=%s", eventID, r.RemoteAddr)
+	defer log.Printf("progress_stream_closed event=%s client=%s", eventID, r.RemoteAddr)
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	samples := make(map[sampleKey]sample)
+	lastWrite := time.Now()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		case result := <-results:
+			err = writeResult(w, result)
+		case now := <-ticker.C:
+			var wrote bool
+			wrote, err = s.writeProgress(w, eventID, samples, now)
+			if err == nil && !wrote && now.Sub(lastWrite) >= keepaliveInterval {
+				_, err = fmt.Fprint(w, ": keepalive\n\n")
+				wrote = true
+			}
+			if !wrote {
+				continue
+			}
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+		lastWrite = time.Now()
+	}
+}
+
+// writeProgress writes a progress event for every upload of the event in flight
+// samples holds the previous tick's byte counts, so the rate covers the last interval.
+func (s *Server) writeProgress(w http.ResponseWriter, eventID string, samples map[sampleKey]sample, now time.Time) (bool, error) {
+	seen := make(map[sampleKey]bool)
+	for _, session := range s.clientMgr.Clients() {
+		if session.EventID != eventID {
+			continue
+		}
+		for _, upload := range session.Uploads {
+			key := sampleKey{sessionID: session.ID, file: upload.Filename, startedAt: upload.StartedAt}
+			seen[key] = true
+
+			prev, ok := samples[key]
+			if !ok {
+				prev = sample{bytes: 0, at: upload.StartedAt}
+			}
+			var rate int64
+			if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 {
+				rate = int64(float64(upload.Bytes-prev.bytes) / elapsed)
+			}
+			samples[key] = sample{bytes: upload.Bytes, at: now}
+
+			err := writeEvent(w, "progress", progressJSON{
+				SessionID: session.ID,
+				Protocol:  session.Protocol,
+				File:      upload.Filename,
+				Bytes:     upload.Bytes,
+				Rate:      rate,
+				StartedAt: upload.StartedAt,
+			})
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	for key := range samples {
+		if !seen[key] {
+			delete(samples, key)
+		}
+	}
+	return len(seen) > 0, nil
+}
+
+func writeResult(w http.ResponseWriter, result clientmgr.UploadResult) error {
+	payload := resultJSON{
+		SessionID:  result.ClientID,
+		File:       result.Filename,
+		Bytes:      result.Bytes,
+		DurationMS: result.Duration.Milliseconds(),
+	}
+	if result.Err != nil {
+		payload.Error = result.Err.Error()
+		return writeEvent(w, "failed", payload)
+	}
+	return writeEvent(w, "completed", payload)
+}
+
+func writeEvent(w http.ResponseWriter, event string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+	return err
+}
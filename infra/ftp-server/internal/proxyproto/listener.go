@@ -0,0 +1,305 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerTimeout bounds how long a trusted peer may take to send its PROXY header
+const headerTimeout = 5 * time.Second
+
+// v2Signature starts every PROXY protocol v2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Header is the longest v1 header allowed by the spec, CRLF included
+const maxV1Header = 107
+
+var errNotProxy = errors.New("connection did not start with a PROXY protocol header")
+
+// ParseCIDRs parses a comma-separated list of CIDRs or bare IPs (PROXY_PROTOCOL_TRUSTED_CIDRS)
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %q: %w", entry, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	if len(nets) == 0 {
+		return nil, fmt.Errorf("no trusted proxy CIDRs in %q", list)
+	}
+	return nets, nil
+}
+
+// Listener reads PROXY protocol v1/v2 headers from trusted load balancers
+// Connections from trusted CIDRs must send a header; their RemoteAddr becomes the client's address.
+// Other connections are passed through untouched, so a spoofed header is just an invalid FTP command.
+// Headers are read in the background: ftpserverlib's accept loop must never wait on a silent peer.
+type Listener struct {
+	inner   net.Listener
+	trusted []*net.IPNet
+
+	ready     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener wraps inner and starts accepting connections
+func NewListener(inner net.Listener, trusted []*net.IPNet) *Listener {
+	l := &Listener{
+		inner:   inner,
+		trusted: trusted,
+		ready:   make(chan net.Conn),
+		errs:    make(chan error),
+		done:    make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// Accept returns the next connection whose header (if required) has been read
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.ready:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		// ftpserverlib recognises this error as a normal shutdown
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: l.inner.Addr(), Err: net.ErrClosed}
+	}
+}
+
+// Close stops accepting; connections still sending their header are dropped
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.inner.Close()
+	})
+	return err
+}
+
+// Addr returns the listening address
+func (l *Listener) Addr() net.Addr {
+	return l.inner.Addr()
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.inner.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			case l.errs <- err:
+				continue
+			}
+		}
+
+		if !l.isTrusted(conn.RemoteAddr()) {
+			l.deliver(conn)
+			continue
+		}
+		go func() {
+			proxied, err := readHeader(conn)
+			if err != nil {
+				log.Printf("proxy_protocol_rejected peer=%s error=%v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			l.deliver(proxied)
+		}()
+	}
+}
+
+func (l *Listener) deliver(conn net.Conn) {
+	select {
+	case l.ready <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range l.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection whose RemoteAddr is the client named in the PROXY header
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader // holds any bytes the proxy sent after the header (e.g. a TLS ClientHello)
+	remote net.Addr
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the original client address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// ProxyAddr returns the load balancer's address
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// readHeader consumes the PROXY header and returns the connection with the client's address
+// Health checks (v2 LOCAL, v1 UNKNOWN) keep the proxy's own address.
+func readHeader(conn net.Conn) (net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(headerTimeout)); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	var remote net.Addr
+	switch first[0] {
+	case 'P':
+		remote, err = readV1(reader)
+	case v2Signature[0]:
+		remote, err = readV2(reader)
+	default:
+		err = errNotProxy
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+	return &Conn{Conn: conn, reader: reader, remote: remote}, nil
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"
+func readV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxV1Header {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 header too long or not CRLF-terminated")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errNotProxy
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v1 protocol %q", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("malformed PROXY v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("malformed PROXY v1 source address")
+	}
+	if (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, errors.New("PROXY v1 source address does not match its protocol")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readV2 parses the binary v2 header; TLVs are skipped
+func readV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:12], v2Signature) {
+		return nil, errNotProxy
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	switch command := header[12] & 0x0f; command {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+
+	switch family := header[13]; family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short PROXY v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short PROXY v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		// UNSPEC or a non-TCP family: the address is not usable for an FTP client
+		return nil, nil
+	}
+}
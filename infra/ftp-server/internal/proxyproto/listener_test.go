@@ -0,0 +1,180 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// startListener serves one connection from localhost with the given trust list
+func startListener(t *testing.T, trusted string) (*Listener, string) {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	cidrs, err := ParseCIDRs(trusted)
+	if err != nil {
+		t.Fatalf("ParseCIDRs failed: %v", err)
+	}
+	l := NewListener(inner, cidrs)
+	t.Cleanup(func() { l.Close() })
+	return l, inner.Addr().String()
+}
+
+// acceptAndRead accepts one connection and reads the line sent after the header
+func acceptAndRead(t *testing.T, l *Listener) (net.Conn, string) {
+	t.Helper()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Read after header failed: %v", err)
+	}
+	return conn, line
+}
+
+func v2Header(command byte, family byte, addrs []byte) []byte {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestListener_Headers(t *testing.T) {
+	ipv4Block := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0xC3, 0x50, 0x00, 0x15} // 203.0.113.7:50000 -> 10.0.0.1:21
+	ipv6Block := make([]byte, 36)
+	copy(ipv6Block, net.ParseIP("2001:db8::7"))
+	binary.BigEndian.PutUint16(ipv6Block[32:34], 50000)
+	withTLV := append(append([]byte{}, ipv4Block...), 0x04, 0x00, 0x01, 0xFF) // PP2_TYPE_NOOP
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   string // empty = the proxy's own address
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 50000 21\r\n"), "203.0.113.7:50000"},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 50000 21\r\n"), "[2001:db8::7]:50000"},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), ""},
+		{"v2 IPv4", v2Header(0x1, 0x11, ipv4Block), "203.0.113.7:50000"},
+		{"v2 IPv6", v2Header(0x1, 0x21, ipv6Block), "[2001:db8::7]:50000"},
+		{"v2 with TLVs", v2Header(0x1, 0x11, withTLV), "203.0.113.7:50000"},
+		{"v2 LOCAL health check", v2Header(0x0, 0x00, nil), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, addr := startListener(t, "127.0.0.0/8")
+
+			client, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer client.Close()
+			// Header and first payload in one segment, as load balancers send them
+			client.Write(append(tt.header, "AUTH TLS\r\n"...))
+
+			conn, line := acceptAndRead(t, l)
+			if line != "AUTH TLS\r\n" {
+				t.Errorf("Payload after header = %q", line)
+			}
+			want := tt.want
+			if want == "" {
+				want = client.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("RemoteAddr = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestListener_UntrustedPeerPassedThrough(t *testing.T) {
+	l, addr := startListener(t, "10.0.0.0/8")
+
+	client, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	// A spoofed header from an untrusted peer is not interpreted
+	client.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 50000 21\r\n"))
+
+	conn, line := acceptAndRead(t, l)
+	if line != "PROXY TCP4 203.0.113.7 10.0.0.1 50000 21\r\n" {
+		t.Errorf("Untrusted header should reach the server unchanged, got %q", line)
+	}
+	if got := conn.RemoteAddr().String(); got != client.LocalAddr().String() {
+		t.Errorf("RemoteAddr = %s, want the peer's own address %s", got, client.LocalAddr())
+	}
+}
+
+func TestListener_TrustedPeerWithoutHeaderRejected(t *testing.T) {
+	l, addr := startListener(t, "127.0.0.1")
+
+	bad, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer bad.Close()
+	bad.Write([]byte("USER test\r\n"))
+
+	// The bad connection is dropped without blocking the next one
+	good, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer good.Close()
+	good.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 50000 21\r\nNOOP\r\n"))
+
+	conn, _ := acceptAndRead(t, l)
+	if got := conn.RemoteAddr().String(); got != "203.0.113.7:50000" {
+		t.Errorf("RemoteAddr = %s, want 203.0.113.7:50000", got)
+	}
+
+	bad.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := bad.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected connection without PROXY header to be closed")
+	}
+}
+
+func TestListener_CloseUnblocksAccept(t *testing.T) {
+	l, _ := startListener(t, "127.0.0.1")
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		errCh <- err
+	}()
+	l.Close()
+
+	select {
+	case err := <-errCh:
+		opErr, ok := err.(*net.OpError)
+		if !ok || opErr.Err.Error() != "use of closed network connection" {
+			t.Errorf("Accept after Close = %v, want a closed-connection OpError", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs("10.0.0.0/8, 192.0.2.10,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("ParseCIDRs failed: %v", err)
+	}
+	if len(nets) != 3 || !nets[1].Contains(net.ParseIP("192.0.2.10")) || nets[1].Contains(net.ParseIP("192.0.2.11")) {
+		t.Errorf("Unexpected networks: %v", nets)
+	}
+
+	for _, bad := range []string{"", "10.0.0.0/33", "lb.internal"} {
+		if _, err := ParseCIDRs(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
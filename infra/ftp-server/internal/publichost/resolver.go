@@ -0,0 +1,164 @@
+package publichost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Auto is the FTP_PUBLIC_HOST value that detects the public IP with an HTTP probe
+const Auto = "auto"
+
+// Resolver supplies the IPv4 address advertised in PASV replies
+// FTP_PUBLIC_HOST may be a static IPv4, a hostname (re-resolved periodically, for dynamic DNS),
+// or "auto" (the address reported by an IP echo service such as checkip.amazonaws.com).
+// A failed refresh keeps advertising the previous address.
+type Resolver struct {
+	host     string
+	probeURL string
+	interval time.Duration
+
+	current atomic.Value // string
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New resolves the public host once; the initial lookup must succeed
+func New(host, probeURL string, interval time.Duration) (*Resolver, error) {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	r := &Resolver{
+		host:     strings.TrimSpace(host),
+		probeURL: probeURL,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+
+	if ip := net.ParseIP(r.host); ip != nil && ip.To4() == nil {
+		return nil, fmt.Errorf("FTP_PUBLIC_HOST %s is IPv6 - PASV replies can only carry IPv4", r.host)
+	}
+	if r.host == Auto && r.probeURL == "" {
+		return nil, fmt.Errorf("FTP_PUBLIC_HOST=auto requires FTP_PUBLIC_IP_PROBE_URL")
+	}
+
+	ip, err := r.resolve()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve FTP public host %q: %w", r.host, err)
+	}
+	r.current.Store(ip)
+	log.Printf("public_host_resolved host=%s ip=%s", r.host, ip)
+
+	return r, nil
+}
+
+// PublicIP returns the address to advertise (for ftpserverlib's PublicIPResolver)
+func (r *Resolver) PublicIP() (string, error) {
+	return r.current.Load().(string), nil
+}
+
+// Start refreshes hostnames and auto-detected addresses in the background
+// Static IPs never change, so nothing is started for them.
+func (r *Resolver) Start() error {
+	if net.ParseIP(r.host) != nil {
+		return nil
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	return nil
+}
+
+// Stop ends the refresh loop
+func (r *Resolver) Stop() {
+	r.stopOnce.Do(func() { close(r.stopChan) })
+	r.wg.Wait()
+}
+
+func (r *Resolver) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stopChan:
+			return
+		case <-ticker.C:
+			r.refresh()
+		}
+	}
+}
+
+func (r *Resolver) refresh() {
+	ip, err := r.resolve()
+	if err != nil {
+		log.Printf("public_host_refresh_failed host=%s keeping=%s error=%v", r.host, r.current.Load(), err)
+		return
+	}
+	if previous := r.current.Swap(ip); previous != ip {
+		log.Printf("public_host_changed host=%s old=%s new=%s", r.host, previous, ip)
+	}
+}
+
+// resolve returns the current IPv4 for the configured host
+func (r *Resolver) resolve() (string, error) {
+	if ip := net.ParseIP(r.host); ip != nil {
+		return ip.To4().String(), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if r.host == Auto {
+		return probe(ctx, r.probeURL)
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", r.host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			return v4.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no IPv4 address for %s", r.host)
+}
+
+// probe asks an IP echo service for the address our traffic leaves from
+func probe(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("probe %s returned %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil || ip.To4() == nil {
+		return "", fmt.Errorf("probe %s returned no IPv4 address", url)
+	}
+	return ip.To4().String(), nil
+}
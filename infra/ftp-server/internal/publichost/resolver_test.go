@@ -0,0 +1,63 @@
+package publichost
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolver_AutoRefreshKeepsLastGoodAddress(t *testing.T) {
+	var response atomic.Value
+	response.Store("198.51.100.9\n")
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := response.Load().(string)
+		if body == "" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer probe.Close()
+
+	r, err := New(Auto, probe.URL, time.Minute)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if ip, _ := r.PublicIP(); ip != "198.51.100.9" {
+		t.Fatalf("PublicIP = %s, want 198.51.100.9", ip)
+	}
+
+	// NAT egress changed
+	response.Store("198.51.100.10")
+	r.refresh()
+	if ip, _ := r.PublicIP(); ip != "198.51.100.10" {
+		t.Errorf("PublicIP after refresh = %s, want 198.51.100.10", ip)
+	}
+
+	// A failed probe keeps the last good address
+	response.Store("")
+	r.refresh()
+	if ip, _ := r.PublicIP(); ip != "198.51.100.10" {
+		t.Errorf("PublicIP after failed refresh = %s, want 198.51.100.10", ip)
+	}
+}
+
+func TestNew_RejectsUnusableHosts(t *testing.T) {
+	if _, err := New("2001:db8::1", "", time.Minute); err == nil {
+		t.Error("Expected IPv6 address to be rejected")
+	}
+	if _, err := New(Auto, "", time.Minute); err == nil {
+		t.Error("Expected auto without probe URL to be rejected")
+	}
+
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "not an ip")
+	}))
+	defer probe.Close()
+	if _, err := New(Auto, probe.URL, time.Minute); err == nil {
+		t.Error("Expected a probe without an IPv4 answer to fail")
+	}
+}
@@ -0,0 +1,143 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+)
+
+// Scheduler hands out a fixed number of R2 upload slots
+// Events take turns so one event's backlog doesn't hold up the others; each event's uploads
+// keep their arrival order.
+type Scheduler struct {
+	slots int
+
+	mu     sync.Mutex
+	inUse  int
+	queues map[string][]*waiter // a FIFO per event, served round-robin
+	turns  []string             // events with waiters, in the order they are served
+}
+
+type waiter struct {
+	category string // file category, for the queue metrics
+	granted  chan struct{}
+	done     bool // granted or cancelled; guarded by Scheduler.mu
+}
+
+// New creates a Scheduler with slots concurrent uploads
+func New(slots int) *Scheduler {
+	return &Scheduler{
+		slots:  slots,
+		queues: make(map[string][]*waiter),
+	}
+}
+
+// Slots returns the number of concurrent uploads
+func (s *Scheduler) Slots() int {
+	return s.slots
+}
+
+// Acquire waits for an upload slot for a file of category (see the queue metrics) in eventID
+// Call release when the upload is done. Fails when ctx is done before a slot frees up.
+func (s *Scheduler) Acquire(ctx context.Context, category, eventID string) (release func(), err error) {
+	start := time.Now()
+	s.mu.Lock()
+	// Free slots are handed to waiters on release, so a free slot means nobody is waiting
+	if s.inUse < s.slots {
+		s.inUse++
+		s.mu.Unlock()
+		observability.RecordUploadQueueWait(category, 0)
+		return s.releaseFunc(), nil
+	}
+
+	w := &waiter{category: category, granted: make(chan struct{})}
+	if len(s.queues[eventID]) == 0 {
+		s.turns = append(s.turns, eventID)
+	}
+	s.queues[eventID] = append(s.queues[eventID], w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.granted:
+		observability.RecordUploadQueueWait(category, time.Since(start))
+		return s.releaseFunc(), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if w.done {
+			// Granted while we gave up: pass the slot on
+			s.inUse--
+			s.dispatchLocked()
+		}
+		w.done = true
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Scheduler) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.inUse--
+			s.dispatchLocked()
+		})
+	}
+}
+
+// dispatchLocked grants free slots to the most urgent waiters; callers hold mu
+func (s *Scheduler) dispatchLocked() {
+	for s.inUse < s.slots {
+		w := s.nextLocked()
+		if w == nil {
+			return
+		}
+		w.done = true
+		s.inUse++
+		close(w.granted)
+	}
+}
+
+// nextLocked pops the next waiter, events in turn
+// Cancelled waiters are skipped and dropped.
+func (s *Scheduler) nextLocked() *waiter {
+	for len(s.turns) > 0 {
+		eventID := s.turns[0]
+		queue := s.queues[eventID]
+		w := queue[0]
+		queue = queue[1:]
+
+		// The event goes to the back of the line if it still has waiters
+		s.turns = s.turns[1:]
+		if len(queue) > 0 {
+			s.queues[eventID] = queue
+			s.turns = append(s.turns, eventID)
+		} else {
+			delete(s.queues, eventID)
+		}
+
+		if !w.done {
+			return w
+		}
+	}
+	return nil
+}
+
+// Waiting returns the number of uploads waiting for a slot by category
+func (s *Scheduler) Waiting() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	waiting := make(map[string]int)
+	for _, queue := range s.queues {
+		for _, w := range queue {
+			if !w.done {
+				waiting[w.category]++
+			}
+		}
+	}
+	return waiting
+}
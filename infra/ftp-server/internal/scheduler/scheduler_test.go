@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queued records the order in which waiting uploads get their slot
+type queued struct {
+	mu    sync.Mutex
+	order []string
+	wg    sync.WaitGroup
+}
+
+// enqueue starts an upload named name and returns once it is waiting
+// Granted uploads note their name and free the slot straight away.
+func (q *queued) enqueue(t *testing.T, s *Scheduler, name, category, eventID string) {
+	t.Helper()
+	before := total(s.Waiting())
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		release, err := s.Acquire(context.Background(), category, eventID)
+		if err != nil {
+			t.Errorf("Acquire(%s) failed: %v", name, err)
+			return
+		}
+		q.mu.Lock()
+		q.order = append(q.order, name)
+		q.mu.Unlock()
+		release()
+	}()
+	waitUntil(t, func() bool { return total(s.Waiting()) == before+1 })
+}
+
+func total(waiting map[string]int) int {
+	n := 0
+	for _, v := range waiting {
+		n += v
+	}
+	return n
+}
+
+func waitUntil(t *testing.T, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScheduler_EventsTakeTurns(t *testing.T) {
+	s := New(1)
+	release, _ := s.Acquire(context.Background(), "image", "evt_busy")
+
+	q := &queued{}
+	for _, name := range []string{"busy1", "busy2", "busy3"} {
+		q.enqueue(t, s, name, "image", "evt_busy")
+	}
+	q.enqueue(t, s, "quiet1", "image", "evt_quiet")
+	q.enqueue(t, s, "quiet2", "image", "evt_quiet")
+
+	release()
+	q.wg.Wait()
+	if got := strings.Join(q.order, ","); got != "busy1,quiet1,busy2,quiet2,busy3" {
+		t.Errorf("Upload order = %s, want events alternating", got)
+	}
+}
+
+func TestScheduler_CancelledWaitersGiveWay(t *testing.T) {
+	s := New(1)
+	release, _ := s.Acquire(context.Background(), "image", "evt_1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := s.Acquire(ctx, "image", "evt_1")
+		cancelled <- err
+	}()
+	waitUntil(t, func() bool { return total(s.Waiting()) == 1 })
+
+	q := &queued{}
+	q.enqueue(t, s, "next.jpg", "image", "evt_1")
+
+	cancel()
+	if err := <-cancelled; err == nil {
+		t.Fatal("Cancelled Acquire should fail")
+	}
+	release()
+	q.wg.Wait()
+	if len(q.order) != 1 {
+		t.Fatalf("Waiting upload never got the slot: %v", q.order)
+	}
+
+	// Every slot is free again
+	for i := 0; i < s.Slots(); i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		if _, err := s.Acquire(ctx, "image", "evt_2"); err != nil {
+			t.Errorf("Slot leaked: %v", err)
+		}
+		cancel()
+	}
+}
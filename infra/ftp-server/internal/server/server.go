@@ -3,24 +3,83 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
 	ftpslog "github.com/fclairamb/go-log/slog"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/acmecert"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/adminserver"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/bandwidth"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/certmgr"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/driver"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/handoff"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/httpauth"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/limits"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/progress"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/proxyproto"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/publichost"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/scheduler"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/sftpserver"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/spool"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/spoolcrypt"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/tlspolicy"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/tlsprofile"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/transfer"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/tusserver"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/uploadbuf"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/webdavserver"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/webhook"
 )
 
 // Server wraps the FTP server(s) and manages their lifecycle
 type Server struct {
 	explicitServer *ftpserver.FtpServer // Explicit FTPS server (port 2121, AUTH TLS)
 	implicitServer *ftpserver.FtpServer // Implicit FTPS server (port 990, immediate TLS)
+	sftpServer     *sftpserver.Server   // SFTP server (optional, same auth and upload pipeline)
+	tusServer      *tusserver.Server    // tus resumable HTTP upload endpoint (optional)
+	webdavServer   *webdavserver.Server // Write-only WebDAV frontend (optional)
+	progressServer *progress.Server     // Live upload progress over SSE (optional)
+	adminServer    *adminserver.Server  // Operator API for live sessions (optional)
 	config         *config.Config
 	clientMgr      *clientmgr.Manager
+	certs          certificateService   // Serves the FTPS certificate (nil if TLS is not configured)
+	publicHost     *publichost.Resolver // PASV address behind NAT (nil = local IP)
+	limiter        *limits.Limiter      // Session/upload caps; refuses new FTP sessions while draining
+	spoolAPI       apiclient.APIClient  // Replays the shutdown spool (nil = no spool)
+	ftpDrivers     []*driver.MainDriver // Own the FTP control sockets passed on by HandOff
+	webhooks       *webhook.Dispatcher  // Outbound lifecycle webhooks (nil = disabled)
+	handedOff      atomic.Bool          // Listeners were passed to a new process; only sessions remain
+	shutDown       atomic.Bool          // Shutdown ran; later calls do nothing
+	listenMu       sync.Mutex           // Orders binding the FTP listeners in Start with closing them
+	spawn          func(argv []string, listeners map[string]*os.File) (*os.Process, error)
+
+	// Set by HandOff: the HTTP frontends finish their requests in progress in the background
+	frontendsStopped chan struct{}
+	cancelFrontends  context.CancelFunc
+}
+
+// listenerSource is a frontend whose listening socket HandOff passes to the new process
+type listenerSource interface {
+	ListenerFile() (string, *os.File, error)
+}
+
+// certificateService serves the FTPS certificate and keeps it current
+// Implemented by certmgr.Manager (file reload) and acmecert.Manager (ACME issuance)
+type certificateService interface {
+	driver.CertificateSource
+	Start() error
+	Stop()
 }
 
 // New creates FTP server instance(s) - explicit FTPS and optionally implicit FTPS
@@ -32,6 +91,7 @@ func New(cfg *config.Config, clientMgr *clientmgr.Manager) (*Server, error) {
 type TestServerOptions struct {
 	APIClient apiclient.APIClient
 	TLSConfig *tls.Config
+	Spawn     func(argv []string, listeners map[string]*os.File) (*os.Process, error) // replaces handoff.Spawn in HandOff
 }
 
 // NewWithClient creates FTP server with a custom API client (for testing)
@@ -45,13 +105,175 @@ func NewWithClient(cfg *config.Config, clientMgr *clientmgr.Manager, apiClient a
 // NewWithOptions creates FTP server with custom options (for testing)
 // Supports both explicit FTPS (main port) and implicit FTPS (if enabled in config)
 func NewWithOptions(cfg *config.Config, clientMgr *clientmgr.Manager, opts TestServerOptions) (*Server, error) {
+	// Reject unknown TLS profiles at startup rather than on the first handshake
+	for _, name := range []string{cfg.TLSProfile, cfg.FTPTLSProfile, cfg.ImplicitFTPSTLSProfile} {
+		if name == "" {
+			continue
+		}
+		if _, err := tlsprofile.Lookup(name); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load the TLS certificate once and share it between both listeners
+	var certs certificateService
+	var certSource driver.CertificateSource
+	if opts.TLSConfig == nil {
+		var err error
+		certs, err = newCertificateService(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if certs != nil {
+			certSource = certs
+		}
+	}
+
+	// Resolve the PASV public host once and share it between both listeners
+	var publicHost *publichost.Resolver
+	if cfg.FTPPublicHost != "" {
+		var err error
+		publicHost, err = publichost.New(cfg.FTPPublicHost, cfg.FTPPublicIPProbeURL, time.Duration(cfg.FTPPublicHostRefresh)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// ftpserverlib dials active data connections from port 20 or from any free port, nothing else
+	if cfg.FTPActiveModeEnabled {
+		if cfg.FTPActiveSourcePort != 0 && cfg.FTPActiveSourcePort != 20 {
+			return nil, fmt.Errorf("FTP_ACTIVE_SOURCE_PORT must be 20 or 0 (any free port), got %d", cfg.FTPActiveSourcePort)
+		}
+		log.Printf("[Server] Active mode enabled (source port %d)", cfg.FTPActiveSourcePort)
+	}
+
+	// Only load balancers in the trusted CIDRs may set the client address
+	if cfg.ProxyProtocolTrustedCIDRs != "" {
+		if _, err := proxyproto.ParseCIDRs(cfg.ProxyProtocolTrustedCIDRs); err != nil {
+			return nil, fmt.Errorf("invalid PROXY_PROTOCOL_TRUSTED_CIDRS: %w", err)
+		}
+		log.Printf("[Server] PROXY protocol accepted from %s", cfg.ProxyProtocolTrustedCIDRs)
+	}
+
+	// Session and upload caps are shared by both FTP listeners
+	caps := limits.FromConfig(cfg)
+	if err := caps.Validate(); err != nil {
+		return nil, err
+	}
+	limiter := limits.New(caps)
+	observability.SetLimitUsageSource(func() []observability.LimitUsage {
+		var samples []observability.LimitUsage
+		for _, u := range limiter.Usage() {
+			samples = append(samples, observability.LimitUsage{
+				Kind:  u.Kind,
+				Scope: u.Scope,
+				InUse: int64(u.InUse),
+				Limit: int64(u.Limit),
+			})
+		}
+		return samples
+	})
+	log.Printf("[Server] Limits: sessions=%d per_ip=%d per_event=%d uploads=%d per_ip=%d per_event=%d (0 = unlimited)",
+		caps.Sessions, caps.SessionsPerIP, caps.SessionsPerEvent, caps.Uploads, caps.UploadsPerIP, caps.UploadsPerEvent)
+
+	// Bandwidth shaping on both legs of every upload (adjustable at runtime through the admin API)
+	rates := bandwidth.FromConfig(cfg)
+	if err := rates.Validate(); err != nil {
+		return nil, err
+	}
+	shaper := clientMgr.Bandwidth()
+	shaper.SetLimits(rates)
+	observability.SetBandwidthLimitSource(func() map[string]int64 {
+		current := shaper.Limits()
+		return map[string]int64{
+			bandwidth.ScopeSession: current.Session,
+			bandwidth.ScopeEvent:   current.Event,
+			bandwidth.ScopeGlobal:  current.Global,
+		}
+	})
+	log.Printf("[Server] Bandwidth: session=%d event=%d global=%d bytes/s per leg (0 = unlimited)",
+		rates.Session, rates.Event, rates.Global)
+
+	// R2 PUTs wait for one of UploadSlots; events take turns
+	if cfg.UploadSlots < 0 {
+		return nil, fmt.Errorf("UPLOAD_SLOTS must be 0 (no queue) or positive, got %d", cfg.UploadSlots)
+	}
+	if cfg.UploadSlots > 0 {
+		queue := scheduler.New(cfg.UploadSlots)
+		clientMgr.SetScheduler(queue)
+		observability.SetUploadQueueSource(func() map[string]int64 {
+			waiting := make(map[string]int64)
+			for category, n := range queue.Waiting() {
+				waiting[category] = int64(n)
+			}
+			return waiting
+		})
+		log.Printf("[Server] R2 upload slots: %d", cfg.UploadSlots)
+	}
+
+	// Uploads are buffered on disk before the R2 PUT; new ones are refused while that disk is nearly full
+	if cfg.MaxFileSize < 0 {
+		return nil, fmt.Errorf("MAX_FILE_SIZE must be 0 (unlimited) or positive, got %d", cfg.MaxFileSize)
+	}
+	keys, err := spoolKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	buffer, err := uploadbuf.New(cfg.SpoolDir, int64(cfg.SpoolMinFreeBytes), keys)
+	if err != nil {
+		return nil, err
+	}
+	clientMgr.SetUploadBuffer(buffer)
+	clientMgr.SetMaxFileSize(int64(cfg.MaxFileSize))
+	observability.SetUploadBufferSource(func() map[string]int64 {
+		usage := map[string]int64{"buffered": buffer.Buffered()}
+		if free, total, err := buffer.Free(); err == nil {
+			usage["free"] = free
+			usage["total"] = total
+		}
+		return usage
+	})
+	log.Printf("[Server] Upload buffer: %s (min free %d bytes, max file %d bytes; 0 = off; encrypted=%t durable_keys=%t)",
+		buffer.Path(), buffer.MinFree(), cfg.MaxFileSize, keys != nil, keys.Durable())
+
+	// Client manager policies: event end and repeated failures (banned IPs get 421 at connect)
+	if cfg.PolicyMaxConsecutiveFailures < 0 || cfg.PolicyFailureBan < 0 {
+		return nil, fmt.Errorf("POLICY_MAX_CONSECUTIVE_FAILURES and POLICY_FAILURE_BAN must not be negative")
+	}
+	if cfg.PolicyDisconnectOnEventEnd {
+		clientMgr.AddPolicy(clientmgr.DisconnectOn(clientmgr.EventEventExpired, clientmgr.EventCreditsExhausted))
+	}
+	if cfg.PolicyMaxConsecutiveFailures > 0 {
+		clientMgr.AddPolicy(clientmgr.ConsecutiveFailures(cfg.PolicyMaxConsecutiveFailures, time.Duration(cfg.PolicyFailureBan)*time.Second))
+	}
+	limiter.SetBanCheck(clientMgr.CheckBan)
+	log.Printf("[Server] Policies: disconnect_on_event_end=%t max_consecutive_failures=%d failure_ban=%ds",
+		cfg.PolicyDisconnectOnEventEnd, cfg.PolicyMaxConsecutiveFailures, cfg.PolicyFailureBan)
+
+	// A policy requiring TLS can't be met without a certificate
+	policy := tlspolicy.Clear
+	if cfg.FTPTLSPolicy != "" {
+		var err error
+		if policy, err = tlspolicy.Parse(cfg.FTPTLSPolicy); err != nil {
+			return nil, err
+		}
+	}
+	if policy.RequiresControlTLS() && certSource == nil && opts.TLSConfig == nil {
+		return nil, fmt.Errorf("FTP_TLS_POLICY=%s requires a TLS certificate (TLS_CERT_PATH/TLS_KEY_PATH or ACME_ENABLED)", policy)
+	}
+	log.Printf("[Server] Explicit listener TLS policy: %s", policy)
+
 	// Create explicit FTPS driver (supports plain FTP and AUTH TLS upgrade)
 	var explicitDriver *driver.MainDriver
 	if opts.APIClient != nil {
 		explicitDriver = driver.NewMainDriverWithTLS(cfg, clientMgr, opts.APIClient, ftpserver.ClearOrEncrypted, opts.TLSConfig)
 	} else {
-		explicitDriver = driver.NewMainDriver(cfg, clientMgr)
+		explicitDriver = driver.NewMainDriver(cfg, clientMgr, certSource)
+	}
+	if publicHost != nil {
+		explicitDriver.SetPublicHost(publicHost)
 	}
+	explicitDriver.SetLimiter(limiter)
 	explicitServer := ftpserver.NewFtpServer(explicitDriver)
 
 	// Configure FTP protocol debug logging if enabled
@@ -71,6 +293,14 @@ func NewWithOptions(cfg *config.Config, clientMgr *clientmgr.Manager, opts TestS
 		explicitServer: explicitServer,
 		config:         cfg,
 		clientMgr:      clientMgr,
+		certs:          certs,
+		publicHost:     publicHost,
+		limiter:        limiter,
+		ftpDrivers:     []*driver.MainDriver{explicitDriver},
+		spawn:          handoff.Spawn,
+	}
+	if opts.Spawn != nil {
+		server.spawn = opts.Spawn
 	}
 
 	// Create implicit FTPS server if enabled (immediate TLS on separate port)
@@ -79,9 +309,14 @@ func NewWithOptions(cfg *config.Config, clientMgr *clientmgr.Manager, opts TestS
 		if opts.APIClient != nil {
 			implicitDriver = driver.NewMainDriverWithTLS(cfg, clientMgr, opts.APIClient, ftpserver.ImplicitEncryption, opts.TLSConfig)
 		} else {
-			implicitDriver = driver.NewMainDriverImplicit(cfg, clientMgr)
+			implicitDriver = driver.NewMainDriverImplicit(cfg, clientMgr, certSource)
+		}
+		if publicHost != nil {
+			implicitDriver.SetPublicHost(publicHost)
 		}
+		implicitDriver.SetLimiter(limiter)
 		server.implicitServer = ftpserver.NewFtpServer(implicitDriver)
+		server.ftpDrivers = append(server.ftpDrivers, implicitDriver)
 
 		// Share the same logger if debug is enabled
 		if cfg.FTPDebug {
@@ -96,15 +331,224 @@ func NewWithOptions(cfg *config.Config, clientMgr *clientmgr.Manager, opts TestS
 		log.Printf("[Server] Implicit FTPS server DISABLED (set IMPLICIT_FTPS_ENABLED=true to enable)")
 	}
 
+	// Create SFTP server if enabled (shares the client manager so sessions are tracked together)
+	if cfg.SFTPEnabled {
+		sftpServer, err := sftpserver.New(cfg, clientMgr, frontendAPIClient(cfg, opts))
+		if err != nil {
+			return nil, err
+		}
+		sftpServer.SetLimiter(limiter)
+		server.sftpServer = sftpServer
+
+		log.Printf("[Server] SFTP server ENABLED on %s", cfg.SFTPListenAddress)
+	}
+
+	// Create tus endpoint if enabled (HTTPS when a certificate is configured)
+	if cfg.TUSEnabled {
+		tlsConfig := httpTLSConfig(opts, certs)
+		server.tusServer = tusserver.New(cfg, clientMgr, frontendAPIClient(cfg, opts), tlsConfig)
+
+		log.Printf("[Server] tus upload endpoint ENABLED on %s (tls=%t)", cfg.TUSListenAddress, tlsConfig != nil)
+	}
+
+	// Create WebDAV frontend if enabled (HTTPS when a certificate is configured)
+	if cfg.WebDAVEnabled {
+		tlsConfig := httpTLSConfig(opts, certs)
+		server.webdavServer = webdavserver.New(cfg, clientMgr, frontendAPIClient(cfg, opts), tlsConfig)
+
+		log.Printf("[Server] WebDAV frontend ENABLED on %s (tls=%t)", cfg.WebDAVListenAddress, tlsConfig != nil)
+	}
+
+	// Create upload progress stream if enabled (HTTPS when a certificate is configured)
+	if cfg.ProgressEnabled {
+		if err := progress.ValidateConfig(cfg); err != nil {
+			return nil, err
+		}
+		tlsConfig := httpTLSConfig(opts, certs)
+		server.progressServer = progress.New(cfg, clientMgr, frontendAPIClient(cfg, opts), tlsConfig)
+		clientMgr.SetUploadObserver(server.progressServer.Publish)
+
+		log.Printf("[Server] Upload progress stream ENABLED on %s (tls=%t)", cfg.ProgressListenAddress, tlsConfig != nil)
+	}
+
+	// Uploads still unsent when the shutdown deadline passes are kept and retried on the next start
+	if cfg.ShutdownSpoolDir != "" {
+		if cfg.FTPJWTSecret == "" {
+			return nil, fmt.Errorf("SHUTDOWN_SPOOL_DIR requires FTP_JWT_SECRET (spooled uploads are re-authenticated on replay, their session token is not stored)")
+		}
+		uploadSpool, err := spool.New(cfg.ShutdownSpoolDir)
+		if err != nil {
+			return nil, err
+		}
+		clientMgr.SetSpool(uploadSpool)
+		server.spoolAPI = frontendAPIClient(cfg, opts)
+		log.Printf("[Server] Shutdown spool: %s", cfg.ShutdownSpoolDir)
+	}
+
+	// Report each ended session to the API (best effort, off the disconnect path)
+	if cfg.SessionSummaryReportEnabled {
+		reportAPI := frontendAPIClient(cfg, opts)
+		clientMgr.SetSummaryReporter(func(summary clientmgr.SessionSummary) {
+			reportSessionSummary(reportAPI, summary)
+		})
+		log.Printf("[Server] Session summaries reported to the API")
+	}
+
+	// Signed webhooks fire from the same points as the upload/session log events
+	webhooks, err := webhook.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if webhooks != nil {
+		server.webhooks = webhooks
+		clientMgr.SetWebhookSink(func(event clientmgr.ClientEvent, session clientmgr.ClientInfo, reason string) {
+			webhooks.Publish(webhook.EventPolicyTriggered, map[string]any{
+				"trigger":    event.Type.String(),
+				"reason":     reason,
+				"session_id": session.ID,
+				"client_ip":  session.ClientIP,
+				"protocol":   session.Protocol,
+				"event_id":   session.EventID,
+			})
+		})
+		log.Printf("[Server] Webhooks ENABLED: %d sink(s)", len(webhooks.Sinks()))
+	}
+
+	// Create admin API if enabled (localhost-only unless a token is set)
+	if cfg.AdminEnabled {
+		if err := adminserver.ValidateConfig(cfg); err != nil {
+			return nil, err
+		}
+		server.adminServer = adminserver.New(cfg, clientMgr)
+
+		log.Printf("[Server] Admin API ENABLED on %s (token=%t)", cfg.AdminListenAddress, cfg.AdminToken != "")
+	}
+
 	log.Printf("[Server] FTP server(s) created successfully")
 	return server, nil
 }
 
+// spoolKeys reads the SPOOL_ENCRYPTION settings (nil = files stay in plaintext)
+// Keys held only in memory are lost on restart, so the shutdown spool needs a master key.
+func spoolKeys(cfg *config.Config) (*spoolcrypt.Keys, error) {
+	if !cfg.SpoolEncryption {
+		return nil, nil
+	}
+	if cfg.SpoolEncryptionKey == "" {
+		if cfg.ShutdownSpoolDir != "" {
+			return nil, fmt.Errorf("SHUTDOWN_SPOOL_DIR with SPOOL_ENCRYPTION requires SPOOL_ENCRYPTION_KEY, or spooled files could not be decrypted after the restart")
+		}
+		return spoolcrypt.New(nil), nil
+	}
+	master, err := spoolcrypt.ParseMasterKey(cfg.SpoolEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return spoolcrypt.New(master), nil
+}
+
+// reportSessionSummary posts an ended session's totals to the API
+func reportSessionSummary(apiClient apiclient.APIClient, summary clientmgr.SessionSummary) {
+	if summary.Token == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := apiclient.SessionSummaryRequest{
+		Protocol:          summary.Protocol,
+		ClientIP:          summary.ClientIP,
+		ConnectedAt:       summary.ConnectedAt.UTC().Format(time.RFC3339),
+		DisconnectedAt:    summary.DisconnectedAt.UTC().Format(time.RFC3339),
+		FilesOK:           summary.Stats.FilesOK,
+		FilesFailed:       summary.Stats.FilesFailed,
+		Bytes:             summary.Stats.Bytes,
+		LastError:         summary.Stats.LastError,
+		AvgThroughputMBps: summary.Stats.ThroughputMBps(),
+	}
+	if !summary.Stats.LastUploadAt.IsZero() {
+		req.LastUploadAt = summary.Stats.LastUploadAt.UTC().Format(time.RFC3339)
+	}
+
+	if err := apiClient.ReportSessionSummary(ctx, summary.Token, req); err != nil {
+		log.Printf("session_summary_report_failed id=%d event=%s error=%v", summary.ClientID, summary.EventID, err)
+	}
+}
+
+// frontendAPIClient returns the API client for the non-FTP frontends
+func frontendAPIClient(cfg *config.Config, opts TestServerOptions) apiclient.APIClient {
+	if opts.APIClient != nil {
+		return opts.APIClient
+	}
+	return apiclient.NewClient(cfg.APIURL)
+}
+
+// httpTLSConfig returns the TLS config for the HTTP frontends (nil = plain HTTP)
+func httpTLSConfig(opts TestServerOptions, certs certificateService) *tls.Config {
+	if opts.TLSConfig != nil {
+		return opts.TLSConfig
+	}
+	if certs == nil {
+		return nil
+	}
+	return &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// newCertificateService picks the certificate source from config: ACME, cert/key files, or none
+func newCertificateService(cfg *config.Config) (certificateService, error) {
+	if cfg.ACMEEnabled {
+		log.Printf("[Server] TLS certificates provisioned via ACME for %s (%s)", cfg.ACMEHostname, cfg.ACMEChallenge)
+		return acmecert.New(cfg)
+	}
+
+	if cfg.TLSCertPath != "" && cfg.TLSKeyPath != "" {
+		log.Printf("[Server] TLS certificate reload enabled (every %ds or on SIGHUP)", cfg.TLSCertReloadInterval)
+		return certmgr.New(cfg.TLSCertPath, cfg.TLSKeyPath, time.Duration(cfg.TLSCertReloadInterval)*time.Second)
+	}
+
+	return nil, nil
+}
+
 // Start starts the FTP server(s) and blocks until they stop
 func (s *Server) Start() error {
 	log.Printf("[Server] Starting explicit FTPS server on %s", s.config.FTPListenAddress)
 	log.Printf("[Server] Passive port range: %d-%d", s.config.FTPPassivePortStart, s.config.FTPPassivePortEnd)
 
+	// Keep the certificate current so renewals apply without dropping connected cameras
+	if s.certs != nil {
+		if err := s.certs.Start(); err != nil {
+			return err
+		}
+	}
+
+	// Keep a hostname or auto-detected PASV address current (dynamic DNS, changing NAT egress)
+	if s.publicHost != nil {
+		s.publicHost.Start()
+	}
+
+	// Deliver upload and session events to the webhook sinks
+	if s.webhooks != nil {
+		s.webhooks.Start()
+		observability.SetLogHook(s.webhooks.HandleLog)
+	}
+
+	// Files a crashed process left in the upload buffer are recovered into the spool or deleted
+	s.sweepUploadBuffer()
+
+	// Send uploads the previous process spooled at shutdown
+	if s.spoolAPI != nil {
+		go s.replaySpool()
+	}
+
+	// Bind the FTP listeners before serving them, so Shutdown can't race the bind
+	if err := s.listenFTP(); err != nil {
+		return err
+	}
+
 	// Start implicit FTPS server in background if enabled
 	if s.implicitServer != nil {
 		log.Printf("[Server] Starting implicit FTPS server on %s", s.config.ImplicitFTPSPort)
@@ -113,7 +557,7 @@ func (s *Server) Start() error {
 		implicitErrChan := make(chan error, 1)
 
 		go func() {
-			if err := s.implicitServer.ListenAndServe(); err != nil {
+			if err := s.implicitServer.Serve(); err != nil {
 				log.Printf("[Server] Implicit FTPS server stopped with error: %v", err)
 				implicitErrChan <- err
 			} else {
@@ -130,8 +574,73 @@ func (s *Server) Start() error {
 		}()
 	}
 
+	// Start SFTP server in background if enabled
+	if s.sftpServer != nil {
+		log.Printf("[Server] Starting SFTP server on %s", s.config.SFTPListenAddress)
+
+		go func() {
+			if err := s.sftpServer.ListenAndServe(); err != nil {
+				log.Printf("[Server] ERROR: SFTP server failed: %v", err)
+			} else {
+				log.Printf("[Server] SFTP server stopped gracefully")
+			}
+		}()
+	}
+
+	// Start tus endpoint in background if enabled
+	if s.tusServer != nil {
+		log.Printf("[Server] Starting tus upload endpoint on %s", s.config.TUSListenAddress)
+
+		go func() {
+			if err := s.tusServer.ListenAndServe(); err != nil {
+				log.Printf("[Server] ERROR: tus upload endpoint failed: %v", err)
+			} else {
+				log.Printf("[Server] tus upload endpoint stopped gracefully")
+			}
+		}()
+	}
+
+	// Start WebDAV frontend in background if enabled
+	if s.webdavServer != nil {
+		log.Printf("[Server] Starting WebDAV frontend on %s", s.config.WebDAVListenAddress)
+
+		go func() {
+			if err := s.webdavServer.ListenAndServe(); err != nil {
+				log.Printf("[Server] ERROR: WebDAV frontend failed: %v", err)
+			} else {
+				log.Printf("[Server] WebDAV frontend stopped gracefully")
+			}
+		}()
+	}
+
+	// Start upload progress stream in background if enabled
+	if s.progressServer != nil {
+		log.Printf("[Server] Starting upload progress stream on %s", s.config.ProgressListenAddress)
+
+		go func() {
+			if err := s.progressServer.ListenAndServe(); err != nil {
+				log.Printf("[Server] ERROR: upload progress stream failed: %v", err)
+			} else {
+				log.Printf("[Server] Upload progress stream stopped gracefully")
+			}
+		}()
+	}
+
+	// Start admin API in background if enabled
+	if s.adminServer != nil {
+		log.Printf("[Server] Starting admin API on %s", s.config.AdminListenAddress)
+
+		go func() {
+			if err := s.adminServer.ListenAndServe(); err != nil {
+				log.Printf("[Server] ERROR: admin API failed: %v", err)
+			} else {
+				log.Printf("[Server] Admin API stopped gracefully")
+			}
+		}()
+	}
+
 	// Start explicit FTPS server (blocks until stopped)
-	if err := s.explicitServer.ListenAndServe(); err != nil {
+	if err := s.explicitServer.Serve(); err != nil {
 		log.Printf("[Server] Explicit FTPS server stopped with error: %v", err)
 		return err
 	}
@@ -140,17 +649,201 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// listenFTP binds the explicit and implicit FTP listeners
+func (s *Server) listenFTP() error {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	if s.shutDown.Load() {
+		return errors.New("server is shut down")
+	}
+	if s.implicitServer != nil {
+		if err := s.implicitServer.Listen(); err != nil {
+			return fmt.Errorf("implicit FTPS listener: %w", err)
+		}
+	}
+	if err := s.explicitServer.Listen(); err != nil {
+		if s.implicitServer != nil {
+			s.implicitServer.Stop()
+		}
+		return fmt.Errorf("explicit FTPS listener: %w", err)
+	}
+	return nil
+}
+
 // Shutdown performs graceful shutdown of the FTP server(s)
+// It returns an error when uploads were still in flight at the drain deadline (they were spooled or lost).
+// Only the first call does anything.
 func (s *Server) Shutdown(ctx context.Context) error {
-	log.Printf("[Server] Graceful shutdown requested for all FTP servers")
+	if s.shutDown.Swap(true) {
+		return nil
+	}
+	log.Printf("[Server] Graceful shutdown requested - draining until the deadline")
+
+	// Refuse new work: FTP connections get 421, logins and uploads on every frontend are refused
+	s.limiter.Drain()
+	s.clientMgr.BeginDrain()
+	if !s.handedOff.Load() {
+		s.stopSFTP()
+	}
+
+	// FTP sessions get a 421 once their current transfer is done; the other protocols have no
+	// way to be told and learn about the drain when their next login or upload is refused
+	notice := "Server shutting down, closing control connection"
+	if s.handedOff.Load() {
+		notice = "Server restarting, reconnect to continue"
+	}
+	warned := make(map[uint32]bool)
+	for _, id := range s.clientMgr.CloseWithReply(ftpserver.StatusServiceNotAvailable, notice) {
+		warned[id] = true
+	}
+	for _, info := range s.clientMgr.Clients() {
+		log.Printf("drain_session_active id=%d ip=%s protocol=%s event=%s uploads=%d warned=%t",
+			info.ID, info.ClientIP, info.Protocol, info.EventID, len(info.Uploads), warned[info.ID])
+	}
+
+	// Let in-flight transfers and their R2 uploads finish, keeping the last SpoolGrace of ctx
+	// for the ones still running to reach the spool
+	drainCtx, cancelDrain := context.WithCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		drainCtx, cancelDrain = context.WithDeadline(ctx, deadline.Add(-SpoolGrace))
+	}
+	started := time.Now()
+	drainErr := s.clientMgr.WaitForUploads(drainCtx)
+	if drainErr != nil {
+		pending := s.clientMgr.UploadCount()
+		log.Printf("drain_timeout pending_uploads=%d waited_ms=%d", pending, time.Since(started).Milliseconds())
+		drainErr = fmt.Errorf("%d uploads still in flight at the shutdown deadline: %w", pending, drainErr)
+	} else {
+		log.Printf("drain_complete waited_ms=%d", time.Since(started).Milliseconds())
+	}
+
+	// After a handoff the listeners are already closed (and now belong to the new process);
+	// only the HTTP requests still in progress here may remain
+	if s.handedOff.Load() {
+		select {
+		case <-s.frontendsStopped:
+		case <-drainCtx.Done():
+			s.cancelFrontends()
+			<-s.frontendsStopped
+		}
+	} else {
+		s.stopFrontends(drainCtx)
+	}
+	cancelDrain()
+
+	// Stopping the client manager last cancels the remaining R2 uploads; complete files
+	// go to the spool (if configured) while we wait a moment for them
+	log.Printf("[Server] Stopping client manager")
+	s.clientMgr.Stop()
+
+	graceCtx, cancel := context.WithTimeout(ctx, SpoolGrace)
+	if err := s.clientMgr.WaitForUploads(graceCtx); err != nil {
+		log.Printf("drain_uploads_lost count=%d spool=%t", s.clientMgr.UploadCount(), s.clientMgr.Spool() != nil)
+	}
+	cancel()
+
+	// Deliver the last session events before exiting
+	if s.webhooks != nil {
+		observability.SetLogHook(nil)
+		webhookCtx, cancel := context.WithTimeout(context.Background(), SpoolGrace)
+		s.webhooks.Stop(webhookCtx)
+		cancel()
+	}
+
+	if s.certs != nil {
+		s.certs.Stop()
+	}
+
+	if s.publicHost != nil {
+		s.publicHost.Stop()
+	}
+
+	log.Printf("[Server] Shutdown complete")
+	return drainErr
+}
+
+// HandOff starts a new process on the same sockets (graceful restart) and stops accepting here
+// Sessions already connected keep running and HTTP requests in progress (tus and WebDAV uploads)
+// finish; the caller waits for them (WaitForSessions), then calls Shutdown.
+func (s *Server) HandOff() (*os.Process, error) {
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, source := range s.listenerSources() {
+		name, file, err := source.ListenerFile()
+		if err != nil {
+			return nil, fmt.Errorf("cannot hand off the %s listener: %w", name, err)
+		}
+		files[name] = file
+	}
+
+	child, err := s.spawn(os.Args, files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the new process: %w", err)
+	}
+	log.Printf("handoff_started child_pid=%d sessions=%d listeners=%d", child.Pid, s.clientMgr.ClientCount(), len(files))
+
+	s.handedOff.Store(true)
+	s.stopSFTP()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelFrontends = cancel
+	s.frontendsStopped = make(chan struct{})
+	go func() {
+		defer close(s.frontendsStopped)
+		s.stopFrontends(ctx)
+	}()
+	return child, nil
+}
+
+// listenerSources lists every listening socket a graceful restart passes on
+func (s *Server) listenerSources() []listenerSource {
+	var sources []listenerSource
+	for _, d := range s.ftpDrivers {
+		sources = append(sources, d)
+	}
+	if s.sftpServer != nil {
+		sources = append(sources, s.sftpServer)
+	}
+	if s.tusServer != nil {
+		sources = append(sources, s.tusServer)
+	}
+	if s.webdavServer != nil {
+		sources = append(sources, s.webdavServer)
+	}
+	if s.progressServer != nil {
+		sources = append(sources, s.progressServer)
+	}
+	if s.adminServer != nil {
+		sources = append(sources, s.adminServer)
+	}
+	return sources
+}
 
-	// Stop the client manager event processing
-	if s.clientMgr != nil {
-		log.Printf("[Server] Stopping client manager")
-		s.clientMgr.Stop()
+// WaitForSessions blocks until every session on this process has ended or ctx is done
+func (s *Server) WaitForSessions(ctx context.Context) error {
+	return s.clientMgr.WaitForSessions(ctx)
+}
+
+// stopSFTP closes the SFTP listener; connected SFTP sessions continue
+func (s *Server) stopSFTP() {
+	if s.sftpServer != nil {
+		log.Printf("[Server] Stopping SFTP server")
+		if err := s.sftpServer.Stop(); err != nil {
+			log.Printf("[Server] Error stopping SFTP server: %v", err)
+		}
 	}
+}
+
+// stopFTP closes both FTP listeners; connected FTP sessions continue
+func (s *Server) stopFTP() {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
 
-	// Stop FTP servers
 	if s.implicitServer != nil {
 		log.Printf("[Server] Stopping implicit FTPS server")
 		if err := s.implicitServer.Stop(); err != nil {
@@ -162,7 +855,86 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	if err := s.explicitServer.Stop(); err != nil {
 		log.Printf("[Server] Error stopping explicit server: %v", err)
 	}
+}
 
-	log.Printf("[Server] Shutdown complete")
-	return nil
+// stopFrontends closes every listener, then lets the HTTP frontends finish the requests in
+// progress until ctx is done (connected FTP sessions are not affected)
+func (s *Server) stopFrontends(ctx context.Context) {
+	s.stopFTP()
+
+	type httpFrontend struct {
+		name string
+		stop func(context.Context) error
+	}
+	var frontends []httpFrontend
+	if s.tusServer != nil {
+		frontends = append(frontends, httpFrontend{"tus upload endpoint", s.tusServer.Stop})
+	}
+	if s.webdavServer != nil {
+		frontends = append(frontends, httpFrontend{"WebDAV frontend", s.webdavServer.Stop})
+	}
+	if s.progressServer != nil {
+		frontends = append(frontends, httpFrontend{"upload progress stream", s.progressServer.Stop})
+	}
+	if s.adminServer != nil {
+		frontends = append(frontends, httpFrontend{"admin API", s.adminServer.Stop})
+	}
+
+	var wg sync.WaitGroup
+	for _, frontend := range frontends {
+		log.Printf("[Server] Stopping %s", frontend.name)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := frontend.stop(ctx); err != nil {
+				log.Printf("[Server] Error stopping %s: %v", frontend.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// SpoolGrace is the part of the Shutdown deadline kept for cancelled uploads to reach the spool
+// (callers add it to the drain time they want)
+const SpoolGrace = 5 * time.Second
+
+// sweepUploadBuffer deals with orphaned buffer files before new uploads arrive
+// Complete files go to the shutdown spool (and are replayed next) when one is configured.
+func (s *Server) sweepUploadBuffer() {
+	buffer := s.clientMgr.UploadBuffer()
+	var recoverFile func(entry spool.Entry, dataPath string) error
+	if uploadSpool := s.clientMgr.Spool(); uploadSpool != nil {
+		recoverFile = func(entry spool.Entry, dataPath string) error {
+			return uploadSpool.Save(dataPath, entry)
+		}
+	}
+	recovered, removed := buffer.Sweep(recoverFile)
+	if recovered+removed > 0 {
+		log.Printf("upload_buffer_swept dir=%s recovered=%d removed=%d", buffer.Path(), recovered, removed)
+	}
+}
+
+// spoolTokenTTL is how long the token signed for one spooled upload is valid
+const spoolTokenTTL = 15 * time.Minute
+
+// replaySpool uploads files spooled by the previous process
+// Each one is re-authenticated with a token signed for its photographer and event. Entries the
+// API rejects (or that can't be authenticated) are dropped; other failures stay for the next start.
+func (s *Server) replaySpool() {
+	uploadSpool := s.clientMgr.Spool()
+	sent, dropped, kept := uploadSpool.Replay(context.Background(),
+		func(ctx context.Context, entry spool.Entry, dataPath string) error {
+			token, err := httpauth.IssueToken(s.config.FTPJWTSecret, entry.EventID, entry.PhotographerID, spoolTokenTTL)
+			if err != nil {
+				return err
+			}
+			return transfer.UploadSpooled(ctx, s.spoolAPI, s.clientMgr, s.clientMgr.UploadBuffer().Keys(), entry, token, dataPath)
+		},
+		func(err error) bool {
+			return errors.Is(err, apiclient.ErrUnauthorized) || errors.Is(err, httpauth.ErrInvalidToken)
+		},
+	)
+	if sent+dropped+kept > 0 {
+		log.Printf("spool_replayed dir=%s sent=%d dropped=%d kept=%d", uploadSpool.Dir(), sent, dropped, kept)
+	}
 }
@@ -1,36 +1,61 @@
 package server_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
 	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jlaffaye/ftp"
+	"github.com/pkg/sftp"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/httpauth"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/server"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/transfer"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/webhook"
+	"golang.org/x/crypto/ssh"
 )
 
 // TestEnv holds the test environment
 type TestEnv struct {
-	Server           *server.Server
-	MockAPI          *apiclient.MockClient
-	Config           *config.Config
-	ClientMgr        *clientmgr.Manager
-	ExplicitAddr     string // Address for plain FTP and explicit FTPS
-	ImplicitAddr     string // Address for implicit FTPS (if enabled)
-	TLSConfig        *tls.Config
+	Server       *server.Server
+	MockAPI      *apiclient.MockClient
+	Config       *config.Config
+	ClientMgr    *clientmgr.Manager
+	ExplicitAddr string // Address for plain FTP and explicit FTPS
+	ImplicitAddr string // Address for implicit FTPS (if enabled)
+	TLSConfig    *tls.Config
 }
 
 // generateTestCert creates a self-signed certificate for testing
@@ -70,6 +95,79 @@ func generateTestCert() (*tls.Config, error) {
 	}, nil
 }
 
+// testCA is a locally generated CA for issuing client certificates in tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// generateTestCA creates a self-signed CA for client certificate tests
+func generateTestCA() (*testCA, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(100),
+		Subject: pkix.Name{
+			Organization: []string{"SabaiPics Test Devices CA"},
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+
+	return &testCA{
+		cert: cert,
+		key:  privateKey,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+	}, nil
+}
+
+// issueClientCert creates a device client certificate signed by the CA
+// Returns the certificate and the hex SHA-256 fingerprint of its DER
+func (ca *testCA) issueClientCert(commonName string) (tls.Certificate, string, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		NotBefore:   time.Now(),
+		NotAfter:    time.Now().Add(24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, &privateKey.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	sum := sha256.Sum256(certDER)
+	return tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  privateKey,
+	}, hex.EncodeToString(sum[:]), nil
+}
+
 // findAvailablePort returns an available TCP port
 func findAvailablePort(t *testing.T) string {
 	t.Helper()
@@ -96,6 +194,8 @@ func SetupTestEnv(t *testing.T) *TestEnv {
 		FTPPassivePortEnd:   0,
 		FTPIdleTimeout:      30,
 		FTPDebug:            testing.Verbose(),
+		FTPEPSVEnabled:      true,
+		FTPEPRTEnabled:      true,
 	}
 
 	mgr := clientmgr.NewManager()
@@ -124,6 +224,19 @@ func SetupTestEnv(t *testing.T) *TestEnv {
 // SetupMultiModeTestEnv creates a test environment supporting all connection types
 func SetupMultiModeTestEnv(t *testing.T) *TestEnv {
 	t.Helper()
+	return SetupMultiModeTestEnvWithConfig(t, nil)
+}
+
+// SetupMultiModeTestEnvWithConfig is SetupMultiModeTestEnv with a hook to adjust the config
+func SetupMultiModeTestEnvWithConfig(t *testing.T, configure func(cfg *config.Config)) *TestEnv {
+	t.Helper()
+	return setupMultiModeTestEnv(t, configure, server.TestServerOptions{}, nil)
+}
+
+// setupMultiModeTestEnv builds the multi-mode environment; opts supplies anything but the API
+// client and TLS config, and prepare (optional) sees the client manager before the server does
+func setupMultiModeTestEnv(t *testing.T, configure func(cfg *config.Config), opts server.TestServerOptions, prepare func(mgr *clientmgr.Manager)) *TestEnv {
+	t.Helper()
 
 	explicitAddr := findAvailablePort(t)
 	implicitAddr := findAvailablePort(t)
@@ -142,17 +255,24 @@ func SetupMultiModeTestEnv(t *testing.T) *TestEnv {
 		FTPPassivePortEnd:   0,
 		FTPIdleTimeout:      30,
 		FTPDebug:            testing.Verbose(),
+		FTPEPSVEnabled:      true,
+		FTPEPRTEnabled:      true,
 		ImplicitFTPSEnabled: true,
 		ImplicitFTPSPort:    implicitAddr,
 	}
+	if configure != nil {
+		configure(cfg)
+	}
 
 	mgr := clientmgr.NewManager()
+	if prepare != nil {
+		prepare(mgr)
+	}
 	mgr.Start()
 
-	ftpServer, err := server.NewWithOptions(cfg, mgr, server.TestServerOptions{
-		APIClient: mockAPI,
-		TLSConfig: tlsConfig,
-	})
+	opts.APIClient = mockAPI
+	opts.TLSConfig = tlsConfig
+	ftpServer, err := server.NewWithOptions(cfg, mgr, opts)
 	if err != nil {
 		t.Fatalf("Failed to create FTP server: %v", err)
 	}
@@ -196,7 +316,7 @@ func waitForServer(t *testing.T, addr string, timeout time.Duration) {
 // Cleanup shuts down all resources
 func (te *TestEnv) Cleanup(t *testing.T) {
 	t.Helper()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second+server.SpoolGrace)
 	defer cancel()
 	te.Server.Shutdown(ctx)
 }
@@ -249,6 +369,33 @@ func (te *TestEnv) ConnectImplicitFTPS(t *testing.T) *ftp.ServerConn {
 	return conn
 }
 
+// ConnectRawFTP opens a plain control connection for commands the ftp client doesn't expose
+func (te *TestEnv) ConnectRawFTP(t *testing.T) *textproto.Conn {
+	t.Helper()
+	conn, err := textproto.Dial("tcp", te.ExplicitAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect raw control connection: %v", err)
+	}
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		conn.Close()
+		t.Fatalf("Unexpected greeting: %v", err)
+	}
+	return conn
+}
+
+// rawCmd sends a command on a raw control connection and reads the reply
+func rawCmd(t *testing.T, conn *textproto.Conn, expectCode int, format string, args ...any) string {
+	t.Helper()
+	if _, err := conn.Cmd(format, args...); err != nil {
+		t.Fatalf("Failed to send %q: %v", format, err)
+	}
+	_, msg, err := conn.ReadResponse(expectCode)
+	if err != nil {
+		t.Fatalf("Command %q: %v", format, err)
+	}
+	return msg
+}
+
 // =============================================================================
 // Connection Type Tests - Verify server supports all connection modes
 // =============================================================================
@@ -438,6 +585,56 @@ func TestE2E_LargeFile(t *testing.T) {
 }
 
 // TestE2E_DownloadBlocked tests that downloads are blocked
+// dialEPSV opens the data connection announced by an EPSV reply
+func dialEPSV(t *testing.T, reply string) net.Conn {
+	t.Helper()
+	start, end := strings.Index(reply, "(|||"), strings.LastIndex(reply, "|)")
+	if start < 0 || end < start {
+		t.Fatalf("Unexpected EPSV reply %q", reply)
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", reply[start+4:end]), 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to open data connection: %v", err)
+	}
+	return conn
+}
+
+// TestE2E_BrokenDataConnectionDiscardsPartialUpload checks that a STOR whose data connection
+// is reset mid-transfer (on any listener) is not sent to R2 as a truncated photo
+func TestE2E_BrokenDataConnectionDiscardsPartialUpload(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup(t)
+
+	conn := env.ConnectRawFTP(t)
+	defer conn.Close()
+	rawCmd(t, conn, 331, "USER testuser")
+	rawCmd(t, conn, 230, "PASS testpass")
+	rawCmd(t, conn, 200, "TYPE I")
+	data := dialEPSV(t, rawCmd(t, conn, 229, "EPSV"))
+
+	if _, err := conn.Cmd("STOR partial.jpg"); err != nil {
+		t.Fatalf("Failed to send STOR: %v", err)
+	}
+	if _, _, err := conn.ReadResponse(150); err != nil {
+		t.Fatalf("STOR not accepted: %v", err)
+	}
+	data.Write(bytes.Repeat([]byte("x"), 64<<10))
+	time.Sleep(100 * time.Millisecond)
+	data.(*net.TCPConn).SetLinger(0) // close with RST, as a camera losing Wi-Fi would appear
+	data.Close()
+
+	if code, msg, _ := conn.ReadResponse(0); code == 226 {
+		t.Fatalf("Reset transfer reported as complete: %d %s", code, msg)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got := env.MockAPI.GetPresignCallCount(); got != 0 {
+		t.Errorf("Expected the partial file to be discarded, got %d presign calls", got)
+	}
+	if clients := env.ClientMgr.Clients(); len(clients) != 1 || clients[0].Stats.FilesOK != 0 {
+		t.Errorf("Expected no completed upload, got %+v", clients)
+	}
+}
+
 func TestE2E_DownloadBlocked(t *testing.T) {
 	env := SetupTestEnv(t)
 	defer env.Cleanup(t)
@@ -509,3 +706,2767 @@ func TestE2E_DirectoryOps(t *testing.T) {
 		t.Logf("LIST returned %d entries", len(entries))
 	}
 }
+
+// TestE2E_WelcomeAndSiteStatus tests the event-aware login reply and SITE commands
+func TestE2E_WelcomeAndSiteStatus(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup(t)
+
+	conn := env.ConnectRawFTP(t)
+	defer conn.Close()
+
+	rawCmd(t, conn, 331, "USER test")
+	welcome := rawCmd(t, conn, 230, "PASS pass")
+	if !strings.Contains(welcome, "Test Event") || !strings.Contains(welcome, "1000 credits") {
+		t.Errorf("Welcome message missing event details: %q", welcome)
+	}
+
+	status := rawCmd(t, conn, 200, "SITE STATUS")
+	for _, want := range []string{"Test Event", "evt_test123", "Upload window ends", "Credits remaining at login: 1000", "0 uploaded"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("SITE STATUS missing %q: %q", want, status)
+		}
+	}
+
+	if credits := rawCmd(t, conn, 200, "SITE CREDITS"); !strings.Contains(credits, "1000") {
+		t.Errorf("SITE CREDITS = %q", credits)
+	}
+	if event := rawCmd(t, conn, 200, "SITE EVENT"); !strings.Contains(event, "evt_test123") {
+		t.Errorf("SITE EVENT = %q", event)
+	}
+}
+
+// =============================================================================
+// Client Certificate Tests - Registered camera devices (mutual TLS)
+// =============================================================================
+
+// setupClientCertTestEnv starts a multi-mode server that accepts device certificates from a test CA
+func setupClientCertTestEnv(t *testing.T) (*TestEnv, *testCA) {
+	t.Helper()
+
+	ca, err := generateTestCA()
+	if err != nil {
+		t.Fatalf("Failed to generate test CA: %v", err)
+	}
+	caPath := filepath.Join(t.TempDir(), "devices-ca.pem")
+	if err := os.WriteFile(caPath, ca.pem, 0600); err != nil {
+		t.Fatalf("Failed to write test CA: %v", err)
+	}
+
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.TLSClientAuthEnabled = true
+		cfg.TLSClientCAPath = caPath
+	})
+	return env, ca
+}
+
+// TestE2E_ClientCertAuth verifies registered device certificates log in without a password
+// on both the explicit and implicit FTPS listeners
+func TestE2E_ClientCertAuth(t *testing.T) {
+	env, ca := setupClientCertTestEnv(t)
+	defer env.Cleanup(t)
+
+	clientCert, fingerprint, err := ca.issueClientCert("camera-z6-001")
+	if err != nil {
+		t.Fatalf("Failed to issue client certificate: %v", err)
+	}
+	clientTLS := &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{clientCert},
+	}
+
+	dials := map[string]func() (*ftp.ServerConn, error){
+		"ExplicitFTPS": func() (*ftp.ServerConn, error) {
+			return ftp.Dial(env.ExplicitAddr, ftp.DialWithTimeout(5*time.Second), ftp.DialWithExplicitTLS(clientTLS))
+		},
+		"ImplicitFTPS": func() (*ftp.ServerConn, error) {
+			return ftp.Dial(env.ImplicitAddr, ftp.DialWithTimeout(5*time.Second), ftp.DialWithTLS(clientTLS))
+		},
+	}
+
+	for name, dial := range dials {
+		t.Run(name, func(t *testing.T) {
+			env.MockAPI.Reset()
+
+			conn, err := dial()
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer conn.Quit()
+
+			// Password is ignored: the certificate authenticates the device
+			if err := conn.Login("camera", "not-the-password"); err != nil {
+				t.Fatalf("Login with client certificate failed: %v", err)
+			}
+
+			if got := env.MockAPI.GetCertAuthCallCount(); got != 1 {
+				t.Errorf("Expected 1 certificate auth call, got %d", got)
+			}
+			if got := env.MockAPI.GetAuthCallCount(); got != 0 {
+				t.Errorf("Expected no password auth calls, got %d", got)
+			}
+			if call := env.MockAPI.GetLastCertAuthCall(); call == nil || call.Fingerprint != fingerprint {
+				t.Errorf("Fingerprint = %+v, want %s", call, fingerprint)
+			}
+
+			if err := conn.Stor("cert_photo.jpg", bytes.NewReader([]byte("jpeg bytes"))); err != nil {
+				t.Fatalf("Upload failed: %v", err)
+			}
+		})
+	}
+}
+
+// TestE2E_ClientCertFallsBackToPassword verifies USER/PASS still works when no certificate
+// is presented or the certificate isn't registered
+func TestE2E_ClientCertFallsBackToPassword(t *testing.T) {
+	env, ca := setupClientCertTestEnv(t)
+	defer env.Cleanup(t)
+
+	t.Run("NoCertificate", func(t *testing.T) {
+		env.MockAPI.Reset()
+		conn := env.ConnectImplicitFTPS(t)
+		defer conn.Quit()
+
+		if err := conn.Login("testuser", "testpass"); err != nil {
+			t.Fatalf("Password login failed: %v", err)
+		}
+		if got := env.MockAPI.GetCertAuthCallCount(); got != 0 {
+			t.Errorf("Expected no certificate auth calls, got %d", got)
+		}
+	})
+
+	t.Run("UnregisteredCertificate", func(t *testing.T) {
+		env.MockAPI.Reset()
+		env.MockAPI.SetCertAuthFailure(errors.New("certificate not registered"))
+
+		clientCert, _, err := ca.issueClientCert("unknown-device")
+		if err != nil {
+			t.Fatalf("Failed to issue client certificate: %v", err)
+		}
+		conn, err := ftp.Dial(env.ImplicitAddr,
+			ftp.DialWithTimeout(5*time.Second),
+			ftp.DialWithTLS(&tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{clientCert}}),
+		)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Quit()
+
+		if err := conn.Login("testuser", "testpass"); err != nil {
+			t.Fatalf("Password fallback failed: %v", err)
+		}
+		if got := env.MockAPI.GetAuthCallCount(); got != 1 {
+			t.Errorf("Expected 1 password auth call, got %d", got)
+		}
+	})
+}
+
+// legacyCameraTLS mimics an older camera body: TLS 1.0 with a CBC suite only
+func legacyCameraTLS() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		MaxVersion:         tls.VersionTLS10,
+		CipherSuites:       []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA},
+	}
+}
+
+func TestE2E_TLSProfilePerListener(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.TLSProfile = "modern"
+		cfg.ImplicitFTPSTLSProfile = "legacy-camera"
+	})
+	defer env.Cleanup(t)
+
+	t.Run("LegacyCameraOnLegacyListener", func(t *testing.T) {
+		conn, err := ftp.Dial(env.ImplicitAddr,
+			ftp.DialWithTimeout(5*time.Second),
+			ftp.DialWithTLS(legacyCameraTLS()),
+		)
+		if err != nil {
+			t.Fatalf("Legacy camera handshake failed on legacy-camera listener: %v", err)
+		}
+		defer conn.Quit()
+
+		if err := conn.Login("testuser", "testpass"); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+	})
+
+	t.Run("LegacyCameraOnModernListener", func(t *testing.T) {
+		conn, err := ftp.Dial(env.ExplicitAddr,
+			ftp.DialWithTimeout(5*time.Second),
+			ftp.DialWithExplicitTLS(legacyCameraTLS()),
+		)
+		if err == nil {
+			// The client only handshakes on the first command after AUTH TLS
+			err = conn.Login("testuser", "testpass")
+			conn.Quit()
+		}
+		if err == nil {
+			t.Fatal("Expected legacy camera handshake to fail on modern listener")
+		}
+	})
+
+	t.Run("ModernClientOnBothListeners", func(t *testing.T) {
+		conn := env.ConnectExplicitFTPS(t)
+		conn.Quit()
+		conn = env.ConnectImplicitFTPS(t)
+		conn.Quit()
+	})
+}
+
+// logBuffer collects the server's log lines for assertions
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog sends the standard logger to a buffer for the rest of the test
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+	logs := &logBuffer{}
+	log.SetOutput(io.MultiWriter(os.Stderr, logs))
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return logs
+}
+
+func TestE2E_TLSHandshakeFailureLogsClientHello(t *testing.T) {
+	env := SetupMultiModeTestEnv(t)
+	defer env.Cleanup(t)
+	logs := captureLog(t)
+
+	// A client that doesn't trust the server's certificate aborts the handshake after the hello
+	verifying := &tls.Config{ServerName: "camera.test", MaxVersion: tls.VersionTLS12}
+
+	t.Run("Implicit", func(t *testing.T) {
+		conn, err := net.DialTimeout("tcp", env.ImplicitAddr, 5*time.Second)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		if err := tls.Client(conn, verifying).Handshake(); err == nil {
+			t.Fatal("Expected the handshake to fail")
+		}
+		eventually(t, "implicit handshake failure log", func() bool {
+			return strings.Contains(logs.String(), "tls_handshake_failed") &&
+				strings.Contains(logs.String(), "listener=implicit")
+		})
+	})
+
+	t.Run("AuthTLS", func(t *testing.T) {
+		conn, err := net.DialTimeout("tcp", env.ExplicitAddr, 5*time.Second)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		plain := textproto.NewConn(conn)
+		if _, _, err := plain.ReadResponse(220); err != nil {
+			t.Fatalf("Unexpected greeting: %v", err)
+		}
+		rawCmd(t, plain, 234, "AUTH TLS")
+		if err := tls.Client(conn, verifying).Handshake(); err == nil {
+			t.Fatal("Expected the handshake to fail")
+		}
+		eventually(t, "explicit handshake failure log", func() bool {
+			return strings.Contains(logs.String(), "listener=explicit")
+		})
+	})
+
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "tls_handshake_failed") &&
+			(!strings.Contains(line, "offered_versions=TLS 1.2") || !strings.Contains(line, `server_name="camera.test"`)) {
+			t.Errorf("Failure log lacks the client hello: %s", line)
+		}
+	}
+}
+
+func TestNewWithOptions_RejectsUnknownTLSProfile(t *testing.T) {
+	cfg := &config.Config{
+		APIURL:           "http://mock.test",
+		FTPListenAddress: "127.0.0.1:0",
+		TLSProfile:       "paranoid",
+	}
+	mgr := clientmgr.NewManager()
+	_, err := server.NewWithOptions(cfg, mgr, server.TestServerOptions{APIClient: apiclient.NewMockClient()})
+	if err == nil {
+		t.Fatal("Expected error for unknown TLS profile")
+	}
+}
+
+// dialExplicitTLSRaw opens an explicit FTPS control connection for raw commands
+func dialExplicitTLSRaw(t *testing.T, addr string) *textproto.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	plain := textproto.NewConn(conn)
+	if _, _, err := plain.ReadResponse(220); err != nil {
+		conn.Close()
+		t.Fatalf("Unexpected greeting: %v", err)
+	}
+	rawCmd(t, plain, 234, "AUTH TLS")
+	return textproto.NewConn(tls.Client(conn, &tls.Config{InsecureSkipVerify: true}))
+}
+
+func TestE2E_TLSPolicyControl(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.FTPTLSPolicy = "tls-control"
+	})
+	defer env.Cleanup(t)
+
+	t.Run("CleartextLoginRefused", func(t *testing.T) {
+		conn := env.ConnectRawFTP(t)
+		defer conn.Close()
+
+		rawCmd(t, conn, 534, "USER testuser")
+		rawCmd(t, conn, 534, "PASS testpass")
+		if got := env.MockAPI.GetAuthCallCount(); got != 0 {
+			t.Errorf("Expected no auth calls for cleartext credentials, got %d", got)
+		}
+	})
+
+	t.Run("LoginAfterAuthTLS", func(t *testing.T) {
+		env.MockAPI.Reset()
+		conn := env.ConnectExplicitFTPS(t)
+		defer conn.Quit()
+
+		if err := conn.Login("testuser", "testpass"); err != nil {
+			t.Fatalf("Login over TLS failed: %v", err)
+		}
+		if err := conn.Stor("policy_photo.jpg", bytes.NewReader([]byte("jpeg bytes"))); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+	})
+
+	t.Run("ImplicitUnaffected", func(t *testing.T) {
+		conn := env.ConnectImplicitFTPS(t)
+		defer conn.Quit()
+
+		if err := conn.Login("testuser", "testpass"); err != nil {
+			t.Fatalf("Implicit login failed: %v", err)
+		}
+	})
+}
+
+func TestE2E_TLSPolicyAll(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.FTPTLSPolicy = "tls-all"
+	})
+	defer env.Cleanup(t)
+
+	t.Run("ClearDataRefused", func(t *testing.T) {
+		conn := dialExplicitTLSRaw(t, env.ExplicitAddr)
+		defer conn.Close()
+
+		rawCmd(t, conn, 331, "USER testuser")
+		rawCmd(t, conn, 230, "PASS testpass")
+		rawCmd(t, conn, 200, "PROT C")
+		rawCmd(t, conn, 521, "PASV")
+		rawCmd(t, conn, 521, "EPSV")
+		rawCmd(t, conn, 521, "STOR clear.jpg")
+		rawCmd(t, conn, 200, "PBSZ 0")
+		rawCmd(t, conn, 200, "PROT P")
+		rawCmd(t, conn, 227, "PASV")
+
+		if got := env.MockAPI.GetPresignCallCount(); got != 0 {
+			t.Errorf("Expected refused transfer not to be uploaded, got %d presign calls", got)
+		}
+	})
+
+	t.Run("ImplicitUnaffected", func(t *testing.T) {
+		// Implicit FTPS data connections are TLS without PROT P, as before the policy existed
+		raw, err := tls.Dial("tcp", env.ImplicitAddr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn := textproto.NewConn(raw)
+		defer conn.Close()
+		if _, _, err := conn.ReadResponse(220); err != nil {
+			t.Fatalf("Unexpected greeting: %v", err)
+		}
+		rawCmd(t, conn, 331, "USER testuser")
+		rawCmd(t, conn, 230, "PASS testpass")
+		rawCmd(t, conn, 227, "PASV")
+	})
+
+	t.Run("PrivateDataAllowed", func(t *testing.T) {
+		conn := env.ConnectExplicitFTPS(t)
+		defer conn.Quit()
+
+		if err := conn.Login("testuser", "testpass"); err != nil {
+			t.Fatalf("Login over TLS failed: %v", err)
+		}
+		if err := conn.Stor("private.jpg", bytes.NewReader([]byte("jpeg bytes"))); err != nil {
+			t.Fatalf("Upload with PROT P failed: %v", err)
+		}
+	})
+}
+
+func TestNewWithOptions_TLSPolicyRequiresCertificate(t *testing.T) {
+	cfg := &config.Config{
+		APIURL:           "http://mock.test",
+		FTPListenAddress: "127.0.0.1:0",
+		FTPTLSPolicy:     "tls-control",
+	}
+	mgr := clientmgr.NewManager()
+	_, err := server.NewWithOptions(cfg, mgr, server.TestServerOptions{APIClient: apiclient.NewMockClient()})
+	if err == nil {
+		t.Fatal("Expected error for TLS policy without certificate")
+	}
+}
+
+// setupSFTPTestEnv starts the multi-mode environment with the SFTP listener enabled
+func setupSFTPTestEnv(t *testing.T) (*TestEnv, string) {
+	t.Helper()
+	sftpAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.SFTPEnabled = true
+		cfg.SFTPListenAddress = sftpAddr
+		cfg.SFTPHostKeyPath = filepath.Join(t.TempDir(), "sftp_host_key")
+	})
+	waitForServer(t, sftpAddr, 5*time.Second)
+	return env, sftpAddr
+}
+
+// connectSFTP logs in over SSH with a password and opens an SFTP client
+func connectSFTP(t *testing.T, addr, user, pass string, opts ...sftp.ClientOption) (*sftp.Client, error) {
+	t.Helper()
+	sshConn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password(pass)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(sshConn, opts...)
+	if err != nil {
+		sshConn.Close()
+		return nil, err
+	}
+	t.Cleanup(func() {
+		client.Close()
+		sshConn.Close()
+	})
+	return client, nil
+}
+
+func TestE2E_SFTPUpload(t *testing.T) {
+	env, sftpAddr := setupSFTPTestEnv(t)
+	defer env.Cleanup(t)
+
+	// Concurrent writes deliver chunks out of order, like most desktop SFTP clients
+	client, err := connectSFTP(t, sftpAddr, "test", "pass", sftp.UseConcurrentWrites(true))
+	if err != nil {
+		t.Fatalf("SFTP login failed: %v", err)
+	}
+	if got := env.MockAPI.GetAuthCallCount(); got != 1 {
+		t.Errorf("Expected 1 auth call, got %d", got)
+	}
+
+	size := 1024 * 1024
+	file, err := client.Create("/sftp_photo.jpg")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := file.ReadFrom(bytes.NewReader(bytes.Repeat([]byte("x"), size))); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	if got := env.MockAPI.GetPresignCallCount(); got != 1 {
+		t.Errorf("Expected 1 presign call, got %d", got)
+	}
+	if call := env.MockAPI.GetLastPresignCall(); call == nil || call.Filename != "/sftp_photo.jpg" {
+		t.Errorf("Unexpected presign call: %+v", call)
+	}
+	upload := env.MockAPI.GetLastUploadCall()
+	if upload == nil {
+		t.Fatal("No upload recorded")
+	}
+	if upload.Size != int64(size) {
+		t.Errorf("Size = %d, want %d", upload.Size, size)
+	}
+
+	// Directory ops are accepted no-ops, as over FTP
+	if err := client.Mkdir("/folder"); err != nil {
+		t.Errorf("Mkdir failed: %v", err)
+	}
+	if _, err := client.ReadDir("/"); err != nil {
+		t.Errorf("ReadDir failed: %v", err)
+	}
+}
+
+func TestE2E_SFTPDownloadBlocked(t *testing.T) {
+	env, sftpAddr := setupSFTPTestEnv(t)
+	defer env.Cleanup(t)
+
+	client, err := connectSFTP(t, sftpAddr, "test", "pass")
+	if err != nil {
+		t.Fatalf("SFTP login failed: %v", err)
+	}
+
+	if _, err := client.Open("/some_file.jpg"); err == nil {
+		t.Fatal("Expected download to be blocked")
+	}
+}
+
+func TestE2E_SFTPAuthFailure(t *testing.T) {
+	env, sftpAddr := setupSFTPTestEnv(t)
+	defer env.Cleanup(t)
+
+	env.MockAPI.SetAuthFailure(errors.New("invalid credentials"))
+
+	if _, err := connectSFTP(t, sftpAddr, "test", "wrong"); err == nil {
+		t.Fatal("Expected SFTP login to fail")
+	}
+	if got := env.MockAPI.GetAuthCallCount(); got == 0 {
+		t.Error("Expected credentials to be checked against the API")
+	}
+}
+
+func TestE2E_SFTPSessionIDsUniqueAcrossListeners(t *testing.T) {
+	env, sftpAddr := setupSFTPTestEnv(t)
+	defer env.Cleanup(t)
+
+	ftpConn := env.ConnectPlainFTP(t)
+	defer ftpConn.Quit()
+	if err := ftpConn.Login("test", "pass"); err != nil {
+		t.Fatalf("FTP login failed: %v", err)
+	}
+	if _, err := connectSFTP(t, sftpAddr, "test", "pass"); err != nil {
+		t.Fatalf("SFTP login failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// FTP and SFTP sessions draw IDs from the same manager, so neither overwrites the other
+	if got := env.ClientMgr.ClientCount(); got != 2 {
+		t.Errorf("Expected 2 tracked sessions, got %d", got)
+	}
+}
+
+const tusTestSecret = "tus-test-secret"
+
+// setupTusTestEnv starts the multi-mode environment with the tus endpoint enabled (HTTPS, test cert)
+func setupTusTestEnv(t *testing.T) (*TestEnv, string) {
+	t.Helper()
+	tusAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.TUSEnabled = true
+		cfg.TUSListenAddress = tusAddr
+		cfg.TUSMaxSize = 10 << 20
+		cfg.FTPJWTSecret = tusTestSecret
+	})
+	waitForServer(t, tusAddr, 5*time.Second)
+	return env, "https://" + tusAddr
+}
+
+var tusHTTPClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+}
+
+// tusRequest sends a tus 1.0 request; auth is applied by the caller through setAuth
+func tusRequest(t *testing.T, method, url string, body []byte, headers map[string]string, setAuth func(*http.Request)) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if setAuth != nil {
+		setAuth(req)
+	}
+	resp, err := tusHTTPClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func basicAuth(req *http.Request) { req.SetBasicAuth("test", "pass") }
+
+func bearerAuth(token string) func(*http.Request) {
+	return func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+}
+
+// signFtpToken signs an FTP upload JWT like the API does (HS256, aud ftp-upload)
+func signFtpToken(t *testing.T, eventID string) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "HS256"})
+	payload, _ := json.Marshal(map[string]any{
+		"eventId":        eventID,
+		"photographerId": "ph_test",
+		"aud":            "ftp-upload",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(tusTestSecret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func tusMetadata(filename string) string {
+	return "filename " + base64.StdEncoding.EncodeToString([]byte(filename))
+}
+
+func TestE2E_TusResumableUpload(t *testing.T) {
+	env, baseURL := setupTusTestEnv(t)
+	defer env.Cleanup(t)
+
+	resp := tusRequest(t, http.MethodOptions, baseURL+"/files/", nil, nil, nil)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Tus-Version") != "1.0.0" {
+		t.Fatalf("OPTIONS: status=%d Tus-Version=%q", resp.StatusCode, resp.Header.Get("Tus-Version"))
+	}
+
+	data := bytes.Repeat([]byte("x"), 256*1024)
+	resp = tusRequest(t, http.MethodPost, baseURL+"/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": tusMetadata("tus_photo.jpg"),
+	}, basicAuth)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d", resp.StatusCode)
+	}
+	uploadURL := baseURL + resp.Header.Get("Location")
+
+	patch := func(offset int, chunk []byte) *http.Response {
+		return tusRequest(t, http.MethodPatch, uploadURL, chunk, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		}, basicAuth)
+	}
+
+	half := len(data) / 2
+	if resp := patch(0, data[:half]); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("First PATCH: expected 204, got %d", resp.StatusCode)
+	}
+
+	// Client "reconnects" and asks where to resume
+	resp = tusRequest(t, http.MethodHead, uploadURL, nil, nil, basicAuth)
+	if got := resp.Header.Get("Upload-Offset"); got != strconv.Itoa(half) {
+		t.Fatalf("HEAD: Upload-Offset = %q, want %d", got, half)
+	}
+	if resp := patch(0, data[:half]); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Stale PATCH: expected 409, got %d", resp.StatusCode)
+	}
+	if got := env.MockAPI.GetPresignCallCount(); got != 0 {
+		t.Fatalf("Expected no presign before the upload completes, got %d", got)
+	}
+
+	if resp := patch(half, data[half:]); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Final PATCH: expected 204, got %d", resp.StatusCode)
+	}
+
+	if call := env.MockAPI.GetLastPresignCall(); call == nil || call.Filename != "tus_photo.jpg" {
+		t.Errorf("Unexpected presign call: %+v", call)
+	}
+	upload := env.MockAPI.GetLastUploadCall()
+	if upload == nil || upload.Size != int64(len(data)) {
+		t.Fatalf("Expected %d-byte upload, got %+v", len(data), upload)
+	}
+	if got := env.MockAPI.GetAuthCallCount(); got != 1 {
+		t.Errorf("Expected credentials verified once and cached, got %d auth calls", got)
+	}
+
+	// A client whose final PATCH response was lost learns the upload is complete
+	resp = tusRequest(t, http.MethodHead, uploadURL, nil, nil, basicAuth)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Upload-Offset") != strconv.Itoa(len(data)) {
+		t.Errorf("HEAD after completion: status=%d Upload-Offset=%q, want 200 and %d", resp.StatusCode, resp.Header.Get("Upload-Offset"), len(data))
+	}
+	if resp := patch(len(data), nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("PATCH after completion: expected 404, got %d", resp.StatusCode)
+	}
+	if got := env.MockAPI.GetUploadCallCount(); got != 1 {
+		t.Errorf("Expected one R2 upload, got %d", got)
+	}
+}
+
+func TestE2E_TusBearerToken(t *testing.T) {
+	env, baseURL := setupTusTestEnv(t)
+	defer env.Cleanup(t)
+
+	data := []byte("bearer upload data")
+	headers := map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": tusMetadata("bearer.jpg"),
+		"Content-Type":    "application/offset+octet-stream",
+	}
+
+	if resp := tusRequest(t, http.MethodPost, baseURL+"/files/", data, headers, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without credentials, got %d", resp.StatusCode)
+	}
+
+	// creation-with-upload: the whole file in the POST body
+	resp := tusRequest(t, http.MethodPost, baseURL+"/files/", data, headers, bearerAuth(signFtpToken(t, "evt_test123")))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Upload-Offset"); got != strconv.Itoa(len(data)) {
+		t.Errorf("Upload-Offset = %q, want %d", got, len(data))
+	}
+	if got := env.MockAPI.GetAuthCallCount(); got != 0 {
+		t.Errorf("Expected bearer auth without API login, got %d auth calls", got)
+	}
+	if call := env.MockAPI.GetLastPresignCall(); call == nil || call.Token != signFtpToken(t, "evt_test123") {
+		t.Error("Expected presign with the bearer token")
+	}
+}
+
+func TestE2E_TusRejectsAndTerminates(t *testing.T) {
+	env, baseURL := setupTusTestEnv(t)
+	defer env.Cleanup(t)
+
+	resp := tusRequest(t, http.MethodPost, baseURL+"/files/", nil, map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tusMetadata("notes.txt"),
+	}, basicAuth)
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Unsupported type: expected 415, got %d", resp.StatusCode)
+	}
+
+	resp = tusRequest(t, http.MethodPost, baseURL+"/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(11 << 20),
+		"Upload-Metadata": tusMetadata("big.jpg"),
+	}, basicAuth)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized upload: expected 413, got %d", resp.StatusCode)
+	}
+
+	resp = tusRequest(t, http.MethodPost, baseURL+"/files/", nil, map[string]string{
+		"Upload-Length":   "100",
+		"Upload-Metadata": tusMetadata("cancelled.jpg"),
+	}, basicAuth)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d", resp.StatusCode)
+	}
+	uploadURL := baseURL + resp.Header.Get("Location")
+
+	// Another event's token can't see the upload
+	if resp := tusRequest(t, http.MethodHead, uploadURL, nil, nil, bearerAuth(signFtpToken(t, "evt_other"))); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Foreign event HEAD: expected 404, got %d", resp.StatusCode)
+	}
+
+	if resp := tusRequest(t, http.MethodDelete, uploadURL, nil, nil, basicAuth); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: expected 204, got %d", resp.StatusCode)
+	}
+	if resp := tusRequest(t, http.MethodHead, uploadURL, nil, nil, basicAuth); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD after DELETE: expected 404, got %d", resp.StatusCode)
+	}
+	if got := env.MockAPI.GetPresignCallCount(); got != 0 {
+		t.Errorf("Expected terminated upload not to reach the API, got %d presign calls", got)
+	}
+}
+
+// fakeRestart stands in for the process a graceful restart spawns: it takes over the sockets
+type fakeRestart struct {
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+}
+
+func (f *fakeRestart) spawn(argv []string, files map[string]*os.File) (*os.Process, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listeners = make(map[string]net.Listener)
+	for name, file := range files {
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, err
+		}
+		f.listeners[name] = listener
+	}
+	return os.FindProcess(os.Getpid())
+}
+
+func (f *fakeRestart) listener(name string) net.Listener {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.listeners[name]
+}
+
+func (f *fakeRestart) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, listener := range f.listeners {
+		listener.Close()
+	}
+}
+
+func TestE2E_HandOffFinishesTusUpload(t *testing.T) {
+	restart := &fakeRestart{}
+	defer restart.close()
+	tusAddr := findAvailablePort(t)
+	env := setupMultiModeTestEnv(t, func(cfg *config.Config) {
+		cfg.TUSEnabled = true
+		cfg.TUSListenAddress = tusAddr
+		cfg.TUSMaxSize = 10 << 20
+	}, server.TestServerOptions{Spawn: restart.spawn}, nil)
+	defer env.Cleanup(t)
+	waitForServer(t, tusAddr, 5*time.Second)
+	baseURL := "https://" + tusAddr
+
+	data := bytes.Repeat([]byte("x"), 64*1024)
+	resp := tusRequest(t, http.MethodPost, baseURL+"/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": tusMetadata("restart.jpg"),
+	}, basicAuth)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d", resp.StatusCode)
+	}
+
+	// A PATCH is halfway through when the restart happens
+	body, bodyWriter := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPatch, baseURL+resp.Header.Get("Location"), body)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	req.ContentLength = int64(len(data))
+	basicAuth(req)
+	patchDone := make(chan *http.Response, 1)
+	go func() {
+		resp, err := tusHTTPClient.Do(req)
+		if err != nil {
+			t.Errorf("PATCH failed: %v", err)
+		}
+		patchDone <- resp
+	}()
+	bodyWriter.Write(data[:len(data)/2])
+	eventually(t, "the first half to arrive", func() bool {
+		for _, session := range env.ClientMgr.Clients() {
+			for _, upload := range session.Uploads {
+				if upload.Bytes > 0 {
+					return true
+				}
+			}
+		}
+		return false
+	})
+
+	if _, err := env.Server.HandOff(); err != nil {
+		t.Fatalf("HandOff failed: %v", err)
+	}
+	for _, name := range []string{"ftp", "ftps-implicit", "tus"} {
+		if restart.listener(name) == nil {
+			t.Errorf("The %s listener was not handed off", name)
+		}
+	}
+
+	// New connections reach the new process only
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := restart.listener("tus").Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	probe, err := net.DialTimeout("tcp", tusAddr, time.Second)
+	if err != nil {
+		t.Fatalf("Dial after handoff failed: %v", err)
+	}
+	defer probe.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("New tus connection was not accepted by the new process")
+	}
+
+	// The old process finishes the upload in progress
+	bodyWriter.Write(data[len(data)/2:])
+	bodyWriter.Close()
+	if resp := <-patchDone; resp == nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PATCH across the restart = %+v, want 204", resp)
+	}
+	if upload := env.MockAPI.GetLastUploadCall(); upload == nil || upload.Size != int64(len(data)) {
+		t.Errorf("Expected the %d-byte upload to reach R2, got %+v", len(data), upload)
+	}
+}
+
+// setupWebDAVTestEnv starts the multi-mode environment with the WebDAV frontend enabled (HTTPS, test cert)
+func setupWebDAVTestEnv(t *testing.T) (*TestEnv, string) {
+	t.Helper()
+	davAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.WebDAVEnabled = true
+		cfg.WebDAVListenAddress = davAddr
+	})
+	waitForServer(t, davAddr, 5*time.Second)
+	return env, davAddr
+}
+
+// davRequest sends a WebDAV request with the test credentials and returns the status and body
+func davRequest(t *testing.T, method, url string, body []byte, headers map[string]string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.SetBasicAuth("test", "pass")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := tusHTTPClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(respBody)
+}
+
+func TestE2E_WebDAVUploadAndListing(t *testing.T) {
+	env, davAddr := setupWebDAVTestEnv(t)
+	defer env.Cleanup(t)
+	baseURL := "https://" + davAddr
+
+	resp, err := tusHTTPClient.Get(baseURL + "/")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("Expected 401 with a Basic challenge, got %d", resp.StatusCode)
+	}
+
+	if status, _ := davRequest(t, "MKCOL", baseURL+"/Shoot", nil, nil); status != http.StatusCreated {
+		t.Fatalf("MKCOL: expected 201, got %d", status)
+	}
+
+	data := bytes.Repeat([]byte("x"), 64*1024)
+	if status, _ := davRequest(t, http.MethodPut, baseURL+"/Shoot/dav_photo.jpg", data, nil); status != http.StatusCreated {
+		t.Fatalf("PUT: expected 201, got %d", status)
+	}
+
+	upload := env.MockAPI.GetLastUploadCall()
+	if upload == nil || upload.Size != int64(len(data)) {
+		t.Fatalf("Expected %d-byte upload, got %+v", len(data), upload)
+	}
+	if call := env.MockAPI.GetLastPresignCall(); call == nil || !strings.HasSuffix(call.Filename, "dav_photo.jpg") {
+		t.Errorf("Unexpected presign call: %+v", call)
+	}
+
+	// The listing shows what this session uploaded
+	status, body := davRequest(t, "PROPFIND", baseURL+"/Shoot/", nil, map[string]string{"Depth": "1"})
+	if status != http.StatusMultiStatus || !strings.Contains(body, "dav_photo.jpg") {
+		t.Errorf("PROPFIND: status=%d, listing missing uploaded file:\n%s", status, body)
+	}
+	status, body = davRequest(t, "PROPFIND", baseURL+"/", nil, map[string]string{"Depth": "1"})
+	if status != http.StatusMultiStatus || !strings.Contains(body, "/Shoot/") {
+		t.Errorf("PROPFIND /: status=%d, listing missing folder:\n%s", status, body)
+	}
+
+	if status, _ := davRequest(t, http.MethodGet, baseURL+"/Shoot/dav_photo.jpg", nil, nil); status != http.StatusForbidden {
+		t.Errorf("GET: expected 403, got %d", status)
+	}
+	if status, _ := davRequest(t, http.MethodPut, baseURL+"/notes.txt", []byte("text"), nil); status != http.StatusUnsupportedMediaType {
+		t.Errorf("PUT unsupported type: expected 415, got %d", status)
+	}
+	if got := env.MockAPI.GetAuthCallCount(); got != 1 {
+		t.Errorf("Expected credentials verified once and cached, got %d auth calls", got)
+	}
+}
+
+func TestE2E_WebDAVSkipsPlaceholdersAndSidecars(t *testing.T) {
+	env, davAddr := setupWebDAVTestEnv(t)
+	defer env.Cleanup(t)
+	baseURL := "https://" + davAddr
+
+	// Finder creates the file empty, writes its ._ sidecar, then PUTs the content
+	if status, _ := davRequest(t, http.MethodPut, baseURL+"/finder.jpg", nil, nil); status != http.StatusCreated {
+		t.Fatalf("Empty PUT: expected 201, got %d", status)
+	}
+	if status, _ := davRequest(t, http.MethodPut, baseURL+"/._finder.jpg", []byte("appledouble"), nil); status != http.StatusCreated {
+		t.Fatalf("Sidecar PUT: expected 201, got %d", status)
+	}
+	if got := env.MockAPI.GetPresignCallCount(); got != 0 {
+		t.Fatalf("Expected placeholders not to reach the API, got %d presign calls", got)
+	}
+
+	if status, _ := davRequest(t, http.MethodPut, baseURL+"/finder.jpg", []byte("jpeg data"), nil); status != http.StatusCreated {
+		t.Fatalf("PUT: expected 201, got %d", status)
+	}
+	if got := env.MockAPI.GetUploadCallCount(); got != 1 {
+		t.Errorf("Expected 1 upload, got %d", got)
+	}
+
+	// A body cut short is discarded instead of uploaded
+	conn, err := tls.Dial("tcp", davAddr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	req := "PUT /partial.jpg HTTP/1.1\r\nHost: " + davAddr + "\r\n" +
+		"Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("test:pass")) + "\r\n" +
+		"Content-Length: 1000\r\n\r\n" + strings.Repeat("x", 100)
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	if got := env.MockAPI.GetPresignCallCount(); got != 1 {
+		t.Errorf("Expected truncated PUT to be discarded, got %d presign calls", got)
+	}
+}
+
+func TestE2E_PassivePublicHost(t *testing.T) {
+	// Local stand-in for the IP echo service used by FTP_PUBLIC_HOST=auto
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "198.51.100.9\n")
+	}))
+	defer probe.Close()
+
+	tests := []struct {
+		name       string
+		publicHost string
+		want       string
+	}{
+		{"static IP", "203.0.113.7", "203,0,113,7"},
+		{"hostname", "localhost", "127,0,0,1"},
+		{"auto-detected", "auto", "198,51,100,9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+				cfg.FTPPublicHost = tt.publicHost
+				cfg.FTPPublicIPProbeURL = probe.URL
+			})
+			defer env.Cleanup(t)
+
+			conn := env.ConnectRawFTP(t)
+			defer conn.Close()
+			rawCmd(t, conn, 331, "USER test")
+			rawCmd(t, conn, 230, "PASS pass")
+
+			if msg := rawCmd(t, conn, 227, "PASV"); !strings.Contains(msg, "("+tt.want+",") {
+				t.Errorf("PASV advertised %q, want %s", msg, tt.want)
+			}
+			// EPSV carries only the port, so it works unchanged behind NAT
+			if msg := rawCmd(t, conn, 229, "EPSV"); !strings.Contains(msg, "(|||") {
+				t.Errorf("Unexpected EPSV reply %q", msg)
+			}
+		})
+	}
+}
+
+func TestE2E_EPSVDisabled(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.FTPEPSVEnabled = false
+	})
+	defer env.Cleanup(t)
+
+	conn := env.ConnectRawFTP(t)
+	defer conn.Close()
+	rawCmd(t, conn, 331, "USER test")
+	rawCmd(t, conn, 230, "PASS pass")
+	rawCmd(t, conn, 502, "EPSV")
+	rawCmd(t, conn, 227, "PASV")
+
+	// Clients fall back to PASV, on encrypted control connections too
+	for name, connect := range map[string]func(*testing.T) *ftp.ServerConn{
+		"plain":         env.ConnectPlainFTP,
+		"explicit FTPS": env.ConnectExplicitFTPS,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ftpConn := connect(t)
+			defer ftpConn.Quit()
+			if err := ftpConn.Login("test", "pass"); err != nil {
+				t.Fatalf("Login failed: %v", err)
+			}
+			if err := ftpConn.Stor("pasv_fallback.jpg", bytes.NewReader([]byte("photo"))); err != nil {
+				t.Fatalf("Upload after EPSV refusal failed: %v", err)
+			}
+		})
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := env.MockAPI.GetUploadCallCount(); got != 2 {
+		t.Errorf("Expected 2 uploads, got %d", got)
+	}
+}
+
+func TestNewWithOptions_RejectsIPv6PublicHost(t *testing.T) {
+	cfg := &config.Config{
+		APIURL:           "http://mock.test",
+		FTPListenAddress: findAvailablePort(t),
+		FTPPublicHost:    "2001:db8::1",
+	}
+	if _, err := server.NewWithClient(cfg, clientmgr.NewManager(), apiclient.NewMockClient()); err == nil {
+		t.Fatal("Expected IPv6 FTP_PUBLIC_HOST to be rejected")
+	}
+}
+
+// activeModeConn makes jlaffaye/ftp (passive-only) upload in active mode
+// Its EPSV/PASV is sent as PORT or EPRT naming a local listener, and the 200 reply is rewritten
+// into the EPSV reply the library expects; its data "dial" then accepts the server's connection.
+type activeModeConn struct {
+	net.Conn
+	command string // PORT or EPRT
+
+	reader   *bufio.Reader
+	pending  []byte
+	listener net.Listener // opened by the last EPSV/PASV, handed to the next data dial
+}
+
+func (c *activeModeConn) Write(p []byte) (int, error) {
+	line := strings.ToUpper(string(p))
+	if !strings.HasPrefix(line, "EPSV") && !strings.HasPrefix(line, "PASV") {
+		return c.Conn.Write(p)
+	}
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	c.listener = listener
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	cmd := fmt.Sprintf("EPRT |1|127.0.0.1|%d|\r\n", port)
+	if c.command == "PORT" {
+		cmd = fmt.Sprintf("PORT 127,0,0,1,%d,%d\r\n", port/256, port%256)
+	}
+	if _, err := c.Conn.Write([]byte(cmd)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *activeModeConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		line, err := c.reader.ReadBytes('\n')
+		if err != nil {
+			return 0, err
+		}
+		if c.listener != nil && bytes.HasPrefix(line, []byte("200 ")) {
+			port := c.listener.Addr().(*net.TCPAddr).Port
+			line = []byte(fmt.Sprintf("229 Entering Extended Passive Mode (|||%d|)\r\n", port))
+		}
+		c.pending = line
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// dial is the ftp.DialWithDialFunc hook: the first call is the control connection, later ones data
+func (c *activeModeConn) dial(network, address string) (net.Conn, error) {
+	if c.Conn == nil {
+		conn, err := net.DialTimeout(network, address, 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		c.Conn = conn
+		c.reader = bufio.NewReader(conn)
+		return c, nil
+	}
+	if c.listener == nil {
+		return nil, errors.New("no PORT listener for data connection")
+	}
+	data := &acceptedDataConn{listener: c.listener}
+	c.listener = nil
+	return data, nil
+}
+
+// acceptedDataConn is the data connection the server opens; accepted on first use
+// (jlaffaye/ftp "dials" it before sending STOR, but the server connects only after STOR)
+type acceptedDataConn struct {
+	listener net.Listener
+	once     sync.Once
+	conn     net.Conn
+	err      error
+}
+
+func (c *acceptedDataConn) accept() error {
+	c.once.Do(func() {
+		c.listener.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+		c.conn, c.err = c.listener.Accept()
+		c.listener.Close()
+	})
+	return c.err
+}
+
+func (c *acceptedDataConn) Read(p []byte) (int, error) {
+	if err := c.accept(); err != nil {
+		return 0, err
+	}
+	return c.conn.Read(p)
+}
+
+func (c *acceptedDataConn) Write(p []byte) (int, error) {
+	if err := c.accept(); err != nil {
+		return 0, err
+	}
+	return c.conn.Write(p)
+}
+
+func (c *acceptedDataConn) Close() error {
+	c.once.Do(func() { c.err = net.ErrClosed })
+	c.listener.Close()
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *acceptedDataConn) LocalAddr() net.Addr                { return c.listener.Addr() }
+func (c *acceptedDataConn) RemoteAddr() net.Addr               { return c.listener.Addr() }
+func (c *acceptedDataConn) SetDeadline(t time.Time) error      { return nil }
+func (c *acceptedDataConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *acceptedDataConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// connectActiveFTP logs in with jlaffaye/ftp uploading over PORT or EPRT
+func (te *TestEnv) connectActiveFTP(t *testing.T, command string) *ftp.ServerConn {
+	t.Helper()
+	shim := &activeModeConn{command: command}
+	conn, err := ftp.Dial(te.ExplicitAddr,
+		ftp.DialWithTimeout(5*time.Second),
+		ftp.DialWithDialFunc(shim.dial),
+	)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	return conn
+}
+
+func TestE2E_ActiveModeUpload(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.FTPActiveModeEnabled = true
+	})
+	defer env.Cleanup(t)
+
+	for i, command := range []string{"PORT", "EPRT"} {
+		t.Run(command, func(t *testing.T) {
+			conn := env.connectActiveFTP(t, command)
+			defer conn.Quit()
+
+			testData := []byte("active mode upload via " + command)
+			filename := strings.ToLower(command) + "_photo.jpg"
+			if err := conn.Stor(filename, bytes.NewReader(testData)); err != nil {
+				t.Fatalf("Active-mode upload failed: %v", err)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+
+			if got := env.MockAPI.GetUploadCallCount(); got != i+1 {
+				t.Errorf("Expected %d upload calls, got %d", i+1, got)
+			}
+			if upload := env.MockAPI.GetLastUploadCall(); upload == nil || upload.Size != int64(len(testData)) {
+				t.Errorf("Unexpected upload: %+v", upload)
+			}
+		})
+	}
+}
+
+func TestE2E_EPRTDisabled(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.FTPActiveModeEnabled = true
+		cfg.FTPEPRTEnabled = false
+	})
+	defer env.Cleanup(t)
+
+	conn := env.ConnectRawFTP(t)
+	defer conn.Close()
+	rawCmd(t, conn, 331, "USER test")
+	rawCmd(t, conn, 230, "PASS pass")
+	rawCmd(t, conn, 502, "EPRT |1|127.0.0.1|2000|")
+
+	// PORT still works
+	ftpConn := env.connectActiveFTP(t, "PORT")
+	defer ftpConn.Quit()
+	if err := ftpConn.Stor("port_photo.jpg", bytes.NewReader([]byte("photo"))); err != nil {
+		t.Fatalf("PORT upload failed: %v", err)
+	}
+}
+
+func TestE2E_ActiveModeDisabledByDefault(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup(t)
+
+	conn := env.connectActiveFTP(t, "PORT")
+	defer conn.Quit()
+
+	if err := conn.Stor("photo.jpg", bytes.NewReader([]byte("data"))); err == nil {
+		t.Fatal("Expected active-mode upload to fail when FTP_ACTIVE_MODE_ENABLED is unset")
+	}
+	if got := env.MockAPI.GetUploadCallCount(); got != 0 {
+		t.Errorf("Expected no uploads, got %d", got)
+	}
+}
+
+func TestE2E_ActiveModeRejectsThirdPartyTargets(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.FTPActiveModeEnabled = true
+	})
+	defer env.Cleanup(t)
+
+	conn := env.ConnectRawFTP(t)
+	defer conn.Close()
+	rawCmd(t, conn, 331, "USER test")
+	rawCmd(t, conn, 230, "PASS pass")
+
+	// FXP/bounce: the data connection may only go back to the control connection's IP
+	rawCmd(t, conn, 501, "PORT 203,0,113,7,0,25")
+	rawCmd(t, conn, 501, "EPRT |1|203.0.113.7|25|")
+	rawCmd(t, conn, 200, "PORT 127,0,0,1,4,1")
+}
+
+func TestNewWithOptions_RejectsUnsupportedActiveSourcePort(t *testing.T) {
+	cfg := &config.Config{
+		APIURL:               "http://mock.test",
+		FTPListenAddress:     findAvailablePort(t),
+		FTPActiveModeEnabled: true,
+		FTPActiveSourcePort:  2020,
+	}
+	if _, err := server.NewWithClient(cfg, clientmgr.NewManager(), apiclient.NewMockClient()); err == nil {
+		t.Fatal("Expected FTP_ACTIVE_SOURCE_PORT=2020 to be rejected")
+	}
+}
+
+// dialThroughProxy is an ftp dial func that starts the control connection with a PROXY v1
+// header, as a TCP load balancer would; tlsConfig adds implicit TLS on every connection
+func dialThroughProxy(header string, tlsConfig *tls.Config) func(network, address string) (net.Conn, error) {
+	control := true
+	return func(network, address string) (net.Conn, error) {
+		conn, err := net.DialTimeout(network, address, 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		if control {
+			control = false
+			if _, err := conn.Write([]byte(header)); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		if tlsConfig != nil {
+			return tls.Client(conn, tlsConfig), nil
+		}
+		return conn, nil
+	}
+}
+
+func TestE2E_ProxyProtocolClientAddress(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.ProxyProtocolTrustedCIDRs = "127.0.0.1/32"
+		// Passive data connections don't carry a PROXY header, so they come from the balancer's address
+		cfg.FTPPassiveAllowAnyIP = true
+	})
+	defer env.Cleanup(t)
+
+	tests := []struct {
+		name      string
+		addr      string
+		clientIP  string
+		tlsConfig *tls.Config
+	}{
+		{"explicit listener", env.ExplicitAddr, "203.0.113.7:50000", nil},
+		{"implicit FTPS listener", env.ImplicitAddr, "198.51.100.20:50001", &tls.Config{InsecureSkipVerify: true}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, _ := net.SplitHostPort(tt.clientIP)
+			header := fmt.Sprintf("PROXY TCP4 %s 10.0.0.1 %s 21\r\n", host, port)
+
+			conn, err := ftp.Dial(tt.addr,
+				ftp.DialWithTimeout(5*time.Second),
+				ftp.DialWithDialFunc(dialThroughProxy(header, tt.tlsConfig)),
+			)
+			if err != nil {
+				t.Fatalf("Failed to connect through proxy: %v", err)
+			}
+			defer conn.Quit()
+			if err := conn.Login("test", "pass"); err != nil {
+				t.Fatalf("Login failed: %v", err)
+			}
+
+			found := false
+			for _, client := range env.ClientMgr.Clients() {
+				if client.ClientIP == tt.clientIP {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("No session with client IP %s: %+v", tt.clientIP, env.ClientMgr.Clients())
+			}
+
+			if err := conn.Stor("proxied.jpg", bytes.NewReader([]byte("through the balancer"))); err != nil {
+				t.Fatalf("Upload through proxy failed: %v", err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			if got := env.MockAPI.GetUploadCallCount(); got != i+1 {
+				t.Errorf("Expected %d upload calls, got %d", i+1, got)
+			}
+		})
+	}
+}
+
+func TestNewWithOptions_RejectsInvalidProxyCIDRs(t *testing.T) {
+	cfg := &config.Config{
+		APIURL:                    "http://mock.test",
+		FTPListenAddress:          findAvailablePort(t),
+		ProxyProtocolTrustedCIDRs: "10.0.0.0/33",
+	}
+	if _, err := server.NewWithClient(cfg, clientmgr.NewManager(), apiclient.NewMockClient()); err == nil {
+		t.Fatal("Expected invalid PROXY_PROTOCOL_TRUSTED_CIDRS to be rejected")
+	}
+}
+
+// =============================================================================
+// Admin API Tests - live sessions for operators
+// =============================================================================
+
+type adminSession struct {
+	ID          uint32 `json:"id"`
+	Protocol    string `json:"protocol"`
+	ClientIP    string `json:"client_ip"`
+	EventID     string `json:"event_id"`
+	FilesOK     int64  `json:"files_ok"`
+	FilesFailed int64  `json:"files_failed"`
+	Bytes       int64  `json:"bytes"`
+	Uploads     []struct {
+		File  string `json:"file"`
+		Bytes int64  `json:"bytes"`
+	} `json:"uploads"`
+}
+
+func setupAdminTestEnv(t *testing.T, token string) (*TestEnv, string) {
+	t.Helper()
+	adminAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.AdminEnabled = true
+		cfg.AdminListenAddress = adminAddr
+		cfg.AdminToken = token
+	})
+	waitForServer(t, adminAddr, 5*time.Second)
+	return env, "http://" + adminAddr
+}
+
+// adminRequest calls the admin API and decodes a JSON reply into out (if non-nil)
+func adminRequest(t *testing.T, method, url, token string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Failed to decode %s %s: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// ftpSession returns the admin view of the (single) logged-in FTP session
+func ftpSession(t *testing.T, baseURL string) adminSession {
+	t.Helper()
+	var list struct {
+		Sessions []adminSession `json:"sessions"`
+	}
+	if status := adminRequest(t, "GET", baseURL+"/sessions?event=evt_test123", "", &list); status != http.StatusOK {
+		t.Fatalf("GET /sessions = %d", status)
+	}
+	if len(list.Sessions) != 1 {
+		t.Fatalf("Expected 1 session for the event, got %+v", list.Sessions)
+	}
+	return list.Sessions[0]
+}
+
+func TestE2E_AdminListsInspectsAndKicks(t *testing.T) {
+	env, baseURL := setupAdminTestEnv(t, "")
+	defer env.Cleanup(t)
+
+	conn := env.ConnectPlainFTP(t)
+	defer conn.Quit()
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if err := conn.Stor("first.jpg", bytes.NewReader([]byte("first photo"))); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	session := ftpSession(t, baseURL)
+	if session.Protocol != "ftp" || session.EventID != "evt_test123" || session.FilesOK != 1 || session.Bytes != 11 {
+		t.Errorf("Unexpected session: %+v", session)
+	}
+
+	// An upload in flight shows its progress
+	pr, pw := io.Pipe()
+	storDone := make(chan error, 1)
+	go func() { storDone <- conn.Stor("second.jpg", pr) }()
+	pw.Write([]byte("partial"))
+
+	var inFlight adminSession
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		adminRequest(t, "GET", fmt.Sprintf("%s/sessions/%d", baseURL, session.ID), "", &inFlight)
+		if len(inFlight.Uploads) == 1 && inFlight.Uploads[0].Bytes == 7 {
+			break
+		}
+	}
+	if len(inFlight.Uploads) != 1 || inFlight.Uploads[0].File != "/second.jpg" || inFlight.Uploads[0].Bytes != 7 {
+		t.Errorf("Expected /second.jpg in flight with 7 bytes, got %+v", inFlight.Uploads)
+	}
+	pw.Close()
+	if err := <-storDone; err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	// Kick goes through the client manager and closes the control connection
+	if status := adminRequest(t, "DELETE", fmt.Sprintf("%s/sessions/%d?reason=test", baseURL, session.ID), "", nil); status != http.StatusAccepted {
+		t.Fatalf("DELETE session = %d, want 202", status)
+	}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if _, ok := env.ClientMgr.GetClient(session.ID); !ok {
+			break
+		}
+	}
+	if _, ok := env.ClientMgr.GetClient(session.ID); ok {
+		t.Error("Session still registered after kick")
+	}
+	if err := conn.NoOp(); err == nil {
+		t.Error("Expected the kicked connection to be closed")
+	}
+
+	if status := adminRequest(t, "GET", fmt.Sprintf("%s/sessions/%d", baseURL, session.ID), "", nil); status != http.StatusNotFound {
+		t.Errorf("GET kicked session = %d, want 404", status)
+	}
+	if status := adminRequest(t, "DELETE", baseURL+"/sessions/abc", "", nil); status != http.StatusBadRequest {
+		t.Errorf("DELETE invalid id = %d, want 400", status)
+	}
+}
+
+func TestE2E_AdminRequiresToken(t *testing.T) {
+	env, baseURL := setupAdminTestEnv(t, "ops-secret")
+	defer env.Cleanup(t)
+
+	if status := adminRequest(t, "GET", baseURL+"/sessions", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("GET without token = %d, want 401", status)
+	}
+	if status := adminRequest(t, "GET", baseURL+"/sessions", "wrong", nil); status != http.StatusUnauthorized {
+		t.Errorf("GET with wrong token = %d, want 401", status)
+	}
+	if status := adminRequest(t, "GET", baseURL+"/sessions", "ops-secret", nil); status != http.StatusOK {
+		t.Errorf("GET with token = %d, want 200", status)
+	}
+}
+
+func TestNewWithOptions_AdminNeedsTokenOffLocalhost(t *testing.T) {
+	cfg := &config.Config{
+		APIURL:             "http://mock.test",
+		FTPListenAddress:   findAvailablePort(t),
+		AdminEnabled:       true,
+		AdminListenAddress: "0.0.0.0:8090",
+	}
+	if _, err := server.NewWithClient(cfg, clientmgr.NewManager(), apiclient.NewMockClient()); err == nil {
+		t.Fatal("Expected a non-loopback admin address without ADMIN_TOKEN to be rejected")
+	}
+}
+
+func TestE2E_SessionStatsAndSummary(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.SessionSummaryReportEnabled = true
+	})
+	defer env.Cleanup(t)
+
+	conn := env.ConnectPlainFTP(t)
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if err := conn.Stor("ok.jpg", bytes.NewReader([]byte("good photo"))); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	env.MockAPI.SetUploadFailure(errors.New("r2 unavailable"), http.StatusServiceUnavailable)
+	conn.Stor("failed.jpg", bytes.NewReader([]byte("lost photo")))
+	env.MockAPI.SetUploadFailure(nil, 0)
+	time.Sleep(100 * time.Millisecond)
+
+	clients := env.ClientMgr.Clients()
+	if len(clients) != 1 {
+		t.Fatalf("Expected 1 session, got %+v", clients)
+	}
+	stats := clients[0].Stats
+	if stats.FilesOK != 1 || stats.FilesFailed != 1 || stats.Bytes != 10 || stats.LastUploadAt.IsZero() {
+		t.Errorf("Unexpected session stats: %+v", stats)
+	}
+	if !strings.Contains(stats.LastError, "r2 unavailable") {
+		t.Errorf("LastError = %q, want the R2 failure", stats.LastError)
+	}
+	if stats.UploadTime <= 0 || stats.ThroughputMBps() <= 0 {
+		t.Errorf("Expected a positive average throughput, got %+v", stats)
+	}
+
+	// The camera sees the same totals through SITE STATUS
+	raw := env.ConnectRawFTP(t)
+	defer raw.Close()
+	rawCmd(t, raw, 331, "USER test")
+	rawCmd(t, raw, 230, "PASS pass")
+	if status := rawCmd(t, raw, 200, "SITE STATUS"); !strings.Contains(status, "Last upload: none") {
+		t.Errorf("Fresh session SITE STATUS = %q", status)
+	}
+	if err := conn.Quit(); err != nil {
+		t.Fatalf("Quit failed: %v", err)
+	}
+
+	var calls []apiclient.MockSummaryCall
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if calls = env.MockAPI.GetSummaryCalls(); len(calls) == 1 {
+			break
+		}
+	}
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 session summary report, got %d", len(calls))
+	}
+	summary := calls[0].Summary
+	if calls[0].Token != apiclient.MockToken || summary.Protocol != "ftp" || summary.FilesOK != 1 || summary.FilesFailed != 1 ||
+		summary.Bytes != 10 || summary.LastUploadAt == "" || !strings.Contains(summary.LastError, "r2 unavailable") {
+		t.Errorf("Unexpected session summary: %+v (token %q)", summary, calls[0].Token)
+	}
+}
+
+// =============================================================================
+// Concurrency Limit Tests - sessions and uploads per IP, per event and per server
+// =============================================================================
+
+// readGreeting dials a control connection and returns the first reply line
+func readGreeting(t *testing.T, addr string, tlsConfig *tls.Config) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if tlsConfig != nil {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+	return line
+}
+
+// eventually retries check until it succeeds (slots are freed when the server closes the connection)
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestE2E_SessionLimitPerIP(t *testing.T) {
+	sftpAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.FTPMaxSessionsPerIP = 2
+		cfg.SFTPEnabled = true
+		cfg.SFTPListenAddress = sftpAddr
+	})
+	defer env.Cleanup(t)
+	waitForServer(t, sftpAddr, 5*time.Second)
+	time.Sleep(100 * time.Millisecond) // let waitForServer's probes disconnect
+
+	// The cap is shared by every listener
+	first := env.ConnectPlainFTP(t)
+	second := env.ConnectImplicitFTPS(t)
+	defer second.Quit()
+
+	if line := readGreeting(t, env.ExplicitAddr, nil); !strings.HasPrefix(line, "421 Too many concurrent sessions from this address") {
+		t.Errorf("Explicit listener over the cap replied %q, want 421", line)
+	}
+	if line := readGreeting(t, env.ImplicitAddr, &tls.Config{InsecureSkipVerify: true}); !strings.HasPrefix(line, "421 ") {
+		t.Errorf("Implicit listener over the cap replied %q, want 421 over TLS", line)
+	}
+	if _, err := connectSFTP(t, sftpAddr, "test", "pass"); err == nil {
+		t.Error("SFTP connection over the cap succeeded, want it closed before the handshake")
+	}
+
+	// Closing a session frees its slot
+	first.Quit()
+	eventually(t, "a freed session slot", func() bool {
+		return strings.HasPrefix(readGreeting(t, env.ExplicitAddr, nil), "220 ")
+	})
+	// The greeting probe keeps its connection, so free another slot for SFTP
+	second.Quit()
+	eventually(t, "an SFTP session in the freed slot", func() bool {
+		_, err := connectSFTP(t, sftpAddr, "test", "pass")
+		return err == nil
+	})
+}
+
+func TestE2E_PolicyBansAfterConsecutiveFailures(t *testing.T) {
+	sftpAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.PolicyMaxConsecutiveFailures = 2
+		cfg.PolicyFailureBan = 60
+		cfg.SFTPEnabled = true
+		cfg.SFTPListenAddress = sftpAddr
+	})
+	defer env.Cleanup(t)
+	waitForServer(t, sftpAddr, 5*time.Second)
+
+	conn := env.ConnectPlainFTP(t)
+	defer conn.Quit()
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	env.MockAPI.SetUploadFailure(errors.New("r2 unavailable"), http.StatusServiceUnavailable)
+	conn.Stor("first.jpg", bytes.NewReader([]byte("photo")))
+	conn.Stor("second.jpg", bytes.NewReader([]byte("photo")))
+
+	eventually(t, "the failing session to be disconnected", func() bool {
+		return env.ClientMgr.ClientCount() == 0
+	})
+	if line := readGreeting(t, env.ExplicitAddr, nil); !strings.HasPrefix(line, "421 Address temporarily banned") {
+		t.Errorf("Banned address got %q, want 421", line)
+	}
+	if _, err := connectSFTP(t, sftpAddr, "test", "pass"); err == nil {
+		t.Error("Banned address opened an SFTP session, want it refused on accept")
+	}
+	if got := env.MockAPI.GetAuthCallCount(); got != 1 {
+		t.Errorf("Expected only the FTP login to reach the API, got %d auth calls", got)
+	}
+}
+
+func TestE2E_SessionLimitPerEvent(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.FTPMaxSessionsPerEvent = 1
+	})
+	defer env.Cleanup(t)
+
+	first := env.ConnectPlainFTP(t)
+	if err := first.Login("test", "pass"); err != nil {
+		t.Fatalf("First login failed: %v", err)
+	}
+
+	// The event is only known after auth: PASS gets 421 and the connection is closed
+	second := env.ConnectExplicitFTPS(t)
+	defer second.Quit()
+	err := second.Login("test", "pass")
+	if err == nil || !strings.Contains(err.Error(), "421") || !strings.Contains(err.Error(), "too many concurrent sessions for this event") {
+		t.Fatalf("Second login for the event = %v, want 421 from the per-event cap", err)
+	}
+	raw := env.ConnectRawFTP(t)
+	defer raw.Close()
+	rawCmd(t, raw, 331, "USER test")
+	rawCmd(t, raw, 421, "PASS pass")
+	if _, _, err := raw.ReadResponse(0); err == nil {
+		t.Error("Expected the connection to be closed after 421")
+	}
+
+	first.Quit()
+	eventually(t, "a freed event slot", func() bool {
+		conn := env.ConnectPlainFTP(t)
+		defer conn.Quit()
+		return conn.Login("test", "pass") == nil
+	})
+}
+
+func TestE2E_UploadLimitPerEvent(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.FTPMaxUploadsPerEvent = 1
+	})
+	defer env.Cleanup(t)
+
+	sessions := make([]*ftp.ServerConn, 2)
+	for i := range sessions {
+		sessions[i] = env.ConnectPlainFTP(t)
+		defer sessions[i].Quit()
+		if err := sessions[i].Login("test", "pass"); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+	}
+
+	// Hold the first upload open: once data flows the server has opened the file
+	pr, pw := io.Pipe()
+	firstDone := make(chan error, 1)
+	go func() { firstDone <- sessions[0].Stor("slow.jpg", pr) }()
+	if _, err := pw.Write([]byte("first half")); err != nil {
+		t.Fatalf("Failed to start the first upload: %v", err)
+	}
+
+	err := sessions[1].Stor("second.jpg", bytes.NewReader([]byte("second")))
+	if err == nil || !strings.Contains(err.Error(), "450") || !strings.Contains(err.Error(), "too many concurrent uploads for this event") {
+		t.Fatalf("Concurrent upload for the event = %v, want 450 from the per-event cap", err)
+	}
+
+	pw.Close()
+	if err := <-firstDone; err != nil {
+		t.Fatalf("First upload failed: %v", err)
+	}
+	if err := sessions[1].Stor("third.jpg", bytes.NewReader([]byte("third"))); err != nil {
+		t.Fatalf("Upload after the slot was freed failed: %v", err)
+	}
+}
+
+func TestNewWithOptions_RejectsNegativeLimits(t *testing.T) {
+	cfg := &config.Config{
+		APIURL:           "http://mock.test",
+		FTPListenAddress: findAvailablePort(t),
+		FTPMaxUploads:    -1,
+	}
+	if _, err := server.NewWithClient(cfg, clientmgr.NewManager(), apiclient.NewMockClient()); err == nil {
+		t.Fatal("Expected negative FTP_MAX_UPLOADS to be rejected")
+	}
+}
+
+// =============================================================================
+// Graceful Shutdown Tests - drain in-flight uploads, spool what can't finish
+// =============================================================================
+
+func TestE2E_ShutdownDrainsInFlightUploads(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup(t)
+
+	sessions := make([]*ftp.ServerConn, 2)
+	for i := range sessions {
+		sessions[i] = env.ConnectPlainFTP(t)
+		defer sessions[i].Quit()
+		if err := sessions[i].Login("test", "pass"); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+	}
+
+	pr, pw := io.Pipe()
+	uploadDone := make(chan error, 1)
+	go func() { uploadDone <- sessions[0].Stor("in-flight.jpg", pr) }()
+	if _, err := pw.Write([]byte("first half ")); err != nil {
+		t.Fatalf("Failed to start the upload: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second+server.SpoolGrace)
+	defer cancel()
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- env.Server.Shutdown(ctx) }()
+
+	// New connections and new uploads are refused while draining
+	eventually(t, "draining to begin", func() bool {
+		return strings.HasPrefix(readGreeting(t, env.ExplicitAddr, nil), "421 Server is restarting")
+	})
+	// The idle session was sent 421 and closed (see TestE2E_ShutdownWarnsFTPSessions)
+	if err := sessions[1].Stor("late.jpg", bytes.NewReader([]byte("late"))); err == nil {
+		t.Errorf("Upload started during drain succeeded, want it refused")
+	}
+
+	select {
+	case <-shutdownDone:
+		t.Fatal("Shutdown returned with an upload still in flight")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	pw.Write([]byte("second half"))
+	pw.Close()
+	if err := <-uploadDone; err != nil {
+		t.Fatalf("In-flight upload failed during drain: %v", err)
+	}
+	select {
+	case err := <-shutdownDone:
+		if err != nil {
+			t.Errorf("Shutdown after a complete drain = %v, want nil", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Shutdown did not return after the last upload finished")
+	}
+
+	if got := env.MockAPI.GetUploadCallCount(); got != 1 {
+		t.Fatalf("Expected the in-flight upload to reach R2, got %d upload calls", got)
+	}
+	if size := env.MockAPI.GetLastUploadCall().Size; size != int64(len("first half second half")) {
+		t.Errorf("Uploaded %d bytes, want the whole file", size)
+	}
+}
+
+func TestE2E_ShutdownWarnsFTPSessions(t *testing.T) {
+	env := SetupTestEnv(t)
+	defer env.Cleanup(t)
+
+	idle := env.ConnectRawFTP(t)
+	defer idle.Close()
+	rawCmd(t, idle, 331, "USER test")
+	rawCmd(t, idle, 230, "PASS pass")
+
+	uploading := env.ConnectPlainFTP(t)
+	defer uploading.Quit()
+	if err := uploading.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	pr, pw := io.Pipe()
+	uploadDone := make(chan error, 1)
+	go func() { uploadDone <- uploading.Stor("in-flight.jpg", pr) }()
+	if _, err := pw.Write([]byte("first half ")); err != nil {
+		t.Fatalf("Failed to start the upload: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second+server.SpoolGrace)
+	defer cancel()
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- env.Server.Shutdown(ctx) }()
+
+	// The idle session is told right away and closed
+	if _, msg, err := idle.ReadResponse(421); err != nil || !strings.Contains(msg, "shutting down") {
+		t.Fatalf("Idle session got %q, %v, want a 421 shutdown notice", msg, err)
+	}
+	if _, err := idle.ReadLine(); err == nil {
+		t.Error("Expected the idle session to be closed after the notice")
+	}
+
+	// The upload in progress finishes first, then the session gets the notice
+	pw.Write([]byte("second half"))
+	pw.Close()
+	if err := <-uploadDone; err != nil {
+		t.Fatalf("In-flight upload failed during drain: %v", err)
+	}
+	if err := uploading.NoOp(); err == nil || !strings.Contains(err.Error(), "421") {
+		t.Errorf("Command after the upload = %v, want the 421 shutdown notice", err)
+	}
+
+	if err := <-shutdownDone; err != nil {
+		t.Errorf("Shutdown = %v, want nil", err)
+	}
+}
+
+// uploadResults records what the client manager's upload observer receives
+type uploadResults struct {
+	mu      sync.Mutex
+	results []clientmgr.UploadResult
+}
+
+func (r *uploadResults) observe(mgr *clientmgr.Manager) {
+	mgr.SetUploadObserver(func(result clientmgr.UploadResult) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.results = append(r.results, result)
+	})
+}
+
+func (r *uploadResults) all() []clientmgr.UploadResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]clientmgr.UploadResult(nil), r.results...)
+}
+
+func TestE2E_ShutdownSpoolsUnsentUploads(t *testing.T) {
+	spoolDir := t.TempDir()
+	configure := func(cfg *config.Config) {
+		cfg.ShutdownSpoolDir = spoolDir
+		cfg.FTPJWTSecret = tusTestSecret
+	}
+	var spooled uploadResults
+	env := setupMultiModeTestEnv(t, configure, server.TestServerOptions{}, spooled.observe)
+	defer env.Cleanup(t)
+	env.MockAPI.SetUploadDelay(time.Hour) // R2 never answers before the deadline
+
+	conn := env.ConnectPlainFTP(t)
+	defer conn.Quit()
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	data := []byte("complete photo stuck on its way to R2")
+	go conn.Stor("stuck.jpg", bytes.NewReader(data))
+	eventually(t, "the R2 leg to start", func() bool { return env.MockAPI.GetPresignCallCount() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond+server.SpoolGrace)
+	defer cancel()
+	if err := env.Server.Shutdown(ctx); err == nil {
+		t.Error("Expected Shutdown to report the upload still in flight at the deadline")
+	}
+
+	entries, _ := filepath.Glob(filepath.Join(spoolDir, "*.json"))
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 spooled upload, found %d", len(entries))
+	}
+	raw, _ := os.ReadFile(entries[0])
+	var entry struct {
+		Filename       string `json:"filename"`
+		EventID        string `json:"eventId"`
+		PhotographerID string `json:"photographerId"`
+		Size           int64  `json:"size"`
+	}
+	if err := json.Unmarshal(raw, &entry); err != nil || entry.Filename != "/stuck.jpg" || entry.EventID != "evt_test123" ||
+		entry.PhotographerID != "ph_test123" || entry.Size != int64(len(data)) {
+		t.Errorf("Unexpected spool entry %s (%v)", raw, err)
+	}
+	if bytes.Contains(raw, []byte(apiclient.MockToken)) {
+		t.Errorf("Spool entry contains the session token: %s", raw)
+	}
+	// Progress streams learn the upload is not lost, only postponed
+	if results := spooled.all(); len(results) != 1 || !errors.Is(results[0].Err, transfer.ErrSpooled) {
+		t.Errorf("Expected one result with ErrSpooled, got %+v", results)
+	}
+
+	// The next process sends it and empties the spool
+	var replayed uploadResults
+	next := setupMultiModeTestEnv(t, configure, server.TestServerOptions{}, replayed.observe)
+	defer next.Cleanup(t)
+	eventually(t, "the spooled upload to be replayed", func() bool { return next.MockAPI.GetUploadCallCount() == 1 })
+	if size := next.MockAPI.GetLastUploadCall().Size; size != int64(len(data)) {
+		t.Errorf("Replayed %d bytes, want %d", size, len(data))
+	}
+	// The replay is re-authenticated with a token signed for the same photographer and event
+	token := next.MockAPI.GetLastPresignCall().Token
+	parts := strings.Split(token, ".")
+	mac := hmac.New(sha256.New, []byte(tusTestSecret))
+	if len(parts) == 3 {
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+	}
+	if len(parts) != 3 || parts[2] != base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Replay presigned with %q, want a token signed with FTP_JWT_SECRET", token)
+	}
+	if claims, err := httpauth.PeekClaims(token); err != nil || claims.EventID != "evt_test123" || claims.PhotographerID != "ph_test123" {
+		t.Errorf("Replay token claims = %+v (%v)", claims, err)
+	}
+	eventually(t, "the replay result", func() bool { return len(replayed.all()) == 1 })
+	if result := replayed.all()[0]; result.Err != nil || result.Filename != "/stuck.jpg" || result.EventID != entry.EventID {
+		t.Errorf("Unexpected replay result %+v", result)
+	}
+	eventually(t, "the spool to be emptied", func() bool {
+		left, _ := os.ReadDir(spoolDir)
+		return len(left) == 0
+	})
+}
+
+// webhookReceiver collects the deliveries signed with "whsec_e2e"; received returns a snapshot
+func webhookReceiver(t *testing.T) (url string, received func() []webhook.Payload) {
+	t.Helper()
+	var mu sync.Mutex
+	var payloads []webhook.Payload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhook.Verify([]byte("whsec_e2e"), r.Header.Get(webhook.HeaderTimestamp), body, r.Header.Get(webhook.HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload webhook.Payload
+		json.Unmarshal(body, &payload)
+		mu.Lock()
+		payloads = append(payloads, payload)
+		mu.Unlock()
+	}))
+	t.Cleanup(receiver.Close)
+
+	return receiver.URL, func() []webhook.Payload {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhook.Payload(nil), payloads...)
+	}
+}
+
+func TestE2E_WebhooksForSessionAndUploadLifecycle(t *testing.T) {
+	receiverURL, received := webhookReceiver(t)
+
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.WebhookURLs = receiverURL
+		cfg.WebhookSecret = "whsec_e2e"
+	})
+	defer env.Cleanup(t)
+
+	env.MockAPI.SetAuthFailure(errors.New("invalid credentials"))
+	rejected := env.ConnectPlainFTP(t)
+	if err := rejected.Login("test", "wrong"); err == nil {
+		t.Fatal("Expected login to fail")
+	}
+	rejected.Quit()
+	env.MockAPI.SetAuthFailure(nil)
+
+	conn := env.ConnectPlainFTP(t)
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if err := conn.Stor("ok.jpg", bytes.NewReader([]byte("photo"))); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	env.MockAPI.SetUploadFailure(errors.New("r2 unavailable"), http.StatusServiceUnavailable)
+	conn.Stor("broken.jpg", bytes.NewReader([]byte("photo")))
+	conn.Quit()
+
+	want := []string{
+		webhook.EventClientConnected, webhook.EventAuthFailed, webhook.EventUploadStarted,
+		webhook.EventUploadCompleted, webhook.EventUploadFailed, webhook.EventClientDisconnected,
+	}
+	eventually(t, "every lifecycle webhook", func() bool {
+		seen := make(map[string]bool)
+		for _, p := range received() {
+			seen[p.Event] = true
+		}
+		for _, event := range want {
+			if !seen[event] {
+				return false
+			}
+		}
+		return true
+	})
+
+	for _, p := range received() {
+		if p.Event == webhook.EventUploadFailed && p.Data["event_id"] == nil {
+			t.Errorf("upload_failed payload has no event_id: %v", p.Data)
+		}
+	}
+}
+
+func TestE2E_WebhooksForTusSessions(t *testing.T) {
+	receiverURL, received := webhookReceiver(t)
+
+	tusAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.TUSEnabled = true
+		cfg.TUSListenAddress = tusAddr
+		cfg.TUSMaxSize = 10 << 20
+		cfg.FTPJWTSecret = tusTestSecret
+		cfg.WebhookURLs = receiverURL
+		cfg.WebhookSecret = "whsec_e2e"
+	})
+	defer env.Cleanup(t)
+	waitForServer(t, tusAddr, 5*time.Second)
+
+	data := []byte("photo")
+	resp := tusRequest(t, http.MethodPost, "https://"+tusAddr+"/files/", data, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": tusMetadata("tus_webhook.jpg"),
+		"Content-Type":    "application/offset+octet-stream",
+	}, basicAuth)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d", resp.StatusCode)
+	}
+
+	// The upload is the tus session: it connects on creation and disconnects once sent
+	eventually(t, "tus session webhooks", func() bool {
+		seen := make(map[string]any)
+		for _, p := range received() {
+			if p.Data["protocol"] == "tus" {
+				seen[p.Event] = p.Data["reason"]
+			}
+		}
+		_, connected := seen[webhook.EventClientConnected]
+		return connected && seen[webhook.EventClientDisconnected] == "completed"
+	})
+}
+
+// sseEvent is one Server-Sent Event read from a progress stream
+type sseEvent struct {
+	name string
+	data map[string]any
+}
+
+// openProgressStream connects to the event's progress stream and decodes its events
+func openProgressStream(t *testing.T, url string) <-chan sseEvent {
+	t.Helper()
+	resp, err := tusHTTPClient.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Progress stream = %d %s, want 200 text/event-stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan sseEvent, 100)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var name string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var data map[string]any
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data)
+				events <- sseEvent{name: name, data: data}
+			}
+		}
+	}()
+	return events
+}
+
+// nextEvent waits for the next event with the given name, skipping others
+func nextEvent(t *testing.T, events <-chan sseEvent, name string) map[string]any {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("Stream closed before a %s event", name)
+			}
+			if e.name == name {
+				return e.data
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for a %s event", name)
+		}
+	}
+}
+
+func TestE2E_UploadProgressStream(t *testing.T) {
+	progressAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.ProgressEnabled = true
+		cfg.ProgressListenAddress = progressAddr
+		cfg.FTPJWTSecret = tusTestSecret
+	})
+	defer env.Cleanup(t)
+	waitForServer(t, progressAddr, 5*time.Second)
+	streamURL := "https://" + progressAddr + "/events/evt_test123/progress"
+
+	// Only the event's own upload JWT may watch it
+	for name, tc := range map[string]struct {
+		url  string
+		want int
+	}{
+		"no token":    {streamURL, http.StatusUnauthorized},
+		"other event": {streamURL + "?access_token=" + signFtpToken(t, "evt_other"), http.StatusForbidden},
+		"bad token":   {streamURL + "?access_token=not.a.jwt", http.StatusUnauthorized},
+	} {
+		resp, err := tusHTTPClient.Get(tc.url)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: status %d, want %d", name, resp.StatusCode, tc.want)
+		}
+	}
+
+	events := openProgressStream(t, streamURL+"?access_token="+signFtpToken(t, "evt_test123"))
+
+	conn := env.ConnectPlainFTP(t)
+	defer conn.Quit()
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// Hold the upload open so the stream sees it in flight
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- conn.Stor("shot.jpg", pr) }()
+	pw.Write(bytes.Repeat([]byte("x"), 4096))
+
+	tick := nextEvent(t, events, "progress")
+	if tick["file"] != "/shot.jpg" || tick["bytes"].(float64) < 4096 || tick["protocol"] != "ftp" {
+		t.Errorf("Unexpected progress event: %v", tick)
+	}
+
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	completed := nextEvent(t, events, "completed")
+	if completed["file"] != "/shot.jpg" || completed["bytes"].(float64) != 4096 {
+		t.Errorf("Unexpected completed event: %v", completed)
+	}
+
+	env.MockAPI.SetUploadFailure(errors.New("r2 unavailable"), http.StatusServiceUnavailable)
+	conn.Stor("broken.jpg", bytes.NewReader([]byte("photo")))
+	failed := nextEvent(t, events, "failed")
+	if failed["file"] != "/broken.jpg" || failed["error"] == nil {
+		t.Errorf("Unexpected failed event: %v", failed)
+	}
+}
+
+// adminPut sends a JSON body to the admin API and decodes a JSON reply into out (if non-nil)
+func adminPut(t *testing.T, url, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest("PUT", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Failed to decode PUT %s: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestE2E_BandwidthShapingAdjustableAtRuntime(t *testing.T) {
+	env, baseURL := setupAdminTestEnv(t, "")
+	defer env.Cleanup(t)
+
+	conn := env.ConnectPlainFTP(t)
+	defer conn.Quit()
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	const rate = 256 << 10
+	photo := bytes.Repeat([]byte("x"), rate+rate/2)
+	upload := func(name string) time.Duration {
+		start := time.Now()
+		if err := conn.Stor(name, bytes.NewReader(photo)); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		return time.Since(start)
+	}
+
+	if elapsed := upload("unlimited.jpg"); elapsed > 400*time.Millisecond {
+		t.Fatalf("Unshaped upload took %v", elapsed)
+	}
+
+	var state struct {
+		Limits struct {
+			Session int64 `json:"session_bps"`
+			Global  int64 `json:"global_bps"`
+		} `json:"limits"`
+		Events   map[string]int64 `json:"events"`
+		Sessions map[string]int64 `json:"sessions"`
+	}
+	if status := adminPut(t, baseURL+"/bandwidth", fmt.Sprintf(`{"session_bps": %d}`, rate), &state); status != http.StatusOK {
+		t.Fatalf("PUT /bandwidth = %d", status)
+	}
+	if state.Limits.Session != rate || state.Limits.Global != 0 {
+		t.Errorf("Unexpected limits after PUT: %+v", state.Limits)
+	}
+
+	// A second over the burst on the way in, and again on the R2 leg
+	if elapsed := upload("shaped.jpg"); elapsed < 800*time.Millisecond {
+		t.Errorf("Shaped upload took only %v", elapsed)
+	}
+	if size := env.MockAPI.GetLastUploadCall().Size; size != int64(len(photo)) {
+		t.Errorf("R2 received %d bytes, want %d", size, len(photo))
+	}
+
+	// Overrides: an unlimited session beats the default, bad bodies are refused
+	session := ftpSession(t, baseURL)
+	sessionURL := fmt.Sprintf("%s/sessions/%d/bandwidth", baseURL, session.ID)
+	if status := adminPut(t, sessionURL, `{"bps": -1}`, nil); status != http.StatusBadRequest {
+		t.Errorf("Negative rate = %d, want 400", status)
+	}
+	if status := adminPut(t, baseURL+"/bandwidth/events/evt_test123", `{"bps": 1048576}`, &state); status != http.StatusOK || state.Events["evt_test123"] != 1<<20 {
+		t.Errorf("Event override = %d %+v", status, state.Events)
+	}
+	if status := adminPut(t, sessionURL, `{"bps": 104857600}`, &state); status != http.StatusOK || len(state.Sessions) != 1 {
+		t.Errorf("Session override = %d %+v", status, state.Sessions)
+	}
+	if elapsed := upload("overridden.jpg"); elapsed > 800*time.Millisecond {
+		t.Errorf("Upload with a raised session limit took %v", elapsed)
+	}
+}
+
+func TestE2E_UploadSlotsQueueR2Puts(t *testing.T) {
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.UploadSlots = 1
+	})
+	defer env.Cleanup(t)
+	const putTime = 200 * time.Millisecond
+	env.MockAPI.SetUploadDelay(putTime)
+
+	// Two cameras finish a file at the same time; their PUTs go one after the other
+	var wg sync.WaitGroup
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		conn := env.ConnectPlainFTP(t)
+		defer conn.Quit()
+		if err := conn.Login("test", "pass"); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+		wg.Add(1)
+		go func(conn *ftp.ServerConn, name string) {
+			defer wg.Done()
+			if err := conn.Stor(name, bytes.NewReader([]byte("photo"))); err != nil {
+				t.Errorf("Upload of %s failed: %v", name, err)
+			}
+		}(conn, name)
+	}
+	wg.Wait()
+
+	if n := env.MockAPI.GetUploadCallCount(); n != 2 {
+		t.Fatalf("Expected 2 PUTs, got %d", n)
+	}
+	first, second := env.MockAPI.UploadCalls[0], env.MockAPI.UploadCalls[1]
+	if gap := second.Time.Sub(first.Time); gap < putTime*3/4 {
+		t.Errorf("Second PUT finished %v after the first, want it queued behind it (~%v)", gap, putTime)
+	}
+}
+
+func TestE2E_UploadSlotsLetOtherEventsOvertakeABacklog(t *testing.T) {
+	tusAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.UploadSlots = 1
+		cfg.TUSEnabled = true
+		cfg.TUSListenAddress = tusAddr
+		cfg.TUSMaxSize = 10 << 20
+		cfg.FTPJWTSecret = tusTestSecret
+	})
+	defer env.Cleanup(t)
+	waitForServer(t, tusAddr, 5*time.Second)
+	env.MockAPI.SetUploadDelay(time.Second) // long enough for everything to queue behind the first PUT
+	waiting := func() int { return env.ClientMgr.Scheduler().Waiting()["image"] }
+
+	// One event dumps large files: the first takes the only slot, the others wait
+	large := bytes.Repeat([]byte("L"), 1<<20)
+	var wg sync.WaitGroup
+	store := func(name string) {
+		conn := env.ConnectPlainFTP(t)
+		if err := conn.Login("test", "pass"); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Quit()
+			if err := conn.Stor(name, bytes.NewReader(large)); err != nil {
+				t.Errorf("Upload of %s failed: %v", name, err)
+			}
+		}()
+	}
+	store("large1.jpg")
+	eventually(t, "the first large file to take the slot", func() bool { return env.MockAPI.GetPresignCallCount() == 1 })
+	store("large2.jpg")
+	eventually(t, "the second large file to queue", func() bool { return waiting() == 1 })
+	store("large3.jpg")
+	eventually(t, "the third large file to queue", func() bool { return waiting() == 2 })
+
+	// A JPEG from another event arrives last and takes the next turn, ahead of large3
+	photo := []byte("small preview jpeg")
+	resp := tusRequest(t, http.MethodPost, "https://"+tusAddr+"/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(photo)),
+		"Upload-Metadata": tusMetadata("preview.jpg"),
+	}, bearerAuth(signFtpToken(t, "evt_other")))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d", resp.StatusCode)
+	}
+	uploadURL := "https://" + tusAddr + resp.Header.Get("Location")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp := tusRequest(t, http.MethodPatch, uploadURL, photo, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		}, bearerAuth(signFtpToken(t, "evt_other")))
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("PATCH: expected 204, got %d", resp.StatusCode)
+		}
+	}()
+	eventually(t, "the JPEG to queue", func() bool { return waiting() == 3 })
+
+	wg.Wait()
+	eventually(t, "all four PUTs", func() bool { return env.MockAPI.GetUploadCallCount() == 4 })
+	var sizes []int64
+	for _, call := range env.MockAPI.UploadCalls {
+		sizes = append(sizes, call.Size)
+	}
+	if want := []int64{int64(len(large)), int64(len(large)), int64(len(photo)), int64(len(large))}; !slices.Equal(sizes, want) {
+		t.Errorf("PUT sizes in order = %v, want %v (the JPEG ahead of the queued large file)", sizes, want)
+	}
+}
+
+// =============================================================================
+// Upload Buffer Tests - SPOOL_DIR free space floor, MAX_FILE_SIZE, orphan sweep
+// =============================================================================
+
+func TestE2E_UploadBufferRefusesStorWhenDiskIsLow(t *testing.T) {
+	bufferDir := t.TempDir()
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.SpoolDir = bufferDir
+		cfg.SpoolMinFreeBytes = math.MaxInt
+	})
+	defer env.Cleanup(t)
+
+	conn := env.ConnectPlainFTP(t)
+	defer conn.Quit()
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// Refused at STOR, before the data connection is used
+	err := conn.Stor("photo.jpg", bytes.NewReader([]byte("photo")))
+	if err == nil || !strings.Contains(err.Error(), "452") || !strings.Contains(err.Error(), "low on disk space") {
+		t.Fatalf("Upload with a full buffer disk = %v, want 452", err)
+	}
+	if n := env.MockAPI.GetPresignCallCount(); n != 0 {
+		t.Errorf("Refused upload was presigned %d times", n)
+	}
+	if left, _ := os.ReadDir(bufferDir); len(left) != 0 {
+		t.Errorf("Refused upload left %d files in SPOOL_DIR", len(left))
+	}
+}
+
+func TestE2E_MaxFileSizePerSession(t *testing.T) {
+	bufferDir := t.TempDir()
+	adminAddr := findAvailablePort(t)
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.SpoolDir = bufferDir
+		cfg.MaxFileSize = 1024
+		cfg.AdminEnabled = true
+		cfg.AdminListenAddress = adminAddr
+	})
+	defer env.Cleanup(t)
+	waitForServer(t, adminAddr, 5*time.Second)
+	baseURL := "http://" + adminAddr
+
+	conn := env.ConnectPlainFTP(t)
+	defer conn.Quit()
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	photo := bytes.Repeat([]byte("x"), 2048)
+	err := conn.Stor("big.jpg", bytes.NewReader(photo))
+	if err == nil || !strings.Contains(err.Error(), "552") || !strings.Contains(err.Error(), "exceeds the maximum size") {
+		t.Fatalf("Oversized upload = %v, want 552", err)
+	}
+	eventually(t, "the oversized upload to be discarded", func() bool {
+		left, _ := os.ReadDir(bufferDir)
+		return len(left) == 0
+	})
+	if n := env.MockAPI.GetUploadCallCount(); n != 0 {
+		t.Errorf("Oversized upload reached R2 (%d PUTs)", n)
+	}
+
+	// The admin API raises the limit for this session only
+	sessionURL := fmt.Sprintf("%s/sessions/%d/max-file-size", baseURL, ftpSession(t, baseURL).ID)
+	if status := adminPut(t, sessionURL, `{"bytes": -1}`, nil); status != http.StatusBadRequest {
+		t.Errorf("Negative size = %d, want 400", status)
+	}
+	var session struct {
+		MaxFileSize int64 `json:"max_file_size"`
+	}
+	if status := adminPut(t, sessionURL, `{"bytes": 4096}`, &session); status != http.StatusOK || session.MaxFileSize != 4096 {
+		t.Fatalf("Session override = %d %+v", status, session)
+	}
+	if err := conn.Stor("big.jpg", bytes.NewReader(photo)); err != nil {
+		t.Fatalf("Upload under the raised limit failed: %v", err)
+	}
+	if size := env.MockAPI.GetLastUploadCall().Size; size != int64(len(photo)) {
+		t.Errorf("R2 received %d bytes, want %d", size, len(photo))
+	}
+}
+
+func TestE2E_StartupSweepRecoversOrphanedBufferFiles(t *testing.T) {
+	bufferDir := t.TempDir()
+	spoolDir := t.TempDir()
+
+	// What a crash leaves: a complete file waiting for its PUT, a partial one, and one without an entry
+	orphan := func(name, data string, entry map[string]any) {
+		path := filepath.Join(bufferDir, "sabaipics-ftp-"+name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if entry != nil {
+			raw, _ := json.Marshal(entry)
+			os.WriteFile(path+".json", raw, 0o600)
+		}
+	}
+	photo := "complete photo from before the crash"
+	orphan("1", photo, map[string]any{
+		"filename": "/crashed.jpg", "contentType": "image/jpeg", "size": len(photo),
+		"eventId": "evt_test123", "photographerId": "ph_test123",
+	})
+	orphan("2", "half a pho", map[string]any{"filename": "/partial.jpg", "size": 100, "eventId": "evt_test123"})
+	orphan("3", "no entry", nil)
+	os.WriteFile(filepath.Join(bufferDir, "other.bin"), []byte("not ours"), 0o600)
+
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.SpoolDir = bufferDir
+		cfg.ShutdownSpoolDir = spoolDir
+		cfg.FTPJWTSecret = tusTestSecret
+	})
+	defer env.Cleanup(t)
+
+	eventually(t, "the recovered file to be uploaded", func() bool { return env.MockAPI.GetUploadCallCount() == 1 })
+	if size := env.MockAPI.GetLastUploadCall().Size; size != int64(len(photo)) {
+		t.Errorf("Recovered upload sent %d bytes, want %d", size, len(photo))
+	}
+	eventually(t, "the spool to be emptied", func() bool {
+		left, _ := os.ReadDir(spoolDir)
+		return len(left) == 0
+	})
+	left, _ := os.ReadDir(bufferDir)
+	if len(left) != 1 || left[0].Name() != "other.bin" {
+		t.Errorf("SPOOL_DIR after the sweep = %v, want only the unrelated file", left)
+	}
+}
+
+// =============================================================================
+// Encryption at Rest Tests - buffered and spooled uploads are sealed on disk
+// =============================================================================
+
+const testSpoolKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+// plaintextMarker stands in for image content that must never reach disk in the clear
+var plaintextMarker = []byte("\xff\xd8\xff\xe0JFIF-plaintext-photo-marker")
+
+func markedPhoto(size int) []byte {
+	return bytes.Repeat(plaintextMarker, size/len(plaintextMarker)+1)[:size]
+}
+
+// assertNoPlaintext fails if any file in dir contains the marker
+func assertNoPlaintext(t *testing.T, dir string) {
+	t.Helper()
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		raw, _ := os.ReadFile(filepath.Join(dir, e.Name()))
+		if bytes.Contains(raw, plaintextMarker) {
+			t.Errorf("%s holds plaintext", e.Name())
+		}
+	}
+}
+
+func TestE2E_EncryptedUploadBufferRoundTrip(t *testing.T) {
+	bufferDir := t.TempDir()
+	env := SetupMultiModeTestEnvWithConfig(t, func(cfg *config.Config) {
+		cfg.SpoolDir = bufferDir
+		cfg.SpoolEncryption = true
+	})
+	defer env.Cleanup(t)
+
+	conn := env.ConnectPlainFTP(t)
+	defer conn.Quit()
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	photo := markedPhoto(300 << 10)
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- conn.Stor("photo.jpg", pr) }()
+	if _, err := pw.Write(photo[:200<<10]); err != nil {
+		t.Fatalf("Failed to start the upload: %v", err)
+	}
+
+	// Mid-transfer the buffer holds sealed chunks only
+	eventually(t, "sealed chunks to reach disk", func() bool {
+		return dirSize(bufferDir) >= 128<<10
+	})
+	assertNoPlaintext(t, bufferDir)
+
+	pw.Write(photo[200<<10:])
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	// R2 gets the plaintext with its real length
+	call := env.MockAPI.GetLastUploadCall()
+	if !bytes.Equal(call.Data, photo) {
+		t.Errorf("R2 received %d bytes that differ from the %d sent", len(call.Data), len(photo))
+	}
+	if got := call.Headers["Content-Length"]; got != strconv.Itoa(len(photo)) {
+		t.Errorf("Content-Length = %s, want %d", got, len(photo))
+	}
+	eventually(t, "the buffer to be emptied", func() bool { return dirSize(bufferDir) == 0 })
+}
+
+func dirSize(dir string) int64 {
+	entries, _ := os.ReadDir(dir)
+	var n int64
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			n += info.Size()
+		}
+	}
+	return n
+}
+
+func TestE2E_EncryptedSpoolSurvivesRestart(t *testing.T) {
+	spoolDir := t.TempDir()
+	configure := func(cfg *config.Config) {
+		cfg.SpoolDir = t.TempDir()
+		cfg.ShutdownSpoolDir = spoolDir
+		cfg.FTPJWTSecret = tusTestSecret
+		cfg.SpoolEncryption = true
+		cfg.SpoolEncryptionKey = testSpoolKey
+	}
+	env := SetupMultiModeTestEnvWithConfig(t, configure)
+	defer env.Cleanup(t)
+	env.MockAPI.SetUploadDelay(time.Hour) // R2 never answers before the deadline
+
+	conn := env.ConnectPlainFTP(t)
+	defer conn.Quit()
+	if err := conn.Login("test", "pass"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	photo := markedPhoto(100 << 10)
+	go conn.Stor("stuck.jpg", bytes.NewReader(photo))
+	eventually(t, "the R2 leg to start", func() bool { return env.MockAPI.GetPresignCallCount() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond+server.SpoolGrace)
+	defer cancel()
+	if err := env.Server.Shutdown(ctx); err == nil {
+		t.Error("Expected Shutdown to report the upload still in flight at the deadline")
+	}
+
+	if entries, _ := filepath.Glob(filepath.Join(spoolDir, "*.data")); len(entries) != 1 {
+		t.Fatalf("Expected 1 spooled upload, found %d", len(entries))
+	}
+	assertNoPlaintext(t, spoolDir)
+
+	// The next process derives the file key from the same master key
+	next := SetupMultiModeTestEnvWithConfig(t, configure)
+	defer next.Cleanup(t)
+	eventually(t, "the spooled upload to be replayed", func() bool { return next.MockAPI.GetUploadCallCount() == 1 })
+	call := next.MockAPI.GetLastUploadCall()
+	if !bytes.Equal(call.Data, photo) || call.Headers["Content-Length"] != strconv.Itoa(len(photo)) {
+		t.Errorf("Replayed %d bytes (Content-Length %s), want the %d-byte original", len(call.Data), call.Headers["Content-Length"], len(photo))
+	}
+}
+
+func TestNewWithOptions_SpoolEncryptionSettings(t *testing.T) {
+	for name, configure := range map[string]func(cfg *config.Config){
+		"shutdown spool without a master key": func(cfg *config.Config) {
+			cfg.ShutdownSpoolDir = t.TempDir()
+		},
+		"malformed master key": func(cfg *config.Config) {
+			cfg.SpoolEncryptionKey = "c2hvcnQ="
+		},
+		"shutdown spool without FTP_JWT_SECRET": func(cfg *config.Config) {
+			cfg.ShutdownSpoolDir = t.TempDir()
+			cfg.SpoolEncryptionKey = testSpoolKey
+			cfg.FTPJWTSecret = ""
+		},
+	} {
+		cfg := &config.Config{
+			APIURL:           "http://mock.test",
+			FTPListenAddress: "127.0.0.1:0",
+			SpoolDir:         t.TempDir(),
+			SpoolEncryption:  true,
+			FTPJWTSecret:     tusTestSecret,
+		}
+		configure(cfg)
+		if _, err := server.NewWithOptions(cfg, clientmgr.NewManager(), server.TestServerOptions{APIClient: apiclient.NewMockClient()}); err == nil {
+			t.Errorf("%s: expected NewWithOptions to fail", name)
+		}
+	}
+}
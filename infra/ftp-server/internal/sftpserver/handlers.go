@@ -0,0 +1,203 @@
+package sftpserver
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/client"
+	"github.com/spf13/afero"
+)
+
+// maxPendingBytes bounds out-of-order write data held per file
+// Clients pipeline a few dozen 32KB writes, so this is never reached by well-behaved clients.
+const maxPendingBytes = 64 << 20
+
+var (
+	errRewriteNotSupported = errors.New("rewriting uploaded data is not supported")
+	errTooManyPending      = errors.New("too many out-of-order writes")
+	errIncompleteUpload    = errors.New("upload has gaps - file discarded")
+)
+
+// handlers maps SFTP requests onto the upload-only ClientDriver
+// Uploads go through the same pipeline as FTP STOR; everything else is an accepted no-op.
+type handlers struct {
+	driver *client.ClientDriver
+}
+
+func newHandlers(driver *client.ClientDriver) sftp.Handlers {
+	h := &handlers{driver: driver}
+	return sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}
+}
+
+// Fileread blocks downloads, like FTP RETR
+func (h *handlers) Fileread(*sftp.Request) (io.ReaderAt, error) {
+	return nil, client.ErrDownloadNotAllowed
+}
+
+// Filewrite starts an upload through the ClientDriver
+func (h *handlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	file, err := h.driver.OpenFile(r.Filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return newSequentialWriter(file), nil
+}
+
+// Filecmd accepts rename/remove/mkdir/setstat as no-ops, matching the FTP driver
+func (h *handlers) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return nil
+	case "Rename":
+		return h.driver.Rename(r.Filepath, r.Target)
+	case "Rmdir":
+		return h.driver.RemoveAll(r.Filepath)
+	case "Remove":
+		return h.driver.Remove(r.Filepath)
+	case "Mkdir":
+		return h.driver.Mkdir(r.Filepath, 0755)
+	case "Symlink", "Link":
+		return h.driver.Symlink(r.Target, r.Filepath)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// Filelist serves directory listings and stat from the ClientDriver's fake tree
+func (h *handlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		entries, err := h.driver.ReadDir(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(entries), nil
+	case "Stat", "Lstat":
+		info, err := h.driver.Stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(out []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(out, l[offset:])
+	if n < len(out) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sequentialWriter turns SFTP's offset writes into the sequential stream UploadTransfer expects
+// Clients pipeline writes, so chunks can arrive out of order; they are held until the gap is filled.
+type sequentialWriter struct {
+	mu           sync.Mutex
+	file         afero.File
+	next         int64            // offset of the next byte the upload expects
+	pending      map[int64][]byte // out-of-order chunks by offset
+	pendingBytes int
+	err          error
+}
+
+func newSequentialWriter(file afero.File) *sequentialWriter {
+	return &sequentialWriter{
+		file:    file,
+		pending: make(map[int64][]byte),
+	}
+}
+
+// WriteAt implements io.WriterAt
+func (w *sequentialWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	switch {
+	case off < w.next:
+		w.err = errRewriteNotSupported
+		return 0, w.err
+
+	case off > w.next:
+		if w.pendingBytes+len(p) > maxPendingBytes {
+			w.err = errTooManyPending
+			return 0, w.err
+		}
+		w.pending[off] = append([]byte(nil), p...)
+		w.pendingBytes += len(p)
+		return len(p), nil
+	}
+
+	if err := w.write(p); err != nil {
+		return 0, err
+	}
+
+	// Drain chunks that are now contiguous
+	for {
+		chunk, ok := w.pending[w.next]
+		if !ok {
+			break
+		}
+		delete(w.pending, w.next)
+		w.pendingBytes -= len(chunk)
+		if err := w.write(chunk); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *sequentialWriter) write(p []byte) error {
+	n, err := w.file.Write(p)
+	w.next += int64(n)
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// TransferError is called when the SFTP session ends with the file still open
+func (w *sequentialWriter) TransferError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// Close finishes the upload; partial or gapped files are discarded instead of uploaded
+func (w *sequentialWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err == nil && len(w.pending) > 0 {
+		w.err = errIncompleteUpload
+	}
+	if w.err != nil {
+		if te, ok := w.file.(interface{ TransferError(error) }); ok {
+			te.TransferError(w.err)
+		}
+		w.file.Close()
+		return w.err
+	}
+
+	return w.file.Close()
+}
@@ -0,0 +1,336 @@
+package sftpserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/client"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/handoff"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/limits"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+	"golang.org/x/crypto/ssh"
+)
+
+// handshakeTimeout bounds the SSH handshake including password auth
+const handshakeTimeout = 30 * time.Second
+
+// Server is the SSH/SFTP frontend
+// Sessions authenticate with the same FTP credentials and upload through client.ClientDriver.
+type Server struct {
+	config    *config.Config
+	clientMgr *clientmgr.Manager
+	apiClient apiclient.APIClient
+	hostKey   ssh.Signer
+	limiter   *limits.Limiter // Session and upload caps shared with the FTP listeners (nil = unlimited)
+
+	mu       sync.Mutex
+	listener net.Listener
+	stopped  bool
+}
+
+// New creates the SFTP server, loading (or generating) the SSH host key
+func New(cfg *config.Config, clientMgr *clientmgr.Manager, apiClient apiclient.APIClient) (*Server, error) {
+	hostKey, err := loadHostKey(cfg.SFTPHostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		config:    cfg,
+		clientMgr: clientMgr,
+		apiClient: apiClient,
+		hostKey:   hostKey,
+	}, nil
+}
+
+// SetLimiter applies the FTP_MAX_* caps to SFTP sessions and uploads; set it before ListenAndServe
+// The limiter also refuses new sessions while the server drains.
+func (s *Server) SetLimiter(limiter *limits.Limiter) {
+	s.limiter = limiter
+}
+
+// ListenAndServe accepts SFTP connections until Stop is called
+func (s *Server) ListenAndServe() error {
+	listener, err := handoff.Listen("sftp", s.config.SFTPListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.SFTPListenAddress, err)
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	log.Printf("sftp_listening addr=%s host_key=%s", listener.Addr(), ssh.FingerprintSHA256(s.hostKey.PublicKey()))
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("sftp_accept_error error=%v", err)
+			continue
+		}
+
+		// Banned or over a session cap, the connection is closed before the handshake: SSH has
+		// no reply to explain it, so the refusal is only logged
+		release, err := s.admit(conn)
+		if err != nil {
+			log.Printf("sftp_connection_refused client=%s error=%v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			defer release()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// admit checks a new connection against policy bans and the session caps, like the FTP listeners
+func (s *Server) admit(conn net.Conn) (release func(), err error) {
+	if s.limiter != nil {
+		// The limiter runs the ban check too (limits.Limiter.SetBanCheck)
+		return s.limiter.AcquireSession(limits.HostOf(conn.RemoteAddr().String()))
+	}
+	if err := s.clientMgr.CheckBan(conn.RemoteAddr().String()); err != nil {
+		return nil, err
+	}
+	return func() {}, nil
+}
+
+// ListenerFile returns a duplicate of the listening socket for a graceful restart
+func (s *Server) ListenerFile() (string, *os.File, error) {
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+	if listener == nil {
+		return "sftp", nil, errors.New("listener not started")
+	}
+	file, err := handoff.File(listener)
+	return "sftp", file, err
+}
+
+// Stop closes the listener; connected sessions finish on their own
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// handleConn authenticates one SSH connection and serves its SFTP channels
+func (s *Server) handleConn(conn net.Conn) {
+	clientIP := conn.RemoteAddr().String()
+
+	// The auth response is captured per connection for the session driver
+	var authResp *apiclient.AuthResponse
+	releaseEvent := func() {}
+	defer func() { releaseEvent() }()
+	sshConfig := &ssh.ServerConfig{
+		ServerVersion: "SSH-2.0-SabaiPics",
+		MaxAuthTries:  3,
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			resp, err := s.authenticate(meta.User(), string(password), clientIP)
+			if err != nil {
+				return nil, err
+			}
+			// The per-event cap is only known after auth, like an FTP login that gets 421
+			if s.limiter != nil {
+				release, err := s.limiter.AcquireEventSession(resp.EventID)
+				if err != nil {
+					log.Printf("sftp_login_refused client=%s event=%s error=%v", clientIP, resp.EventID, err)
+					return nil, err
+				}
+				releaseEvent = release
+			}
+			authResp = resp
+			return &ssh.Permissions{}, nil
+		},
+	}
+	sshConfig.AddHostKey(s.hostKey)
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, sshConfig)
+	if err != nil {
+		log.Printf("sftp_handshake_failed client=%s error=%v", clientIP, err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	defer sshConn.Close()
+
+	go ssh.DiscardRequests(requests)
+
+	clientID := s.clientMgr.RegisterClient(sshConn)
+	defer s.clientMgr.UnregisterClient(clientID)
+	s.clientMgr.SetLogin(clientID, "sftp", authResp.EventID, authResp.Token)
+
+	observability.EmitLog(context.Background(), "info", "client_connected", map[string]any{
+		"client_ip":  clientIP,
+		"session_id": clientID,
+		"protocol":   "sftp",
+		"event_id":   authResp.EventID,
+	})
+	defer observability.EmitLog(context.Background(), "info", "client_disconnected", map[string]any{
+		"client_ip":  clientIP,
+		"session_id": clientID,
+		"protocol":   "sftp",
+		"event_id":   authResp.EventID,
+	})
+
+	driver := client.NewClientDriver(authResp, clientIP, clientID, s.clientMgr, s.apiClient, s.config)
+	driver.SetLimiter(s.limiter)
+	handlers := newHandlers(driver)
+
+	var sessions sync.WaitGroup
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("sftp_channel_accept_failed client=%s error=%v", clientIP, err)
+			continue
+		}
+
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			s.serveSession(channel, channelRequests, handlers, clientIP)
+		}()
+	}
+	sessions.Wait()
+}
+
+// authenticate checks SFTP credentials against the same API as FTP logins
+func (s *Server) authenticate(user, pass, clientIP string) (*apiclient.AuthResponse, error) {
+	log.Printf("auth_attempt user=%s client=%s protocol=sftp", user, clientIP)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	authResp, err := s.apiClient.Authenticate(ctx, apiclient.AuthRequest{
+		Username: user,
+		Password: pass,
+	})
+	if err != nil {
+		observability.EmitLog(ctx, "error", "auth_failed", map[string]any{
+			"user":      user,
+			"client_ip": clientIP,
+			"protocol":  "sftp",
+			"error":     err.Error(),
+		})
+		return nil, fmt.Errorf("authentication failed")
+	}
+
+	log.Printf("auth_ok user=%s event=%s credits=%d protocol=sftp",
+		user, authResp.EventID, authResp.CreditsRemaining)
+	return authResp, nil
+}
+
+// serveSession starts the SFTP subsystem on a session channel; shells and exec are refused
+func (s *Server) serveSession(channel ssh.Channel, requests <-chan *ssh.Request, handlers sftp.Handlers, clientIP string) {
+	defer channel.Close()
+
+	var served sync.WaitGroup
+	for req := range requests {
+		ok := req.Type == "subsystem" && subsystemName(req.Payload) == "sftp"
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+
+		served.Add(1)
+		go func() {
+			defer served.Done()
+			server := sftp.NewRequestServer(channel, handlers)
+			if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+				log.Printf("sftp_session_error client=%s error=%v", clientIP, err)
+			}
+			server.Close()
+			channel.Close()
+		}()
+	}
+	served.Wait()
+}
+
+// subsystemName decodes the SSH string payload of a subsystem request
+func subsystemName(payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+	n := binary.BigEndian.Uint32(payload)
+	if uint64(n) > uint64(len(payload)-4) {
+		return ""
+	}
+	return string(payload[4 : 4+n])
+}
+
+// loadHostKey reads the SSH host key, generating and saving one on first start
+// An empty path uses an ephemeral key (clients will see a new fingerprint every restart).
+func loadHostKey(path string) (ssh.Signer, error) {
+	if path != "" {
+		keyPEM, err := os.ReadFile(path)
+		if err == nil {
+			signer, err := ssh.ParsePrivateKey(keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse SFTP host key %s: %w", path, err)
+			}
+			return signer, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read SFTP host key: %w", err)
+		}
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SFTP host key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		log.Printf("sftp_host_key_ephemeral fingerprint=%s", ssh.FingerprintSHA256(signer.PublicKey()))
+		return signer, nil
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, "sabaipics-ftp")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to save SFTP host key: %w", err)
+	}
+	log.Printf("sftp_host_key_generated path=%s fingerprint=%s", path, ssh.FingerprintSHA256(signer.PublicKey()))
+	return signer, nil
+}
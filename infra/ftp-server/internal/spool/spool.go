@@ -0,0 +1,166 @@
+package spool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Entry describes a spooled upload: everything needed to presign and PUT it again
+type Entry struct {
+	Filename       string    `json:"filename"`
+	ContentType    string    `json:"contentType"`
+	Size           int64     `json:"size"`
+	EventID        string    `json:"eventId"`
+	PhotographerID string    `json:"photographerId"` // with EventID, re-authenticates the replay; the session's JWT is never stored
+	ClientIP       string    `json:"clientIp"`
+	SpooledAt      time.Time `json:"spooledAt"`
+}
+
+// Spool keeps complete uploads that could not reach storage before shutdown
+// Each upload is a <id>.data file plus a <id>.json entry written after it, so a
+// crash mid-save never leaves an entry without its data.
+type Spool struct {
+	dir string
+}
+
+// New opens (and creates) the spool directory
+func New(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory %s: %w", dir, err)
+	}
+	return &Spool{dir: dir}, nil
+}
+
+// Dir returns the spool directory
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// Save moves the buffered file at dataPath into the spool
+func (s *Spool) Save(dataPath string, entry Entry) error {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(idBytes)
+	target := filepath.Join(s.dir, id+".data")
+
+	if err := moveFile(dataPath, target); err != nil {
+		return fmt.Errorf("failed to spool %s: %w", entry.Filename, err)
+	}
+
+	if entry.SpooledAt.IsZero() {
+		entry.SpooledAt = time.Now().UTC()
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+	// Write then rename so Replay never reads a partial entry
+	tmp := filepath.Join(s.dir, id+".json.tmp")
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		os.Remove(target)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, id+".json")); err != nil {
+		os.Remove(tmp)
+		os.Remove(target)
+		return err
+	}
+	return nil
+}
+
+// Replay calls upload for every spooled entry (oldest first) and removes it on success
+// When upload returns an error for which drop reports true, the entry is discarded;
+// any other error keeps it for the next start.
+func (s *Spool) Replay(ctx context.Context, upload func(ctx context.Context, entry Entry, dataPath string) error, drop func(error) bool) (sent, dropped, kept int) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		log.Printf("spool_replay_failed dir=%s error=%v", s.dir, err)
+		return 0, 0, 0
+	}
+
+	for _, entryPath := range paths {
+		if ctx.Err() != nil {
+			kept++
+			continue
+		}
+		dataPath := strings.TrimSuffix(entryPath, ".json") + ".data"
+
+		raw, err := os.ReadFile(entryPath)
+		var entry Entry
+		if err == nil {
+			err = json.Unmarshal(raw, &entry)
+		}
+		if err != nil {
+			log.Printf("spool_entry_invalid path=%s error=%v", entryPath, err)
+			s.remove(entryPath, dataPath)
+			dropped++
+			continue
+		}
+
+		err = upload(ctx, entry, dataPath)
+		switch {
+		case err == nil:
+			log.Printf("spool_upload_ok file=%s event=%s bytes=%d spooled_at=%s", entry.Filename, entry.EventID, entry.Size, entry.SpooledAt.Format(time.RFC3339))
+			s.remove(entryPath, dataPath)
+			sent++
+		case drop != nil && drop(err):
+			log.Printf("spool_upload_dropped file=%s event=%s error=%v", entry.Filename, entry.EventID, err)
+			s.remove(entryPath, dataPath)
+			dropped++
+		default:
+			log.Printf("spool_upload_failed file=%s event=%s error=%v (kept for next start)", entry.Filename, entry.EventID, err)
+			kept++
+		}
+	}
+	return sent, dropped, kept
+}
+
+func (s *Spool) remove(entryPath, dataPath string) {
+	os.Remove(entryPath)
+	os.Remove(dataPath)
+}
+
+// moveFile renames src to dst, copying when they are on different filesystems (e.g. /tmp is tmpfs)
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,280 @@
+package spoolcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// File layout: magic, salt, then chunks of up to ChunkSize plaintext bytes, each sealed with
+// AES-256-GCM. The nonce is the chunk number plus a flag on the last chunk, so chunks can't be
+// reordered, dropped or cut off without failing authentication. Keys are never reused across files.
+const (
+	// ChunkSize is the plaintext size of every chunk but the last
+	ChunkSize = 64 << 10
+
+	magic     = "SPC1"
+	saltSize  = 32
+	keySize   = 32
+	headerLen = len(magic) + saltSize
+	sealedLen = ChunkSize + 16 // GCM tag
+	hkdfInfo  = "sabaipics-ftp spool file v1"
+)
+
+var (
+	// ErrNoKey is returned for a sealed file when neither its key nor the master key is available
+	ErrNoKey = errors.New("no key to decrypt spooled file")
+	// ErrCorrupt is returned when a sealed file fails authentication or is truncated
+	ErrCorrupt = errors.New("spooled file is corrupt or was tampered with")
+)
+
+// Keys seals spooled files; a nil *Keys writes plaintext
+// With a master key each file's key is derived from it and the salt in the file header, so files
+// survive a restart. Without one each file gets a random key that only lives in memory.
+type Keys struct {
+	master []byte
+}
+
+// ParseMasterKey decodes SPOOL_ENCRYPTION_KEY (32 bytes, base64)
+func ParseMasterKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("SPOOL_ENCRYPTION_KEY must be %d random bytes in base64 (e.g. openssl rand -base64 32)", keySize)
+	}
+	return key, nil
+}
+
+// New creates Keys; master may be nil for per-file keys held in memory only
+func New(master []byte) *Keys {
+	return &Keys{master: master}
+}
+
+// Durable reports whether sealed files can still be opened after a restart (a master key is set)
+func (k *Keys) Durable() bool {
+	return k != nil && k.master != nil
+}
+
+// NewWriter seals everything written to it into w; Close writes the last chunk
+// The returned key is the file's key, which the caller keeps to open the file again. It is nil
+// with a master key, as the key can then be derived from the file itself.
+func (k *Keys) NewWriter(w io.Writer) (*Writer, []byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+
+	var key, kept []byte
+	if k.Durable() {
+		derived, err := hkdf.Key(sha256.New, k.master, salt, hkdfInfo, keySize)
+		if err != nil {
+			return nil, nil, err
+		}
+		key = derived
+	} else {
+		key = make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, nil, err
+		}
+		kept = key
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := w.Write(append([]byte(magic), salt...)); err != nil {
+		return nil, nil, err
+	}
+	return &Writer{w: w, aead: aead, buf: make([]byte, 0, ChunkSize)}, kept, nil
+}
+
+// Writer seals a stream into chunks
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	buf    []byte
+	chunk  uint64
+	sealed []byte
+	closed bool
+}
+
+// Write buffers p, sealing each full chunk once more data follows it
+// A chunk is only written when it is known not to be the last.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk (empty for an empty stream); it does not close the underlying writer
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *Writer) flush(last bool) error {
+	w.sealed = w.aead.Seal(w.sealed[:0], nonce(w.chunk, last), w.buf, nil)
+	clear(w.buf)
+	w.buf = w.buf[:0]
+	w.chunk++
+	_, err := w.w.Write(w.sealed)
+	return err
+}
+
+// Open returns the plaintext of the spooled file at path
+// Sealed files need key (kept from NewWriter) or the master key; files without the header, such as
+// ones spooled before encryption was turned on, are read as they are.
+func (k *Keys) Open(path string, key []byte) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := k.NewReader(f, key)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+// NewReader decrypts a stream written by a Writer (see Open)
+func (k *Keys) NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, sealedLen+1)
+	header, err := br.Peek(headerLen)
+	if err != nil || !bytes.Equal(header[:len(magic)], []byte(magic)) {
+		return br, nil // plaintext
+	}
+
+	if key == nil {
+		if !k.Durable() {
+			return nil, ErrNoKey
+		}
+		if key, err = hkdf.Key(sha256.New, k.master, header[len(magic):], hkdfInfo, keySize); err != nil {
+			return nil, err
+		}
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	br.Discard(headerLen)
+	return &Reader{r: br, aead: aead, sealed: make([]byte, sealedLen)}, nil
+}
+
+// Reader decrypts chunks as they are read
+type Reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	chunk  uint64
+	sealed []byte
+	plain  []byte
+	done   bool
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next opens the following chunk; it is the last one when nothing comes after it
+func (r *Reader) next() error {
+	n, err := io.ReadFull(r.r, r.sealed)
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		r.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.r.Peek(1); err == io.EOF {
+			r.done = true
+		}
+	}
+
+	plain, err := r.aead.Open(r.sealed[:0], nonce(r.chunk, r.done), r.sealed[:n], nil)
+	if err != nil {
+		return ErrCorrupt
+	}
+	r.chunk++
+	r.plain = plain
+	return nil
+}
+
+// PlaintextSize returns the plaintext size of the spooled file at path and whether it is sealed
+func PlaintextSize(path string) (int64, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, header); err != nil || string(header) != magic {
+		return info.Size(), false, nil
+	}
+	body := info.Size() - int64(headerLen)
+	if body < sealedLen-ChunkSize {
+		return 0, true, ErrCorrupt
+	}
+	// Every chunk carries a tag and the last one is never empty unless the file is
+	chunks := (body + sealedLen - 1) / sealedLen
+	return body - chunks*(sealedLen-ChunkSize), true, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(chunk uint64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[3:11], chunk)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
@@ -0,0 +1,159 @@
+package spoolcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// marker stands in for recognisable image content that must never be written in the clear
+var marker = []byte("\xff\xd8\xff\xe0JFIF-plaintext-photo-marker")
+
+func photo(size int) []byte {
+	data := bytes.Repeat(marker, size/len(marker)+1)
+	return data[:size]
+}
+
+// seal writes data to a file in odd-sized pieces, as a data connection does
+func seal(t *testing.T, keys *Keys, data []byte) (path string, key []byte) {
+	t.Helper()
+	path = filepath.Join(t.TempDir(), "sealed")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+
+	w, key, err := keys.NewWriter(f)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 7777)
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return path, key
+}
+
+func open(t *testing.T, keys *Keys, path string, key []byte) ([]byte, error) {
+	t.Helper()
+	r, err := keys.Open(path, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func masterKey(t *testing.T) []byte {
+	t.Helper()
+	master := make([]byte, keySize)
+	rand.Read(master)
+	return master
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, keys := range map[string]*Keys{
+		"memory": New(nil),
+		"master": New(masterKey(t)),
+	} {
+		for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 7} {
+			data := photo(size)
+			path, key := seal(t, keys, data)
+			if keys.Durable() != (key == nil) {
+				t.Errorf("%s: kept key = %v, want one only without a master key", name, key != nil)
+			}
+
+			plain, sealed, err := PlaintextSize(path)
+			if err != nil || !sealed || plain != int64(size) {
+				t.Errorf("%s/%d: PlaintextSize = %d, %t, %v", name, size, plain, sealed, err)
+			}
+			got, err := open(t, keys, path, key)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%s/%d: round trip = %d bytes, %v", name, size, len(got), err)
+			}
+		}
+	}
+}
+
+func TestNoPlaintextOnDisk(t *testing.T) {
+	data := photo(2*ChunkSize + 100)
+	path, _ := seal(t, New(nil), data)
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if bytes.Contains(raw, marker) || bytes.Contains(raw, data[:32]) {
+		t.Error("Sealed file contains plaintext")
+	}
+}
+
+func TestTamperingIsDetected(t *testing.T) {
+	keys := New(masterKey(t))
+	path, _ := seal(t, keys, photo(2*ChunkSize+100))
+	raw, _ := os.ReadFile(path)
+
+	for name, mutated := range map[string][]byte{
+		"flipped byte":    func() []byte { b := bytes.Clone(raw); b[headerLen+10] ^= 1; return b }(),
+		"last chunk cut":  raw[:len(raw)-10],
+		"last chunk gone": raw[:headerLen+2*sealedLen],
+		"chunks reordered": bytes.Join([][]byte{
+			raw[:headerLen],
+			raw[headerLen+sealedLen : headerLen+2*sealedLen],
+			raw[headerLen : headerLen+sealedLen],
+			raw[headerLen+2*sealedLen:],
+		}, nil),
+	} {
+		os.WriteFile(path, mutated, 0o600)
+		if _, err := open(t, keys, path, nil); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: err = %v, want ErrCorrupt", name, err)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	// A key held in memory is needed to open its file
+	path, _ := seal(t, New(nil), photo(100))
+	if _, err := open(t, New(nil), path, nil); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Open without the file key = %v, want ErrNoKey", err)
+	}
+
+	// Another master key can't open it
+	path, _ = seal(t, New(masterKey(t)), photo(100))
+	if _, err := open(t, New(masterKey(t)), path, nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open with another master key = %v, want ErrCorrupt", err)
+	}
+
+	// Files written before encryption was turned on are read as they are
+	plainPath := filepath.Join(t.TempDir(), "plain")
+	os.WriteFile(plainPath, []byte("plain photo"), 0o600)
+	var none *Keys
+	if got, err := open(t, none, plainPath, nil); err != nil || string(got) != "plain photo" {
+		t.Errorf("Plaintext file = %q, %v", got, err)
+	}
+	if size, sealed, err := PlaintextSize(plainPath); err != nil || sealed || size != 11 {
+		t.Errorf("PlaintextSize(plain) = %d, %t, %v", size, sealed, err)
+	}
+}
+
+func TestParseMasterKey(t *testing.T) {
+	if key, err := ParseMasterKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="); err != nil || len(key) != keySize {
+		t.Errorf("ParseMasterKey = %d bytes, %v", len(key), err)
+	}
+	for _, bad := range []string{"", "not base64!", "c2hvcnQ="} {
+		if _, err := ParseMasterKey(bad); err == nil {
+			t.Errorf("ParseMasterKey(%q) should fail", bad)
+		}
+	}
+}
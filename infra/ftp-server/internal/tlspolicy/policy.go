@@ -0,0 +1,139 @@
+package tlspolicy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// Policy is the TLS requirement of an FTP listener
+type Policy string
+
+const (
+	Clear      Policy = "clear"       // Cleartext control and data allowed
+	TLSControl Policy = "tls-control" // AUTH TLS required before login, data may be clear
+	TLSAll     Policy = "tls-all"     // AUTH TLS required before login and PROT P for transfers
+)
+
+// Parse validates a policy name from config
+func Parse(name string) (Policy, error) {
+	switch p := Policy(name); p {
+	case Clear, TLSControl, TLSAll:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown TLS policy %q (use %s, %s or %s)", name, Clear, TLSControl, TLSAll)
+}
+
+// RequiresControlTLS reports whether USER/PASS must be sent over TLS
+func (p Policy) RequiresControlTLS() bool {
+	return p == TLSControl || p == TLSAll
+}
+
+// RequiresDataTLS reports whether transfers must use PROT P
+func (p Policy) RequiresDataTLS() bool {
+	return p == TLSAll
+}
+
+// ReplyDataTLSRequired refuses data commands before PROT P (RFC 4217 section 9)
+const ReplyDataTLSRequired = 521
+
+// OpensDataConnection reports whether command sets up or uses a data connection for an upload
+// Under TLSAll these are refused with 521 until the client sends PROT P.
+func OpensDataConnection(command string) bool {
+	switch command {
+	case "PASV", "EPSV", "PORT", "EPRT", "STOR", "APPE":
+		return true
+	}
+	return false
+}
+
+// maxGuardedLine bounds the command line buffered while the control connection is cleartext
+const maxGuardedLine = 4096
+
+// refusal is sent for USER/PASS on a cleartext control connection (RFC 4217 policy denial)
+const refusal = "534 Policy requires TLS: send AUTH TLS before USER/PASS\r\n"
+
+// NewListener wraps an explicit FTP listener so cleartext USER/PASS are refused with 534
+// Once AUTH is sent the connection is passed through untouched for the TLS upgrade.
+func NewListener(inner net.Listener) net.Listener {
+	return &guardListener{Listener: inner}
+}
+
+type guardListener struct {
+	net.Listener
+}
+
+func (l *guardListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &guardConn{Conn: conn, reader: bufio.NewReaderSize(conn, maxGuardedLine)}, nil
+}
+
+// guardConn inspects cleartext command lines until the client sends AUTH
+type guardConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	mu       sync.Mutex
+	pending  []byte // inspected bytes not yet handed to the FTP server
+	upgraded bool   // AUTH seen - everything after is passed through
+}
+
+func (c *guardConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.pending) == 0 {
+		if c.upgraded {
+			// Hand over anything still buffered before reading the TLS handshake directly
+			if c.reader.Buffered() > 0 {
+				return c.reader.Read(p)
+			}
+			return c.Conn.Read(p)
+		}
+
+		line, err := c.reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// Oversized line - not a command we care about
+			err = nil
+		}
+		if len(line) > 0 {
+			switch commandOf(line) {
+			case "USER", "PASS":
+				log.Printf("tls_policy_refused client=%s command=%s reply=534", c.RemoteAddr(), commandOf(line))
+				if _, werr := c.Conn.Write([]byte(refusal)); werr != nil {
+					return 0, werr
+				}
+				continue
+			case "AUTH":
+				c.upgraded = true
+			}
+			c.pending = append(c.pending[:0], line...)
+		}
+		if err != nil {
+			if len(c.pending) == 0 {
+				return 0, err
+			}
+			break
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// commandOf returns the upper-cased FTP verb of a command line
+func commandOf(line []byte) string {
+	line = bytes.TrimSpace(line)
+	if i := bytes.IndexByte(line, ' '); i >= 0 {
+		line = line[:i]
+	}
+	return string(bytes.ToUpper(line))
+}
@@ -0,0 +1,164 @@
+package tlsprofile
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Profile names
+const (
+	Modern       = "modern"
+	Compatible   = "compatible"
+	LegacyCamera = "legacy-camera"
+)
+
+// Profile is a named TLS version range and cipher suite list
+// TLS 1.3 suites are not configurable in Go and are always enabled when 1.3 is allowed.
+type Profile struct {
+	Name         string
+	MinVersion   uint16
+	CipherSuites []uint16 // TLS 1.0-1.2 suites in server preference order
+}
+
+// ordered from most to least strict; Suggest walks them in this order
+var profiles = []Profile{
+	{
+		// TLS 1.2+ with forward-secret AEAD suites only
+		Name:       Modern,
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+	},
+	{
+		// TLS 1.2+ adding ChaCha20 and ECDHE CBC suites (older mobile apps, newer camera firmware)
+		Name:       Compatible,
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		},
+	},
+	{
+		// TLS 1.0+ adding RSA key exchange and 3DES for old Canon/Nikon bodies and WFT transmitters
+		// RSA key exchange suites need an RSA certificate
+		Name:       LegacyCamera,
+		MinVersion: tls.VersionTLS10,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+		},
+	},
+}
+
+// Names returns the profile names from most to least strict
+func Names() []string {
+	names := make([]string, len(profiles))
+	for i, p := range profiles {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// Lookup returns the named profile
+func Lookup(name string) (Profile, error) {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("unknown TLS profile %q (use %s)", name, strings.Join(Names(), ", "))
+}
+
+// Apply returns a copy of base restricted to the profile's versions and suites
+func (p Profile) Apply(base *tls.Config) *tls.Config {
+	cfg := base.Clone()
+	cfg.MinVersion = p.MinVersion
+	cfg.CipherSuites = slices.Clone(p.CipherSuites)
+	return cfg
+}
+
+// Accepts reports whether a handshake with this client hello can succeed under the profile
+// Only versions and cipher suites are checked.
+func (p Profile) Accepts(hello *tls.ClientHelloInfo) bool {
+	for _, version := range hello.SupportedVersions {
+		if version < p.MinVersion {
+			continue
+		}
+		if version == tls.VersionTLS13 {
+			return true
+		}
+		for _, id := range hello.CipherSuites {
+			if slices.Contains(p.CipherSuites, id) && suiteSupportsVersion(id, version) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Suggest returns the strictest profile that accepts the client hello ("" if none does)
+func Suggest(hello *tls.ClientHelloInfo) string {
+	for _, p := range profiles {
+		if p.Accepts(hello) {
+			return p.Name
+		}
+	}
+	return ""
+}
+
+// VersionNames formats TLS versions for logging, e.g. "TLS 1.2,TLS 1.0"
+func VersionNames(versions []uint16) string {
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		names[i] = tls.VersionName(v)
+	}
+	return strings.Join(names, ",")
+}
+
+// CipherSuiteNames formats cipher suite IDs for logging
+func CipherSuiteNames(ids []uint16) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = tls.CipherSuiteName(id)
+	}
+	return strings.Join(names, ",")
+}
+
+func suiteSupportsVersion(id, version uint16) bool {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, s := range suites {
+			if s.ID == id {
+				return slices.Contains(s.SupportedVersions, version)
+			}
+		}
+	}
+	return false
+}
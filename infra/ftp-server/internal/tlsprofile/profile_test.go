@@ -0,0 +1,44 @@
+package tlsprofile
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []uint16
+		suites   []uint16
+		want     string
+	}{
+		{"TLS13", []uint16{tls.VersionTLS13, tls.VersionTLS12}, nil, Modern},
+		{"TLS12GCM", []uint16{tls.VersionTLS12}, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, Modern},
+		{"TLS12CBC", []uint16{tls.VersionTLS12}, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}, Compatible},
+		{"TLS12RSAKex", []uint16{tls.VersionTLS12}, []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}, LegacyCamera},
+		{"TLS10CBC", []uint16{tls.VersionTLS10}, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}, LegacyCamera},
+		// GCM suites don't exist before TLS 1.2
+		{"TLS10GCMOnly", []uint16{tls.VersionTLS10}, []uint16{tls.TLS_RSA_WITH_AES_128_GCM_SHA256}, ""},
+		{"RC4Only", []uint16{tls.VersionTLS12}, []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello := &tls.ClientHelloInfo{SupportedVersions: tt.versions, CipherSuites: tt.suites}
+			if got := Suggest(hello); got != tt.want {
+				t.Errorf("Suggest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range Names() {
+		if _, err := Lookup(name); err != nil {
+			t.Errorf("Lookup(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := Lookup("paranoid"); err == nil {
+		t.Error("Expected error for unknown profile")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -12,10 +13,17 @@ import (
 	"sync/atomic"
 	"time"
 
+	ftpserver "github.com/fclairamb/ftpserverlib"
+
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/bandwidth"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/httpauth"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/spool"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/spoolcrypt"
 	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/tracectx"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/uploadbuf"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
@@ -25,6 +33,13 @@ import (
 // ClientManager hub will handle disconnection when this error is reported
 var ErrAuthExpired = errors.New("authentication expired")
 
+// ErrFileTooLarge stops an upload that grows past the session's maximum file size
+// It wraps ftpserver.ErrStorageExceeded, so FTP clients get 552.
+var ErrFileTooLarge = fmt.Errorf("%w: file exceeds the maximum size", ftpserver.ErrStorageExceeded)
+
+// ErrSpooled is the outcome of an upload the shutdown spool kept; the next process sends it
+var ErrSpooled = errors.New("upload interrupted by a server restart, it will be sent once the server is back")
+
 // UploadTransfer implements the afero.File interface for buffering uploads
 // Buffers to disk to determine Content-Length before uploading to R2.
 // LIFETIME CONTRACT: 1:1 with uploadTransaction. Must not be reused after Close().
@@ -38,20 +53,45 @@ type UploadTransfer struct {
 	clientID     uint32 // Client ID for event reporting
 	clientMgr    *clientmgr.Manager
 	apiClient    apiclient.APIClient
-	tempFile     *os.File
+	buffer       *uploadbuf.File
 	tempPath     string
+	keys         *spoolcrypt.Keys // opens a spooled file being replayed (nil = plaintext)
+	maxSize      int64            // largest accepted file in bytes (0 = unlimited)
 	bytesWritten atomic.Int64
+	transferErr  atomic.Pointer[error] // set when the data connection was refused or broke
 	startTime    time.Time
 	traceparent  string
 	baggage      string
 	span         trace.Span
+	untrack      func()          // removes the upload from the client manager's in-flight list
+	flow         *bandwidth.Flow // shapes both legs per session, event and globally (nil = unshaped)
+	release      func()          // frees the upload's concurrency slot
 }
 
 // NewUploadTransfer creates a new upload transfer that buffers to disk
 func NewUploadTransfer(ctx context.Context, eventID, jwtToken, clientIP, filename, contentType string, clientID uint32, clientMgr *clientmgr.Manager, apiClient apiclient.APIClient) (*UploadTransfer, error) {
-	tempFile, err := os.CreateTemp("", "sabaipics-ftp-*")
+	// Once shutdown has begun only uploads already in flight are awaited
+	if clientMgr.Draining() {
+		return nil, clientmgr.ErrDraining
+	}
+	// A policy may have banned the address (e.g. after repeated failures)
+	if err := clientMgr.CheckBan(clientIP); err != nil {
+		return nil, err
+	}
+
+	// Refused before any data is accepted when the buffer's disk is nearly full
+	buffer, err := clientMgr.UploadBuffer().Create()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file: %w", err)
+		if errors.Is(err, uploadbuf.ErrInsufficientSpace) {
+			observability.RecordUploadBufferRejection("low_space")
+			observability.EmitLog(ctx, "warn", "upload_rejected_low_space", map[string]any{
+				"file":      filename,
+				"event_id":  eventID,
+				"client_ip": clientIP,
+				"dir":       clientMgr.UploadBuffer().Path(),
+			})
+		}
+		return nil, err
 	}
 
 	if ctx == nil {
@@ -75,14 +115,19 @@ func NewUploadTransfer(ctx context.Context, eventID, jwtToken, clientIP, filenam
 		clientID:    clientID,
 		clientMgr:   clientMgr,
 		apiClient:   apiClient,
-		tempFile:    tempFile,
-		tempPath:    tempFile.Name(),
+		buffer:      buffer,
+		tempPath:    buffer.Name(),
+		maxSize:     clientMgr.MaxFileSize(clientID),
 		startTime:   time.Now(),
 		traceparent: traceparent,
 		baggage:     baggage,
 		span:        uploadSpan,
+		release:     func() {},
 	}
 
+	transfer.untrack = clientMgr.TrackUpload(clientID, transfer)
+	transfer.flow = clientMgr.Bandwidth().Open(clientID, eventID)
+
 	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
 	fileType := ""
 	if ext != "" {
@@ -99,19 +144,68 @@ func NewUploadTransfer(ctx context.Context, eventID, jwtToken, clientIP, filenam
 	return transfer, nil
 }
 
+// ReleaseOnClose runs release once the upload is closed (e.g. to free a limiter slot)
+func (t *UploadTransfer) ReleaseOnClose(release func()) {
+	t.release = release
+}
+
 // Write implements io.Writer - receives data from FTP client
 func (t *UploadTransfer) Write(p []byte) (int, error) {
-	n, err := t.tempFile.Write(p)
+	if t.maxSize > 0 && t.bytesWritten.Load()+int64(len(p)) > t.maxSize {
+		observability.RecordUploadBufferRejection("too_large")
+		return 0, ErrFileTooLarge
+	}
+
+	n, err := t.buffer.Write(p)
 	t.bytesWritten.Add(int64(n))
-	return n, err
+	if err != nil {
+		return n, err
+	}
+
+	// Pacing the writes slows the data connection down to the bandwidth limits
+	if err := t.flow.Wait(t.ctx, bandwidth.Inbound, n); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// TransferError is called when the data connection fails or is refused (implements ftpserver.FileTransferError)
+// The partial file is discarded on Close instead of being uploaded
+func (t *UploadTransfer) TransferError(err error) {
+	t.transferErr.Store(&err)
 }
 
 // Close uploads the buffered file to R2
 func (t *UploadTransfer) Close() error {
-	if err := t.tempFile.Close(); err != nil {
+	defer t.untrack()
+	defer t.release()
+	defer t.flow.Close()
+
+	if errPtr := t.transferErr.Load(); errPtr != nil {
+		t.buffer.Close()
+		t.buffer.Remove()
+		t.span.SetStatus(codes.Error, "transfer_failed")
+		t.span.RecordError(*errPtr)
+		t.span.End()
+		t.clientMgr.RecordUpload(t.clientID, 0, time.Since(t.startTime), *errPtr)
+		t.finish(t.bytesWritten.Load(), *errPtr)
+		observability.RecordUpload("error", t.bytesWritten.Load(), time.Since(t.startTime))
+		observability.EmitLog(t.ctx, "error", "upload_aborted", map[string]any{
+			"file":     t.filename,
+			"event_id": t.eventID,
+			"bytes":    t.bytesWritten.Load(),
+			"error":    (*errPtr).Error(),
+		})
+		return nil
+	}
+
+	if err := t.buffer.Close(); err != nil {
+		t.buffer.Remove()
 		t.span.SetStatus(codes.Error, "temp_file_close_failed")
 		t.span.RecordError(err)
 		t.span.End()
+		t.clientMgr.RecordUpload(t.clientID, 0, time.Since(t.startTime), err)
+		t.finish(t.bytesWritten.Load(), err)
 		observability.RecordUpload("error", t.bytesWritten.Load(), time.Since(t.startTime))
 		observability.EmitLog(t.ctx, "error", "upload_temp_close_error", map[string]any{
 			"file":  t.filename,
@@ -120,16 +214,34 @@ func (t *UploadTransfer) Close() error {
 		return err
 	}
 
-	defer os.Remove(t.tempPath)
+	defer t.buffer.Remove()
 
 	fileSize := t.bytesWritten.Load()
 	if fileSize < 0 {
 		fileSize = 0
 	}
 
-	uploadErr := t.uploadBufferedFile(fileSize)
+	// A crash from here on leaves a complete file the next start can recover
+	if err := t.buffer.Complete(t.spoolEntry(fileSize)); err != nil {
+		observability.EmitLog(t.ctx, "warn", "upload_buffer_entry_failed", map[string]any{
+			"file":  t.filename,
+			"error": err.Error(),
+		})
+	}
+
+	cause := t.uploadBufferedFile(fileSize)
+	if cause != nil && t.spoolOnShutdown(fileSize, cause) {
+		return nil
+	}
+	var uploadErr error
+	if cause != nil {
+		uploadErr = fmt.Errorf("upload failed: %s", sanitizeUploadError(cause))
+	}
 
 	duration := time.Since(t.startTime)
+	t.clientMgr.RecordUpload(t.clientID, fileSize, duration, uploadErr)
+	t.reportOutcome(cause)
+	t.finish(fileSize, uploadErr)
 	bytesTotal := t.bytesWritten.Load()
 	throughputMBps := float64(bytesTotal) / duration.Seconds() / 1024 / 1024
 
@@ -146,6 +258,7 @@ func (t *UploadTransfer) Close() error {
 		observability.EmitLog(t.ctx, "error", "upload_completed", map[string]any{
 			"status":          "error",
 			"file":            t.filename,
+			"event_id":        t.eventID,
 			"bytes":           bytesTotal,
 			"duration_ms":     duration.Milliseconds(),
 			"throughput_mbps": throughputMBps,
@@ -163,6 +276,7 @@ func (t *UploadTransfer) Close() error {
 		observability.EmitLog(t.ctx, "info", "upload_completed", map[string]any{
 			"status":          "ok",
 			"file":            t.filename,
+			"event_id":        t.eventID,
 			"bytes":           bytesTotal,
 			"duration_ms":     duration.Milliseconds(),
 			"throughput_mbps": throughputMBps,
@@ -172,37 +286,164 @@ func (t *UploadTransfer) Close() error {
 	return uploadErr
 }
 
+// spoolOnShutdown hands a complete file whose upload was cut off by shutdown to the spool
+// Returns false when there is no spool, the failure had another cause (e.g. R2 or the API
+// refused it, which a replay would only repeat), or saving failed.
+func (t *UploadTransfer) spoolOnShutdown(fileSize int64, cause error) bool {
+	s := t.clientMgr.Spool()
+	if s == nil || !t.clientMgr.Stopped() || !errors.Is(cause, context.Canceled) {
+		return false
+	}
+
+	duration := time.Since(t.startTime)
+	if err := s.Save(t.tempPath, t.spoolEntry(fileSize)); err != nil {
+		observability.EmitLog(t.ctx, "error", "upload_spool_failed", map[string]any{
+			"file":  t.filename,
+			"error": err.Error(),
+		})
+		return false
+	}
+
+	t.span.SetStatus(codes.Ok, "spooled")
+	t.span.End()
+	t.finish(fileSize, ErrSpooled)
+	observability.RecordUpload("spooled", fileSize, duration)
+	observability.EmitLog(t.ctx, "info", "upload_spooled", map[string]any{
+		"file":  t.filename,
+		"bytes": fileSize,
+		"dir":   s.Dir(),
+	})
+	return true
+}
+
+// spoolEntry describes the buffered file for the shutdown spool and crash recovery
+// The session's token stays in memory; the replay signs a new one for the photographer and event.
+func (t *UploadTransfer) spoolEntry(fileSize int64) spool.Entry {
+	entry := spool.Entry{
+		Filename:    t.filename,
+		ContentType: t.contentType,
+		Size:        fileSize,
+		EventID:     t.eventID,
+		ClientIP:    t.clientIP,
+	}
+	if claims, err := httpauth.PeekClaims(t.jwtToken); err == nil {
+		entry.PhotographerID = claims.PhotographerID
+	}
+	return entry
+}
+
+// UploadSpooled sends a file kept by the shutdown spool (replayed on the next start) with token
+// Sealed files are opened with keys' master key. The outcome goes to clientMgr's upload
+// observer like any other upload; the replay itself doesn't queue for an R2 slot.
+func UploadSpooled(ctx context.Context, apiClient apiclient.APIClient, clientMgr *clientmgr.Manager, keys *spoolcrypt.Keys, entry spool.Entry, token, dataPath string) error {
+	t := &UploadTransfer{
+		ctx:         ctx,
+		eventID:     entry.EventID,
+		jwtToken:    token,
+		clientIP:    entry.ClientIP,
+		filename:    entry.Filename,
+		contentType: entry.ContentType,
+		apiClient:   apiClient,
+		tempPath:    dataPath,
+		keys:        keys,
+		startTime:   time.Now(),
+	}
+	err := t.presignAndUpload(ctx, entry.Size)
+
+	result := clientmgr.UploadResult{
+		EventID:  entry.EventID,
+		Filename: entry.Filename,
+		Bytes:    entry.Size,
+		Duration: time.Since(t.startTime),
+	}
+	if err != nil {
+		result.Err = fmt.Errorf("upload failed: %s", sanitizeUploadError(err))
+	}
+	clientMgr.FinishUpload(result)
+	return err
+}
+
+// uploadBufferedFile sends the file to R2 and returns the unsanitized error
 func (t *UploadTransfer) uploadBufferedFile(fileSize int64) error {
 	ctx := t.ctx
 
 	if err := t.presignAndUpload(ctx, fileSize); err != nil {
-		safeErr := sanitizeUploadError(err)
 		observability.EmitLog(t.ctx, "error", "upload_r2_failed", map[string]any{
 			"file":  t.filename,
-			"error": safeErr,
+			"error": sanitizeUploadError(err),
 		})
+		return err
+	}
 
-		if errors.Is(err, apiclient.ErrUnauthorized) {
-			t.clientMgr.SendEvent(clientmgr.ClientEvent{
-				Type:     clientmgr.EventAuthExpired,
-				ClientID: t.clientID,
-				Reason:   "authentication expired",
-			})
-		}
+	observability.EmitLog(t.ctx, "info", "upload_r2_ok", map[string]any{
+		"file": t.filename,
+	})
+	return nil
+}
 
+// reportOutcome sends the upload's events to the client manager
+// Called after RecordUpload so policies see the session totals including this upload.
+func (t *UploadTransfer) reportOutcome(err error) {
+	if err == nil {
 		t.clientMgr.SendEvent(clientmgr.ClientEvent{
-			Type:     clientmgr.EventUploadFailed,
+			Type:     clientmgr.EventUploadSucceeded,
 			ClientID: t.clientID,
-			Reason:   safeErr,
+			Reason:   t.filename,
 		})
+		return
+	}
 
-		return fmt.Errorf("upload failed: %s", safeErr)
+	switch {
+	case errors.Is(err, apiclient.ErrUnauthorized):
+		t.clientMgr.SendEvent(clientmgr.ClientEvent{
+			Type:     clientmgr.EventAuthExpired,
+			ClientID: t.clientID,
+			Reason:   "authentication expired",
+		})
+	case errors.Is(err, apiclient.ErrEventExpired):
+		t.clientMgr.SendEvent(clientmgr.ClientEvent{
+			Type:     clientmgr.EventEventExpired,
+			ClientID: t.clientID,
+			Reason:   "event expired",
+		})
+	case errors.Is(err, apiclient.ErrInsufficientCredits):
+		t.clientMgr.SendEvent(clientmgr.ClientEvent{
+			Type:     clientmgr.EventCreditsExhausted,
+			ClientID: t.clientID,
+			Reason:   "insufficient credits",
+		})
 	}
 
-	observability.EmitLog(t.ctx, "info", "upload_r2_ok", map[string]any{
-		"file": t.filename,
+	t.clientMgr.SendEvent(clientmgr.ClientEvent{
+		Type:     clientmgr.EventUploadFailed,
+		ClientID: t.clientID,
+		Reason:   sanitizeUploadError(err),
+	})
+}
+
+// waitForSlot queues the upload for an R2 slot by file category (no queue for spool replays)
+func (t *UploadTransfer) waitForSlot(ctx context.Context) (release func(), err error) {
+	if t.clientMgr == nil || t.clientMgr.Scheduler() == nil {
+		return func() {}, nil
+	}
+	category := categorizeFileType(strings.TrimPrefix(strings.ToLower(filepath.Ext(t.filename)), "."))
+	release, err = t.clientMgr.Scheduler().Acquire(ctx, category, t.eventID)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for an upload slot: %w", err)
+	}
+	return release, nil
+}
+
+// finish passes the upload's outcome to the client manager's upload observer
+func (t *UploadTransfer) finish(bytes int64, err error) {
+	t.clientMgr.FinishUpload(clientmgr.UploadResult{
+		ClientID: t.clientID,
+		EventID:  t.eventID,
+		Filename: t.filename,
+		Bytes:    bytes,
+		Duration: time.Since(t.startTime),
+		Err:      err,
 	})
-	return nil
 }
 
 func (t *UploadTransfer) presignAndUpload(ctx context.Context, fileSize int64) error {
@@ -211,6 +452,13 @@ func (t *UploadTransfer) presignAndUpload(ctx context.Context, fileSize int64) e
 		contentLength = 0
 	}
 
+	// Wait for an R2 slot before presigning so the URL doesn't age in the queue
+	release, err := t.waitForSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	presignSpanCtx, presignSpan := observability.StartSpan(ctx, "ftp.presign")
 	presignCtx, cancel := context.WithTimeout(presignSpanCtx, 15*time.Second)
 	presignResp, err := t.apiClient.PresignWithRetry(
@@ -235,7 +483,8 @@ func (t *UploadTransfer) presignAndUpload(ctx context.Context, fileSize int64) e
 		return fmt.Errorf("presign response missing put_url")
 	}
 
-	file, err := os.Open(t.tempPath)
+	// Sealed files are decrypted as the PUT reads them; Content-Length is the plaintext size
+	file, err := t.openBuffer()
 	if err != nil {
 		return fmt.Errorf("failed to open temp file: %w", err)
 	}
@@ -260,7 +509,7 @@ func (t *UploadTransfer) presignAndUpload(ctx context.Context, fileSize int64) e
 		uploadCtx,
 		presignResp.PutURL,
 		requiredHeaders,
-		file,
+		t.flow.Reader(uploadCtx, file),
 	)
 	uploadCancel()
 	if err != nil {
@@ -286,6 +535,14 @@ func (t *UploadTransfer) presignAndUpload(ctx context.Context, fileSize int64) e
 	return nil
 }
 
+// openBuffer returns the plaintext of the buffered or spooled file
+func (t *UploadTransfer) openBuffer() (io.ReadCloser, error) {
+	if t.buffer != nil {
+		return t.buffer.Open()
+	}
+	return t.keys.Open(t.tempPath, nil)
+}
+
 func sanitizeUploadError(err error) string {
 	if err == nil {
 		return ""
@@ -327,6 +584,16 @@ func (t *UploadTransfer) Name() string {
 	return t.filename
 }
 
+// BytesReceived returns the bytes buffered so far (implements clientmgr.UploadProgress)
+func (t *UploadTransfer) BytesReceived() int64 {
+	return t.bytesWritten.Load()
+}
+
+// StartedAt returns when the transfer was opened (implements clientmgr.UploadProgress)
+func (t *UploadTransfer) StartedAt() time.Time {
+	return t.startTime
+}
+
 // Readdir is not supported
 func (t *UploadTransfer) Readdir(count int) ([]os.FileInfo, error) {
 	return nil, errors.New("readdir not supported")
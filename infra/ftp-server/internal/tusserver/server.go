@@ -0,0 +1,560 @@
+package tusserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/handoff"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/httpauth"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/mime"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/transfer"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/uploadbuf"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,termination,expiration"
+
+	// offsetContentType is the required Content-Type of PATCH bodies
+	offsetContentType = "application/offset+octet-stream"
+
+	// basePath is where uploads are created; each upload lives at basePath + id
+	basePath = "/files/"
+)
+
+// uploadExpiry is how long an unfinished upload is kept without activity
+const uploadExpiry = 24 * time.Hour
+
+// completedRetention is how long a finished upload still answers HEAD with its final offset,
+// for clients whose last PATCH response was lost
+const completedRetention = 10 * time.Minute
+
+// sweepInterval is how often expired uploads are discarded
+const sweepInterval = time.Minute
+
+// Server is the tus 1.0 resumable upload frontend
+// Completed uploads go through transfer.UploadTransfer, the same presign + R2 PUT path as FTP.
+type Server struct {
+	config    *config.Config
+	clientMgr *clientmgr.Manager
+	apiClient apiclient.APIClient
+	auth      *httpauth.Authenticator
+	tlsConfig *tls.Config // nil serves plain HTTP (e.g. behind a TLS-terminating proxy)
+
+	httpServer *http.Server
+	stop       chan struct{}
+	stopOnce   sync.Once
+
+	// socket is the TCP listener under TLS, passed on by graceful restarts
+	socketMu sync.Mutex
+	socket   net.Listener
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+// New creates the tus server
+func New(cfg *config.Config, clientMgr *clientmgr.Manager, apiClient apiclient.APIClient, tlsConfig *tls.Config) *Server {
+	s := &Server{
+		config:    cfg,
+		clientMgr: clientMgr,
+		apiClient: apiClient,
+		auth:      httpauth.New(cfg, apiClient),
+		tlsConfig: tlsConfig,
+		stop:      make(chan struct{}),
+		uploads:   make(map[string]*upload),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("OPTIONS "+basePath, s.handleOptions)
+	mux.HandleFunc("POST "+basePath+"{$}", s.handleCreate)
+	mux.HandleFunc("HEAD "+basePath+"{id}", s.handleHead)
+	mux.HandleFunc("PATCH "+basePath+"{id}", s.handlePatch)
+	mux.HandleFunc("DELETE "+basePath+"{id}", s.handleDelete)
+
+	s.httpServer = &http.Server{
+		Handler:           withTusHeaders(mux),
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	return s
+}
+
+// Handler returns the HTTP handler (for tests)
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
+// ListenAndServe serves tus requests until Stop is called
+func (s *Server) ListenAndServe() error {
+	listener, err := handoff.Listen("tus", s.config.TUSListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.TUSListenAddress, err)
+	}
+	s.socketMu.Lock()
+	s.socket = listener
+	s.socketMu.Unlock()
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	log.Printf("tus_listening addr=%s tls=%t", listener.Addr(), s.tlsConfig != nil)
+
+	go s.sweepExpired()
+
+	if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ListenerFile returns a duplicate of the listening socket for a graceful restart
+func (s *Server) ListenerFile() (string, *os.File, error) {
+	s.socketMu.Lock()
+	socket := s.socket
+	s.socketMu.Unlock()
+	if socket == nil {
+		return "tus", nil, errors.New("listener not started")
+	}
+	file, err := handoff.File(socket)
+	return "tus", file, err
+}
+
+// Stop closes the listener, lets PATCH requests in progress finish until ctx is done, then
+// discards unfinished uploads
+// Their temp files would not survive a restart, so clients start over against the next process.
+func (s *Server) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.httpServer.Close()
+	}
+
+	s.mu.Lock()
+	pending := make([]*upload, 0, len(s.uploads))
+	for _, u := range s.uploads {
+		pending = append(pending, u)
+	}
+	s.mu.Unlock()
+
+	for _, u := range pending {
+		u.mu.Lock()
+		s.abort(u, errServerStopping)
+		u.mu.Unlock()
+	}
+	return err
+}
+
+// withTusHeaders adds Tus-Resumable to every response and checks it on every request but OPTIONS
+func withTusHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Clients behind proxies that only allow GET/POST tunnel the method
+		if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+			r.Method = override
+		}
+
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(s.config.TUSMaxSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCreate starts an upload (creation extension), optionally with the first chunk
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	auth, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Upload-Defer-Length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > int64(s.config.TUSMaxSize) {
+		http.Error(w, "upload exceeds Tus-Max-Size", http.StatusRequestEntityTooLarge)
+		return
+	}
+	// Each tus upload is its own session, so the default MAX_FILE_SIZE applies
+	if maxSize := s.clientMgr.MaxFileSize(0); maxSize > 0 && length > maxSize {
+		http.Error(w, "upload exceeds the maximum file size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	meta := parseMetadata(r.Header.Get("Upload-Metadata"))
+	filename := meta["filename"]
+	if filename == "" {
+		filename = meta["name"]
+	}
+	filename = path.Base("/" + filename)
+	if filename == "/" {
+		http.Error(w, "Upload-Metadata must include filename", http.StatusBadRequest)
+		return
+	}
+
+	contentType, err := mime.FromFilename(filename)
+	if err != nil {
+		log.Printf("tus_upload_rejected client=%s file=%s error=%v", r.RemoteAddr, filename, err)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	u, err := s.createUpload(auth, r.RemoteAddr, filename, contentType, length)
+	if err != nil {
+		log.Printf("tus_upload_create_failed client=%s file=%s error=%v", r.RemoteAddr, filename, err)
+		if errors.Is(err, uploadbuf.ErrInsufficientSpace) {
+			http.Error(w, "server is low on disk space, try again shortly", http.StatusInsufficientStorage)
+			return
+		}
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", basePath+u.id)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// creation-with-upload: the request body is the first chunk (empty files complete right away)
+	if r.Header.Get("Content-Type") == offsetContentType || length == 0 {
+		if status, msg := s.writeChunk(r, u); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	if !u.done {
+		w.Header().Set("Upload-Expires", u.expiresAt().UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleHead reports how much of the upload the server has
+func (s *Server) handleHead(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	// A PATCH from a dropped connection may still be draining; the client retries on 423
+	if !u.mu.TryLock() {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer u.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.length, 10))
+	if !u.done {
+		w.Header().Set("Upload-Expires", u.expiresAt().UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePatch appends a chunk; the last chunk pushes the file through the upload pipeline
+func (s *Server) handlePatch(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != offsetContentType {
+		http.Error(w, "Content-Type must be "+offsetContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	// One writer at a time; a client retrying while its previous PATCH is still draining gets 423
+	if !u.mu.TryLock() {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer u.mu.Unlock()
+
+	if u.done {
+		http.NotFound(w, r)
+		return
+	}
+	if offset != u.offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+
+	if status, msg := s.writeChunk(r, u); status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	if !u.done {
+		w.Header().Set("Upload-Expires", u.expiresAt().UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDelete terminates an unfinished upload (termination extension)
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if !u.mu.TryLock() {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer u.mu.Unlock()
+
+	if u.done {
+		http.NotFound(w, r)
+		return
+	}
+	s.abort(u, errUploadTerminated)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticate writes 401 and returns false when the request has no valid credentials
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*apiclient.AuthResponse, bool) {
+	auth, err := s.auth.Authenticate(r, "tus")
+	if err != nil {
+		httpauth.Challenge(w)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	return auth, true
+}
+
+// lookup authenticates the request and finds its upload
+// Uploads of other events are reported as missing so IDs can't be probed.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (*upload, bool) {
+	auth, ok := s.authenticate(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	u, exists := s.uploads[r.PathValue("id")]
+	s.mu.Unlock()
+
+	if !exists || u.eventID != auth.EventID {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return u, true
+}
+
+// createUpload registers the upload as a client session and opens its transfer
+func (s *Server) createUpload(auth *apiclient.AuthResponse, clientIP, filename, contentType string, length int64) (*upload, error) {
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	u := &upload{
+		id:         id,
+		eventID:    auth.EventID,
+		filename:   filename,
+		length:     length,
+		clientIP:   clientIP,
+		server:     s,
+		lastActive: time.Now(),
+	}
+	u.clientID = s.clientMgr.RegisterClient(u)
+	s.clientMgr.SetLogin(u.clientID, "tus", auth.EventID, auth.Token)
+
+	uploadCtx := context.Background()
+	if ctx, ok := s.clientMgr.GetUploadContext(u.clientID); ok {
+		uploadCtx = ctx
+	}
+
+	u.transfer, err = transfer.NewUploadTransfer(
+		uploadCtx,
+		auth.EventID,
+		auth.Token,
+		clientIP,
+		filename,
+		contentType,
+		u.clientID,
+		s.clientMgr,
+		s.apiClient,
+	)
+	if err != nil {
+		s.clientMgr.UnregisterClient(u.clientID)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.uploads[id] = u
+	s.mu.Unlock()
+
+	// Each upload is its own client session, so it connects here and disconnects in release
+	observability.EmitLog(context.Background(), "info", "client_connected", map[string]any{
+		"client_ip":  clientIP,
+		"session_id": u.clientID,
+		"protocol":   "tus",
+		"event_id":   auth.EventID,
+	})
+
+	log.Printf("tus_upload_created id=%s client=%s event=%s file=%s length=%d", id, clientIP, auth.EventID, filename, length)
+	return u, nil
+}
+
+// writeChunk copies the request body into the upload and finishes it once complete
+// Must be called with u.mu held. Returns a non-zero status when the request failed.
+func (s *Server) writeChunk(r *http.Request, u *upload) (int, string) {
+	body := &chunkReader{r: io.LimitReader(r.Body, u.length-u.offset)}
+	n, err := io.Copy(u.transfer, body)
+	u.offset += n
+	u.lastActive = time.Now()
+
+	if u.closed.Load() {
+		s.abort(u, errUploadClosed)
+		return http.StatusGone, "upload session closed"
+	}
+	if err != nil && body.err != nil {
+		// Bytes received so far are kept; the client resumes from the offset HEAD reports
+		log.Printf("tus_chunk_interrupted id=%s offset=%d error=%v", u.id, u.offset, err)
+		return http.StatusBadRequest, "upload interrupted"
+	}
+	if err != nil {
+		// The buffer can't be trusted after a failed write, so the client starts over
+		log.Printf("tus_chunk_write_failed id=%s offset=%d error=%v", u.id, u.offset, err)
+		s.abort(u, err)
+		return http.StatusInternalServerError, "failed to store upload"
+	}
+
+	if u.offset < u.length {
+		return 0, ""
+	}
+
+	if err := s.finish(u); err != nil {
+		return http.StatusBadGateway, err.Error()
+	}
+	return 0, ""
+}
+
+// finish pushes the completed upload through presign + R2 PUT; must be called with u.mu held
+func (s *Server) finish(u *upload) error {
+	err := u.transfer.Close()
+	reason := "failed"
+	if err == nil {
+		u.completedAt = time.Now()
+		reason = "completed"
+	}
+	s.release(u, reason)
+
+	if err != nil {
+		log.Printf("tus_upload_failed id=%s file=%s error=%v", u.id, u.filename, err)
+	} else {
+		log.Printf("tus_upload_finished id=%s file=%s bytes=%d", u.id, u.filename, u.offset)
+	}
+	return err
+}
+
+// abort discards an unfinished upload; must be called with u.mu held
+func (s *Server) abort(u *upload, reason error) {
+	if u.done {
+		return
+	}
+	u.transfer.TransferError(reason)
+	u.transfer.Close()
+	s.release(u, reason.Error())
+
+	log.Printf("tus_upload_aborted id=%s file=%s offset=%d reason=%v", u.id, u.filename, u.offset, reason)
+}
+
+// release marks the upload done and removes it from the server and the client manager
+// Completed uploads stay answerable until the sweep drops them after completedRetention.
+// Called after the transfer is closed: unregistering cancels the session's upload context.
+func (s *Server) release(u *upload, reason string) {
+	u.done = true
+
+	if u.completedAt.IsZero() {
+		s.mu.Lock()
+		delete(s.uploads, u.id)
+		s.mu.Unlock()
+	}
+
+	s.clientMgr.UnregisterClient(u.clientID)
+	observability.EmitLog(context.Background(), "info", "client_disconnected", map[string]any{
+		"client_ip":  u.clientIP,
+		"session_id": u.clientID,
+		"protocol":   "tus",
+		"event_id":   u.eventID,
+		"reason":     reason,
+	})
+}
+
+// sweepExpired discards uploads that have been idle longer than uploadExpiry, and completed
+// uploads older than completedRetention
+func (s *Server) sweepExpired() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			uploads := make([]*upload, 0, len(s.uploads))
+			for _, u := range s.uploads {
+				uploads = append(uploads, u)
+			}
+			s.mu.Unlock()
+
+			for _, u := range uploads {
+				// Skip uploads with a PATCH in progress - they are active by definition
+				if !u.mu.TryLock() {
+					continue
+				}
+				switch {
+				case !u.completedAt.IsZero() && now.After(u.completedAt.Add(completedRetention)):
+					s.mu.Lock()
+					delete(s.uploads, u.id)
+					s.mu.Unlock()
+				case !u.done && now.After(u.expiresAt()):
+					s.abort(u, errUploadExpired)
+				}
+				u.mu.Unlock()
+			}
+		}
+	}
+}
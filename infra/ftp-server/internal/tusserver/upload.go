@@ -0,0 +1,113 @@
+package tusserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/transfer"
+)
+
+var (
+	errUploadTerminated = errors.New("upload terminated by client")
+	errUploadExpired    = errors.New("upload expired before completion")
+	errUploadClosed     = errors.New("upload session closed")
+	errServerStopping   = errors.New("server shutting down")
+)
+
+// upload is one resumable upload, buffered in an UploadTransfer until all bytes arrive
+// It is also the client manager session for the upload, so an auth-expired event aborts it.
+type upload struct {
+	id       string
+	eventID  string
+	filename string
+	length   int64
+	clientIP string
+	clientID uint32
+	server   *Server
+
+	mu         sync.Mutex // held while a PATCH is writing
+	transfer   *transfer.UploadTransfer
+	offset     int64
+	lastActive time.Time
+	done       bool // finished or aborted - the transfer is closed
+
+	completedAt time.Time // set once the file reached R2; HEAD keeps answering until the sweep
+
+	closed atomic.Bool // the client manager asked to disconnect the session
+}
+
+// RemoteAddr implements clientmgr.Session
+func (u *upload) RemoteAddr() net.Addr {
+	return httpAddr(u.clientIP)
+}
+
+// Close implements clientmgr.Session
+// A PATCH in progress sees the flag when its write finishes and aborts the upload itself.
+func (u *upload) Close() error {
+	u.closed.Store(true)
+	if u.mu.TryLock() {
+		defer u.mu.Unlock()
+		u.server.abort(u, errUploadClosed)
+	}
+	return nil
+}
+
+// expiresAt is when an idle upload is discarded
+func (u *upload) expiresAt() time.Time {
+	return u.lastActive.Add(uploadExpiry)
+}
+
+// chunkReader remembers a failed read of a PATCH body, telling a client that went away
+// apart from a failed write to the upload buffer
+type chunkReader struct {
+	r   io.Reader
+	err error
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	return n, err
+}
+
+// httpAddr is the remote address of an HTTP client as reported by net/http
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+// newUploadID returns a random URL-safe upload ID
+func newUploadID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// parseMetadata decodes an Upload-Metadata header ("key base64value,key2 base64value2")
+func parseMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		meta[key] = string(decoded)
+	}
+	return meta
+}
@@ -0,0 +1,274 @@
+package uploadbuf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/spool"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/spoolcrypt"
+)
+
+// Prefix names every buffered upload; the startup sweep only touches files starting with it
+const Prefix = "sabaipics-ftp-"
+
+// entrySuffix marks the entry written next to a complete file (see File.Complete)
+const entrySuffix = ".json"
+
+// ErrInsufficientSpace refuses an upload when the buffer's filesystem is below the free space floor
+// FTP clients get 452 (insufficient storage space, a transient error they retry).
+var ErrInsufficientSpace error = spaceError{}
+
+type spaceError struct{}
+
+func (spaceError) Error() string {
+	return "upload buffer is low on disk space, try again shortly"
+}
+
+// ReplyCode is the FTP reply for the refusal (implements ftpserver.ReplyCoder)
+func (spaceError) ReplyCode() int {
+	return 452
+}
+
+// Dir is the directory uploads are buffered in before they are sent to R2
+// A nil Dir buffers in the system temp directory without a free space check or encryption.
+type Dir struct {
+	path    string
+	minFree int64
+	keys    *spoolcrypt.Keys
+}
+
+// New opens (and creates) the buffer directory; an empty path uses the system temp directory
+// Uploads are refused while the filesystem has less than minFree bytes available (0 = no check).
+// With keys, files are sealed as they are written (nil = plaintext).
+func New(path string, minFree int64, keys *spoolcrypt.Keys) (*Dir, error) {
+	if minFree < 0 {
+		return nil, fmt.Errorf("SPOOL_MIN_FREE_BYTES must be 0 (no check) or positive, got %d", minFree)
+	}
+	if path == "" {
+		path = os.TempDir()
+	}
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create upload buffer directory %s: %w", path, err)
+	}
+	return &Dir{path: path, minFree: minFree, keys: keys}, nil
+}
+
+// Path returns the buffer directory
+func (d *Dir) Path() string {
+	if d == nil {
+		return os.TempDir()
+	}
+	return d.path
+}
+
+// MinFree returns the free space floor in bytes (0 = no check)
+func (d *Dir) MinFree() int64 {
+	if d == nil {
+		return 0
+	}
+	return d.minFree
+}
+
+// Keys returns the keys files are sealed with (nil = plaintext)
+func (d *Dir) Keys() *spoolcrypt.Keys {
+	if d == nil {
+		return nil
+	}
+	return d.keys
+}
+
+// Free returns the bytes available to the process and the size of the directory's filesystem
+// Only supported on Unix; elsewhere it fails and the free space floor is not enforced.
+func (d *Dir) Free() (free, total int64, err error) {
+	return statfs(d.Path())
+}
+
+// Buffered returns the bytes held by buffered uploads, including orphans not yet swept
+func (d *Dir) Buffered() int64 {
+	paths, _ := filepath.Glob(filepath.Join(d.Path(), Prefix+"*"))
+	var n int64
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			n += info.Size()
+		}
+	}
+	return n
+}
+
+// Create opens a new buffer file, refusing with ErrInsufficientSpace below the free space floor
+// The file is locked until Remove, so a sweep by another process (e.g. after a handoff) leaves it alone.
+func (d *Dir) Create() (*File, error) {
+	if d.MinFree() > 0 {
+		// A failed statfs doesn't block uploads; creating the file reports a real problem
+		if free, _, err := d.Free(); err == nil && free < d.minFree {
+			return nil, ErrInsufficientSpace
+		}
+	}
+
+	f, err := os.CreateTemp(d.Path(), Prefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	lock, err := lockFile(f.Name())
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to lock temp file: %w", err)
+	}
+
+	buffered := &File{file: f, lock: lock, keys: d.Keys()}
+	if buffered.keys != nil {
+		buffered.sealer, buffered.key, err = buffered.keys.NewWriter(f)
+		if err != nil {
+			buffered.Close()
+			buffered.Remove()
+			return nil, fmt.Errorf("failed to seal temp file: %w", err)
+		}
+	}
+	return buffered, nil
+}
+
+// File is one buffered upload, sealed on the way to disk when the Dir has keys
+// Close only closes the write handle; the file stays locked and on disk until Remove.
+type File struct {
+	file   *os.File
+	lock   *os.File
+	keys   *spoolcrypt.Keys
+	sealer *spoolcrypt.Writer // nil = plaintext
+	key    []byte             // the file's key when it only lives in memory
+}
+
+// Name returns the file's path
+func (f *File) Name() string {
+	return f.file.Name()
+}
+
+// Write appends p to the file
+func (f *File) Write(p []byte) (int, error) {
+	if f.sealer != nil {
+		return f.sealer.Write(p)
+	}
+	return f.file.Write(p)
+}
+
+// Close seals the last chunk and closes the write handle
+func (f *File) Close() error {
+	if f.sealer != nil {
+		if err := f.sealer.Close(); err != nil {
+			f.file.Close()
+			return err
+		}
+	}
+	return f.file.Close()
+}
+
+// Open returns the plaintext of the closed file, decrypted as it is read
+func (f *File) Open() (io.ReadCloser, error) {
+	return f.keys.Open(f.Name(), f.key)
+}
+
+// Complete records entry next to the closed file, so a crash before it reaches R2 can be recovered
+func (f *File) Complete(entry spool.Entry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Write then rename so the sweep never reads a partial entry
+	entryPath := f.Name() + entrySuffix
+	tmp := entryPath + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, entryPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Remove deletes the file (unless it was moved away) and its entry, then releases the lock
+func (f *File) Remove() {
+	removeFiles(f.Name())
+	f.lock.Close()
+}
+
+// Sweep deals with buffered files left behind by a process that crashed
+// Files still locked by a running process are skipped. A complete file with its entry is passed
+// to recoverFile (e.g. to move it into the shutdown spool); it is deleted when recoverFile is nil or fails,
+// and so is every incomplete file and every sealed file whose key only lived in memory.
+func (d *Dir) Sweep(recoverFile func(entry spool.Entry, dataPath string) error) (recovered, removed int) {
+	paths, err := filepath.Glob(filepath.Join(d.Path(), Prefix+"*"))
+	if err != nil {
+		log.Printf("upload_buffer_sweep_failed dir=%s error=%v", d.Path(), err)
+		return 0, 0
+	}
+
+	for _, dataPath := range paths {
+		if strings.Contains(filepath.Base(dataPath), entrySuffix) {
+			continue // entries go with their data file
+		}
+		info, err := os.Stat(dataPath)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		lock, err := lockFile(dataPath)
+		if err != nil {
+			continue // in use by a running process
+		}
+
+		// Sealed files count by plaintext size and can only be recovered with the master key
+		size, sealed, err := spoolcrypt.PlaintextSize(dataPath)
+		entry, ok := readEntry(dataPath + entrySuffix)
+		complete := ok && err == nil && entry.Size == size
+		recoverable := complete && (!sealed || d.Keys().Durable())
+		if recoverable && recoverFile != nil {
+			err := recoverFile(entry, dataPath)
+			if err == nil {
+				log.Printf("upload_buffer_recovered file=%s event=%s bytes=%d", entry.Filename, entry.EventID, entry.Size)
+				removeFiles(dataPath)
+				lock.Close()
+				recovered++
+				continue
+			}
+			log.Printf("upload_buffer_recover_failed path=%s error=%v", dataPath, err)
+		}
+		log.Printf("upload_buffer_orphan_removed path=%s bytes=%d complete=%t sealed=%t", dataPath, info.Size(), complete, sealed)
+		removeFiles(dataPath)
+		lock.Close()
+		removed++
+	}
+
+	// Entries whose data file is gone (crash between the two removes)
+	for _, pattern := range []string{Prefix + "*" + entrySuffix, Prefix + "*" + entrySuffix + ".tmp"} {
+		entries, _ := filepath.Glob(filepath.Join(d.Path(), pattern))
+		for _, entryPath := range entries {
+			dataPath := strings.TrimSuffix(strings.TrimSuffix(entryPath, ".tmp"), entrySuffix)
+			if _, err := os.Stat(dataPath); errors.Is(err, os.ErrNotExist) {
+				os.Remove(entryPath)
+			}
+		}
+	}
+	return recovered, removed
+}
+
+// removeFiles deletes a buffered file and its entry; missing files are ignored
+func removeFiles(dataPath string) {
+	os.Remove(dataPath)
+	os.Remove(dataPath + entrySuffix)
+	os.Remove(dataPath + entrySuffix + ".tmp")
+}
+
+func readEntry(path string) (spool.Entry, bool) {
+	var entry spool.Entry
+	raw, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(raw, &entry) != nil {
+		return spool.Entry{}, false
+	}
+	return entry, true
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package uploadbuf
+
+import (
+	"errors"
+	"os"
+)
+
+func statfs(path string) (free, total int64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
+
+// lockFile only opens path: without flock, files are not protected from another process's sweep
+// That only matters across a graceful restart, which needs Unix anyway (see internal/handoff).
+func lockFile(path string) (*os.File, error) {
+	return os.Open(path)
+}
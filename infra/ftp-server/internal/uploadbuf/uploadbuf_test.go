@@ -0,0 +1,185 @@
+package uploadbuf
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ftpserver "github.com/fclairamb/ftpserverlib"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/spool"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/spoolcrypt"
+)
+
+// orphan leaves a buffered file as a crashed process would, with an entry when entrySize >= 0
+func orphan(t *testing.T, dir, name string, data []byte, entrySize int64) string {
+	t.Helper()
+	dataPath := filepath.Join(dir, Prefix+name)
+	if err := os.WriteFile(dataPath, data, 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if entrySize >= 0 {
+		raw, _ := json.Marshal(spool.Entry{Filename: "/" + name + ".jpg", EventID: "evt_1", Size: entrySize})
+		if err := os.WriteFile(dataPath+entrySuffix, raw, 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	return dataPath
+}
+
+func names(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Name())
+	}
+	return out
+}
+
+func TestDir_CreateChecksFreeSpace(t *testing.T) {
+	d, err := New(t.TempDir(), math.MaxInt64, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	_, err = d.Create()
+	var coder ftpserver.ReplyCoder
+	if !errors.Is(err, ErrInsufficientSpace) || !errors.As(err, &coder) || coder.ReplyCode() != 452 {
+		t.Fatalf("Create below the floor = %v, want ErrInsufficientSpace (452)", err)
+	}
+	if left := names(t, d.Path()); len(left) != 0 {
+		t.Errorf("Refused upload left files behind: %v", left)
+	}
+
+	if _, err := New(t.TempDir(), -1, nil); err == nil {
+		t.Error("New should reject a negative floor")
+	}
+}
+
+func TestFile_CompleteAndRemove(t *testing.T) {
+	d, err := New(t.TempDir(), 1, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	f, err := d.Create()
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("photo"))
+	f.Close()
+	if err := f.Complete(spool.Entry{Filename: "/a.jpg", Size: 5}); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if d.Buffered() < 5 {
+		t.Errorf("Buffered = %d, want the file counted", d.Buffered())
+	}
+	if free, total, err := d.Free(); err != nil || free <= 0 || total < free {
+		t.Errorf("Free = %d of %d, %v", free, total, err)
+	}
+
+	f.Remove()
+	if left := names(t, d.Path()); len(left) != 0 {
+		t.Errorf("Remove left files behind: %v", left)
+	}
+}
+
+func TestDir_SweepRecoversCompleteOrphans(t *testing.T) {
+	d, err := New(t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	live, err := d.Create()
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer live.Remove()
+	live.Close() // closed for writing but still locked, as during the R2 PUT
+
+	orphan(t, d.Path(), "complete", []byte("whole photo"), int64(len("whole photo")))
+	orphan(t, d.Path(), "partial", []byte("half"), 100)
+	orphan(t, d.Path(), "noentry", []byte("unknown"), -1)
+	os.WriteFile(filepath.Join(d.Path(), Prefix+"gone"+entrySuffix), []byte("{}"), 0o600)
+	os.WriteFile(filepath.Join(d.Path(), "unrelated.txt"), []byte("keep"), 0o600)
+
+	target := t.TempDir()
+	var got []spool.Entry
+	recovered, removed := d.Sweep(func(entry spool.Entry, dataPath string) error {
+		got = append(got, entry)
+		return os.Rename(dataPath, filepath.Join(target, "recovered"))
+	})
+
+	if recovered != 1 || removed != 2 {
+		t.Errorf("Sweep = %d recovered, %d removed; want 1 and 2", recovered, removed)
+	}
+	if len(got) != 1 || got[0].Filename != "/complete.jpg" {
+		t.Errorf("Recovered entries = %+v", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(target, "recovered")); string(data) != "whole photo" {
+		t.Errorf("Recovered data = %q", data)
+	}
+	left := names(t, d.Path())
+	want := []string{filepath.Base(live.Name()), "unrelated.txt"}
+	if len(left) != len(want) || left[0] != want[0] || left[1] != want[1] {
+		t.Errorf("Left after sweep = %v, want %v", left, want)
+	}
+}
+
+func TestDir_SweepWithoutRecoveryDeletes(t *testing.T) {
+	d, err := New(t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	orphan(t, d.Path(), "complete", []byte("photo"), 5)
+
+	if recovered, removed := d.Sweep(nil); recovered != 0 || removed != 1 {
+		t.Errorf("Sweep = %d recovered, %d removed; want 0 and 1", recovered, removed)
+	}
+	if left := names(t, d.Path()); len(left) != 0 {
+		t.Errorf("Left after sweep: %v", left)
+	}
+}
+
+func TestDir_SweepSealedFilesNeedTheMasterKey(t *testing.T) {
+	master := make([]byte, 32)
+	for name, keys := range map[string]*spoolcrypt.Keys{
+		"memory": spoolcrypt.New(nil),
+		"master": spoolcrypt.New(master),
+	} {
+		d, err := New(t.TempDir(), 0, keys)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		f, err := d.Create()
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		f.Write([]byte("sealed photo"))
+		f.Close()
+		f.Complete(spool.Entry{Filename: "/a.jpg", Size: int64(len("sealed photo"))})
+		f.lock.Close() // the process crashed
+
+		var recovered []byte
+		d.Sweep(func(entry spool.Entry, dataPath string) error {
+			r, err := keys.Open(dataPath, nil)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			recovered, err = io.ReadAll(r)
+			return err
+		})
+		if want := keys.Durable(); (string(recovered) == "sealed photo") != want {
+			t.Errorf("%s: recovered %q, want recovery only with a master key", name, recovered)
+		}
+		if left := names(t, d.Path()); len(left) != 0 {
+			t.Errorf("%s: left after sweep: %v", name, left)
+		}
+	}
+}
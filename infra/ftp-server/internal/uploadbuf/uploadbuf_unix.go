@@ -0,0 +1,30 @@
+//go:build unix
+
+package uploadbuf
+
+import (
+	"os"
+	"syscall"
+)
+
+func statfs(path string) (free, total int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+}
+
+// lockFile takes an exclusive lock on path through its own handle, failing at once if it is held
+// The lock lasts until the handle is closed or the process exits.
+func lockFile(path string) (*os.File, error) {
+	lock, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
@@ -0,0 +1,244 @@
+package webdavserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/client"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/mime"
+	"github.com/spf13/afero"
+	"golang.org/x/net/webdav"
+)
+
+var errIncompleteUpload = errors.New("request body ended before Content-Length - file discarded")
+
+// fileSystem maps WebDAV onto the session's upload-only ClientDriver
+// The tree only holds what this session created: MKCOL folders and PUT files.
+type fileSystem struct {
+	session *session
+}
+
+// Mkdir records the folder so clients can navigate into it (no-op on the driver, like FTP MKD)
+func (fs *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.session.driver.Mkdir(name, perm); err != nil {
+		return err
+	}
+	fs.session.addDir(name)
+	return nil
+}
+
+// OpenFile starts an upload for writes; reads only serve listings and metadata
+func (fs *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		// Finder writes ._ AppleDouble and .DS_Store files alongside every copy; accept and drop them
+		if isHidden(name) {
+			return &uploadFile{session: fs.session, name: name, body: bodyFromContext(ctx), discard: true}, nil
+		}
+		if _, err := mime.FromFilename(name); err != nil {
+			return nil, err
+		}
+		return &uploadFile{session: fs.session, name: name, body: bodyFromContext(ctx)}, nil
+	}
+
+	info, err := fs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dirFile{info: info, entries: fs.session.list(name)}, nil
+	}
+	return &statFile{info: info}, nil
+}
+
+// RemoveAll is accepted like FTP DELE; the photo stays uploaded, only the session's listing changes
+func (fs *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	if err := fs.session.driver.RemoveAll(name); err != nil {
+		return err
+	}
+	fs.session.remove(name)
+	return nil
+}
+
+// Rename is accepted like FTP RNFR/RNTO; the photo keeps its uploaded name, the listing follows the client
+func (fs *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if err := fs.session.driver.Rename(oldName, newName); err != nil {
+		return err
+	}
+	return fs.session.rename(oldName, newName)
+}
+
+// Stat reports only the root and what this session created
+// Unknown names must not exist, or clients prompt to "replace" every file they copy.
+func (fs *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if info, ok := fs.session.stat(name); ok {
+		return info, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// isHidden reports dot-files (macOS metadata, editor temp files)
+func isHidden(name string) bool {
+	return strings.HasPrefix(path.Base(name), ".")
+}
+
+// uploadFile streams a PUT body into the ClientDriver upload
+// The transfer is opened on the first byte: Finder creates every file with an empty PUT first,
+// and those placeholders must not reach the API.
+type uploadFile struct {
+	session *session
+	name    string
+	body    *trackedBody
+	discard bool
+
+	file afero.File
+	size int64
+	err  error
+}
+
+func (f *uploadFile) Write(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	if f.discard {
+		f.size += int64(len(p))
+		return len(p), nil
+	}
+
+	if f.file == nil {
+		f.file, f.err = f.session.driver.OpenFile(f.name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if f.err != nil {
+			return 0, f.err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		f.err = err
+	}
+	return n, err
+}
+
+// Close finishes the upload, discarding it if the body was cut short
+func (f *uploadFile) Close() error {
+	if f.err == nil && f.body != nil && !f.body.complete() {
+		f.err = errIncompleteUpload
+	}
+
+	if f.file == nil {
+		if f.err == nil {
+			f.session.addFile(f.name, f.size)
+		}
+		return f.err
+	}
+
+	if f.err != nil {
+		if te, ok := f.file.(interface{ TransferError(error) }); ok {
+			te.TransferError(f.err)
+		}
+		f.file.Close()
+		return f.err
+	}
+
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.session.addFile(f.name, f.size)
+	return nil
+}
+
+func (f *uploadFile) Read(p []byte) (int, error) { return 0, client.ErrDownloadNotAllowed }
+func (f *uploadFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, client.ErrDownloadNotAllowed
+}
+func (f *uploadFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, client.ErrReaddirNotAllowed
+}
+func (f *uploadFile) Stat() (os.FileInfo, error) {
+	return &fileInfo{name: path.Base(f.name), size: f.size, modTime: time.Now()}, nil
+}
+
+// statFile is an uploaded file opened for reading: metadata only, downloads are denied
+type statFile struct {
+	info os.FileInfo
+}
+
+func (f *statFile) Close() error                { return nil }
+func (f *statFile) Read(p []byte) (int, error)  { return 0, client.ErrDownloadNotAllowed }
+func (f *statFile) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+func (f *statFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, client.ErrDownloadNotAllowed
+}
+func (f *statFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, client.ErrReaddirNotAllowed
+}
+func (f *statFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+// dirFile lists a folder of the session's tree
+type dirFile struct {
+	info    os.FileInfo
+	entries []os.FileInfo
+	offset  int
+}
+
+func (f *dirFile) Close() error                { return nil }
+func (f *dirFile) Read(p []byte) (int, error)  { return 0, client.ErrDownloadNotAllowed }
+func (f *dirFile) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+func (f *dirFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, client.ErrDownloadNotAllowed
+}
+func (f *dirFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+// Readdir follows os.File semantics
+func (f *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	remaining := f.entries[f.offset:]
+	if count <= 0 {
+		f.offset = len(f.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	f.offset += count
+	return remaining[:count], nil
+}
+
+// fileInfo is an entry of the session's tree
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *fileInfo) Name() string { return fi.name }
+func (fi *fileInfo) Size() int64  { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// sortedEntries orders a listing folders-first, then by name
+func sortedEntries(entries []os.FileInfo) []os.FileInfo {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
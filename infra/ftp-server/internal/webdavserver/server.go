@@ -0,0 +1,459 @@
+package webdavserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/apiclient"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/client"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/clientmgr"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/handoff"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/httpauth"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/mime"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+	"golang.org/x/net/webdav"
+)
+
+// sessionIdleTimeout ends a mounted drive's session after this long without requests
+// Mounted drives poll every few seconds, so only unmounted clients go idle this long.
+const sessionIdleTimeout = 30 * time.Minute
+
+// sweepInterval is how often idle sessions are ended
+const sweepInterval = time.Minute
+
+// Server is the write-only WebDAV frontend
+// Each event + client host gets a session with its own ClientDriver, like one FTP login.
+type Server struct {
+	config    *config.Config
+	clientMgr *clientmgr.Manager
+	apiClient apiclient.APIClient
+	auth      *httpauth.Authenticator
+	tlsConfig *tls.Config // nil serves plain HTTP (e.g. behind a TLS-terminating proxy)
+
+	httpServer *http.Server
+	stop       chan struct{}
+	stopOnce   sync.Once
+
+	// socket is the TCP listener under TLS, passed on by graceful restarts
+	socketMu sync.Mutex
+	socket   net.Listener
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// New creates the WebDAV server
+func New(cfg *config.Config, clientMgr *clientmgr.Manager, apiClient apiclient.APIClient, tlsConfig *tls.Config) *Server {
+	s := &Server{
+		config:    cfg,
+		clientMgr: clientMgr,
+		apiClient: apiClient,
+		auth:      httpauth.New(cfg, apiClient),
+		tlsConfig: tlsConfig,
+		stop:      make(chan struct{}),
+		sessions:  make(map[string]*session),
+	}
+	s.httpServer = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, remoteAddrKey{}, conn.RemoteAddr())
+		},
+	}
+	return s
+}
+
+// ListenAndServe serves WebDAV requests until Stop is called
+func (s *Server) ListenAndServe() error {
+	listener, err := handoff.Listen("webdav", s.config.WebDAVListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.WebDAVListenAddress, err)
+	}
+	s.socketMu.Lock()
+	s.socket = listener
+	s.socketMu.Unlock()
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	log.Printf("webdav_listening addr=%s tls=%t", listener.Addr(), s.tlsConfig != nil)
+
+	go s.sweepIdle()
+
+	if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ListenerFile returns a duplicate of the listening socket for a graceful restart
+func (s *Server) ListenerFile() (string, *os.File, error) {
+	s.socketMu.Lock()
+	socket := s.socket
+	s.socketMu.Unlock()
+	if socket == nil {
+		return "webdav", nil, errors.New("listener not started")
+	}
+	file, err := handoff.File(socket)
+	return "webdav", file, err
+}
+
+// Stop closes the listener, lets requests in progress (PUTs included) finish until ctx is done,
+// then ends all sessions
+func (s *Server) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.httpServer.Close()
+	}
+
+	s.mu.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		s.endSession(sess, "server stopping")
+	}
+	return err
+}
+
+// ServeHTTP authenticates the request and hands it to the session's WebDAV handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth, err := s.auth.Authenticate(r, "webdav")
+	if err != nil {
+		httpauth.Challenge(w)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
+		http.Error(w, client.ErrDownloadNotAllowed.Error(), http.StatusForbidden)
+		return
+
+	case http.MethodPut:
+		// Reject unsupported types before the body is sent (webdav.Handler would answer 404)
+		if !isHidden(r.URL.Path) {
+			if _, err := mime.FromFilename(r.URL.Path); err != nil {
+				log.Printf("webdav_upload_rejected client=%s file=%s error=%v", r.RemoteAddr, r.URL.Path, err)
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		body := &trackedBody{ReadCloser: r.Body, contentLength: r.ContentLength}
+		r.Body = body
+		r = r.WithContext(context.WithValue(r.Context(), bodyKey{}, body))
+	}
+
+	sess := s.sessionFor(auth, remoteAddrFromRequest(r))
+	sess.begin()
+	defer sess.end()
+
+	handler := &webdav.Handler{
+		FileSystem: &fileSystem{session: sess},
+		LockSystem: sess.locks,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Printf("webdav_request_failed id=%d method=%s path=%s error=%v", sess.clientID, r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// sessionFor returns the session of this event and client host, starting one if needed
+func (s *Server) sessionFor(auth *apiclient.AuthResponse, addr net.Addr) *session {
+	remoteAddr := addr.String()
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	key := auth.EventID + "|" + host
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[key]; ok {
+		return sess
+	}
+
+	sess := &session{
+		key:        key,
+		clientIP:   remoteAddr,
+		remoteAddr: addr,
+		server:     s,
+		locks:      webdav.NewMemLS(),
+		entries:    make(map[string]*fileInfo),
+		lastSeen:   time.Now(),
+	}
+	sess.clientID = s.clientMgr.RegisterClient(sess)
+	s.clientMgr.SetLogin(sess.clientID, "webdav", auth.EventID, auth.Token)
+	sess.driver = client.NewClientDriver(auth, remoteAddr, sess.clientID, s.clientMgr, s.apiClient, s.config)
+	s.sessions[key] = sess
+
+	observability.EmitLog(context.Background(), "info", "client_connected", map[string]any{
+		"client_ip":  remoteAddr,
+		"session_id": sess.clientID,
+		"protocol":   "webdav",
+		"event_id":   auth.EventID,
+	})
+	return sess
+}
+
+// endSession removes the session; its next request starts a new one with fresh credentials
+func (s *Server) endSession(sess *session, reason string) {
+	s.mu.Lock()
+	current, ok := s.sessions[sess.key]
+	if !ok || current != sess {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.sessions, sess.key)
+	s.mu.Unlock()
+
+	s.clientMgr.UnregisterClient(sess.clientID)
+	observability.EmitLog(context.Background(), "info", "client_disconnected", map[string]any{
+		"client_ip":  sess.clientIP,
+		"session_id": sess.clientID,
+		"protocol":   "webdav",
+		"reason":     reason,
+	})
+}
+
+// sweepIdle ends sessions with no requests for sessionIdleTimeout
+func (s *Server) sweepIdle() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			var idle []*session
+			for _, sess := range s.sessions {
+				if sess.idleSince(now) > sessionIdleTimeout {
+					idle = append(idle, sess)
+				}
+			}
+			s.mu.Unlock()
+
+			for _, sess := range idle {
+				s.endSession(sess, "idle")
+			}
+		}
+	}
+}
+
+// session is one mounted drive: a ClientDriver plus the tree of what it created
+type session struct {
+	key        string
+	clientIP   string
+	remoteAddr net.Addr
+	clientID   uint32
+	driver     *client.ClientDriver
+	server     *Server
+	locks      webdav.LockSystem
+
+	mu       sync.Mutex
+	entries  map[string]*fileInfo // clean path -> created folder or uploaded file
+	active   int                  // requests in progress
+	lastSeen time.Time
+}
+
+// RemoteAddr implements clientmgr.Session
+func (sess *session) RemoteAddr() net.Addr {
+	return sess.remoteAddr
+}
+
+// Close implements clientmgr.Session (e.g. on expired credentials)
+func (sess *session) Close() error {
+	sess.server.endSession(sess, "closed")
+	return nil
+}
+
+func (sess *session) begin() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.active++
+	sess.lastSeen = time.Now()
+}
+
+func (sess *session) end() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.active--
+	sess.lastSeen = time.Now()
+}
+
+// idleSince returns how long the session has had no requests (0 while one is in progress)
+func (sess *session) idleSince(now time.Time) time.Duration {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.active > 0 {
+		return 0
+	}
+	return now.Sub(sess.lastSeen)
+}
+
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// addDir records a folder and its parents
+func (sess *session) addDir(name string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.addParentsLocked(cleanPath(name) + "/x")
+}
+
+// addFile records an uploaded file (and its folders) for listings
+func (sess *session) addFile(name string, size int64) {
+	name = cleanPath(name)
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.addParentsLocked(name)
+	sess.entries[name] = &fileInfo{name: path.Base(name), size: size, modTime: time.Now()}
+}
+
+func (sess *session) addParentsLocked(name string) {
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if _, ok := sess.entries[dir]; ok {
+			return
+		}
+		sess.entries[dir] = &fileInfo{name: path.Base(dir), modTime: time.Now(), isDir: true}
+	}
+}
+
+func (sess *session) stat(name string) (os.FileInfo, bool) {
+	name = cleanPath(name)
+	if name == "/" {
+		return &fileInfo{name: "/", modTime: time.Now(), isDir: true}, true
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	info, ok := sess.entries[name]
+	return info, ok
+}
+
+// list returns the direct children of a folder
+func (sess *session) list(dir string) []os.FileInfo {
+	dir = cleanPath(dir)
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	var entries []os.FileInfo
+	for name, info := range sess.entries {
+		if path.Dir(name) == dir {
+			entries = append(entries, info)
+		}
+	}
+	return sortedEntries(entries)
+}
+
+// remove drops a path and everything below it from the listing
+func (sess *session) remove(name string) {
+	name = cleanPath(name)
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	for p := range sess.entries {
+		if p == name || strings.HasPrefix(p, name+"/") {
+			delete(sess.entries, p)
+		}
+	}
+}
+
+// rename moves a path and everything below it in the listing
+func (sess *session) rename(oldName, newName string) error {
+	oldName, newName = cleanPath(oldName), cleanPath(newName)
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if _, ok := sess.entries[oldName]; !ok {
+		return os.ErrNotExist
+	}
+	moved := make(map[string]*fileInfo)
+	for p, info := range sess.entries {
+		if p == oldName || strings.HasPrefix(p, oldName+"/") {
+			moved[newName+strings.TrimPrefix(p, oldName)] = info
+			delete(sess.entries, p)
+		}
+	}
+	for p, info := range moved {
+		renamed := *info
+		renamed.name = path.Base(p)
+		sess.entries[p] = &renamed
+	}
+	sess.addParentsLocked(newName)
+	return nil
+}
+
+// trackedBody records whether a PUT body was read to the end
+type trackedBody struct {
+	io.ReadCloser
+	contentLength int64 // -1 when unknown (chunked)
+	read          int64
+	err           error
+}
+
+type bodyKey struct{}
+
+// remoteAddrKey holds the net.Addr of the connection a request arrived on
+type remoteAddrKey struct{}
+
+// remoteAddrFromRequest returns the connection's address, or the one net/http reported when
+// the handler is served without ListenAndServe
+func remoteAddrFromRequest(r *http.Request) net.Addr {
+	if addr, ok := r.Context().Value(remoteAddrKey{}).(net.Addr); ok {
+		return addr
+	}
+	return httpAddr(r.RemoteAddr)
+}
+
+// httpAddr is the remote address of an HTTP client as reported by net/http
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+func bodyFromContext(ctx context.Context) *trackedBody {
+	body, _ := ctx.Value(bodyKey{}).(*trackedBody)
+	return body
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		b.err = err
+	}
+	return n, err
+}
+
+// complete reports whether the whole body arrived
+func (b *trackedBody) complete() bool {
+	if b.err != nil {
+		return false
+	}
+	return b.contentLength < 0 || b.read == b.contentLength
+}
@@ -0,0 +1,336 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/observability"
+)
+
+// Events that can be delivered (WEBHOOK_EVENTS selects a subset)
+const (
+	EventUploadStarted      = "upload_started"
+	EventUploadCompleted    = "upload_completed"
+	EventUploadFailed       = "upload_failed"
+	EventClientConnected    = "client_connected"
+	EventClientDisconnected = "client_disconnected"
+	EventAuthFailed         = "auth_failed"
+	// EventPolicyTriggered is sent when a client manager policy asks for a webhook (always delivered)
+	EventPolicyTriggered = "policy_triggered"
+)
+
+var allEvents = []string{
+	EventUploadStarted, EventUploadCompleted, EventUploadFailed,
+	EventClientConnected, EventClientDisconnected, EventAuthFailed,
+}
+
+// Headers sent with every delivery
+// The signature is hex HMAC-SHA256 of "<timestamp>.<body>" with WEBHOOK_SECRET, so receivers
+// can reject replays by checking the timestamp.
+const (
+	HeaderEvent     = "X-SabaiPics-Event"
+	HeaderTimestamp = "X-SabaiPics-Timestamp"
+	HeaderSignature = "X-SabaiPics-Signature"
+	HeaderDelivery  = "X-SabaiPics-Delivery"
+)
+
+const (
+	defaultQueueSize   = 1000
+	defaultMaxAttempts = 5
+	requestTimeout     = 10 * time.Second
+)
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	ID        string         `json:"id"`
+	Event     string         `json:"event"`
+	Timestamp time.Time      `json:"timestamp"`
+	Data      map[string]any `json:"data"`
+}
+
+type delivery struct {
+	sink    string
+	event   string
+	id      string
+	body    []byte
+	attempt int
+}
+
+// Dispatcher delivers events to the configured sinks from a bounded queue
+// Publish never blocks; when the queue is full the delivery is dropped and counted.
+// Sinks are global: each one receives the selected events of every event_id.
+type Dispatcher struct {
+	sinks       []string
+	secret      []byte
+	events      map[string]bool
+	maxAttempts int
+	backoff     func(attempt int) time.Duration
+	client      *http.Client
+
+	queue chan delivery
+	stop  chan struct{}
+	wg    sync.WaitGroup
+	seq   uint64
+	seqMu sync.Mutex
+}
+
+// New creates a Dispatcher from WEBHOOK_* settings, or returns nil when no sink is configured
+func New(cfg *config.Config) (*Dispatcher, error) {
+	var sinks []string
+	for _, raw := range strings.Split(cfg.WebhookURLs, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid WEBHOOK_URLS entry %q: must be an http(s) URL", raw)
+		}
+		sinks = append(sinks, raw)
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	if cfg.WebhookSecret == "" {
+		return nil, fmt.Errorf("WEBHOOK_SECRET is required when WEBHOOK_URLS is set (deliveries are signed)")
+	}
+
+	events := make(map[string]bool)
+	selected := allEvents
+	if strings.TrimSpace(cfg.WebhookEvents) != "" {
+		selected = strings.Split(cfg.WebhookEvents, ",")
+	}
+	for _, event := range selected {
+		event = strings.TrimSpace(event)
+		if !known(event) {
+			return nil, fmt.Errorf("unknown WEBHOOK_EVENTS entry %q (valid: %s)", event, strings.Join(allEvents, ", "))
+		}
+		events[event] = true
+	}
+	events[EventPolicyTriggered] = true
+
+	queueSize := cfg.WebhookQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	maxAttempts := cfg.WebhookMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	return &Dispatcher{
+		sinks:       sinks,
+		secret:      []byte(cfg.WebhookSecret),
+		events:      events,
+		maxAttempts: maxAttempts,
+		backoff:     func(attempt int) time.Duration { return time.Duration(1<<(attempt-1)) * time.Second },
+		client:      &http.Client{Timeout: requestTimeout},
+		queue:       make(chan delivery, queueSize),
+		stop:        make(chan struct{}),
+	}, nil
+}
+
+func known(event string) bool {
+	for _, e := range allEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sinks returns the configured webhook URLs
+func (d *Dispatcher) Sinks() []string {
+	return d.sinks
+}
+
+// Start runs the delivery workers (one per sink, so a slow receiver doesn't stall the others entirely)
+func (d *Dispatcher) Start() {
+	workers := len(d.sinks)
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+}
+
+// Stop delivers what is queued until ctx is done, then stops the workers
+// Deliveries still waiting for a retry are abandoned.
+func (d *Dispatcher) Stop(ctx context.Context) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for len(d.queue) > 0 && ctx.Err() == nil {
+		<-ticker.C
+	}
+	close(d.stop)
+	d.wg.Wait()
+	if n := len(d.queue); n > 0 {
+		log.Printf("webhook_queue_abandoned deliveries=%d", n)
+	}
+}
+
+// HandleLog maps an EmitLog line to a webhook event (set with observability.SetLogHook)
+// Upload failures are logged as upload_completed with status=error or as upload_aborted.
+func (d *Dispatcher) HandleLog(event string, fields map[string]any) {
+	switch event {
+	case "upload_completed":
+		if fields["status"] == "error" {
+			event = EventUploadFailed
+		}
+	case "upload_aborted":
+		event = EventUploadFailed
+	}
+	d.Publish(event, fields)
+}
+
+// Publish queues event for every sink when it is selected by WEBHOOK_EVENTS
+func (d *Dispatcher) Publish(event string, data map[string]any) {
+	if !d.events[event] {
+		return
+	}
+
+	id := d.nextID()
+	body, err := json.Marshal(Payload{ID: id, Event: event, Timestamp: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("webhook_marshal_failed event=%s error=%v", event, err)
+		return
+	}
+
+	for _, sink := range d.sinks {
+		select {
+		case d.queue <- delivery{sink: sink, event: event, id: id, body: body, attempt: 1}:
+		default:
+			observability.RecordWebhookDelivery("dropped")
+			log.Printf("webhook_dropped reason=queue_full event=%s sink=%s", event, redact(sink))
+		}
+	}
+}
+
+func (d *Dispatcher) nextID() string {
+	d.seqMu.Lock()
+	defer d.seqMu.Unlock()
+	d.seq++
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), d.seq)
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case item := <-d.queue:
+			d.deliver(item)
+		}
+	}
+}
+
+// deliver sends one attempt; failures are requeued after a backoff until maxAttempts
+func (d *Dispatcher) deliver(item delivery) {
+	err := d.send(item)
+	if err == nil {
+		observability.RecordWebhookDelivery("ok")
+		return
+	}
+
+	retryable := true
+	if statusErr, ok := err.(*statusError); ok {
+		retryable = statusErr.retryable()
+	}
+	if !retryable || item.attempt >= d.maxAttempts {
+		observability.RecordWebhookDelivery("failed")
+		log.Printf("webhook_failed event=%s sink=%s attempts=%d error=%v", item.event, redact(item.sink), item.attempt, err)
+		return
+	}
+
+	// Wait out the backoff without holding up the sink's other deliveries
+	delay := d.backoff(item.attempt)
+	item.attempt++
+	time.AfterFunc(delay, func() {
+		select {
+		case d.queue <- item:
+		case <-d.stop:
+		default:
+			observability.RecordWebhookDelivery("dropped")
+			log.Printf("webhook_dropped reason=queue_full event=%s sink=%s attempt=%d", item.event, redact(item.sink), item.attempt)
+		}
+	})
+}
+
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("receiver returned %d", e.code)
+}
+
+// retryable: server errors and rate limiting; other 4xx mean the receiver rejected the payload
+func (e *statusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests || e.code == http.StatusRequestTimeout
+}
+
+func (d *Dispatcher) send(item delivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, item.sink, bytes.NewReader(item.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SabaiPics-FTP-Webhook/1")
+	req.Header.Set(HeaderEvent, item.event)
+	req.Header.Set(HeaderDelivery, item.id)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(d.secret, timestamp, item.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		// url.Error repeats the sink URL, which may carry a token
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{code: resp.StatusCode}
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>"
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header value ("sha256=<hex>") in constant time
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	expected := "sha256=" + Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// redact keeps the scheme and host of a sink URL for logs (paths often carry tokens)
+func redact(sink string) string {
+	u, err := url.Parse(sink)
+	if err != nil {
+		return "invalid"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,210 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sabaipics/sabaipics/apps/ftp-server/internal/config"
+)
+
+const testSecret = "whsec_test"
+
+// receiver records verified deliveries; status decides each reply (nil = 200)
+type receiver struct {
+	mu         sync.Mutex
+	deliveries []Payload
+	attempts   atomic.Int32
+	badSig     atomic.Int32
+	status     func(attempt int32) int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	attempt := rc.attempts.Add(1)
+	body, _ := io.ReadAll(r.Body)
+	if !Verify([]byte(testSecret), r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)) {
+		rc.badSig.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if rc.status != nil {
+		if code := rc.status(attempt); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+	}
+	var payload Payload
+	json.Unmarshal(body, &payload)
+	if payload.Event != r.Header.Get(HeaderEvent) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.mu.Lock()
+	rc.deliveries = append(rc.deliveries, payload)
+	rc.mu.Unlock()
+}
+
+func (rc *receiver) received() []Payload {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]Payload(nil), rc.deliveries...)
+}
+
+func newDispatcher(t *testing.T, url string, configure func(cfg *config.Config)) *Dispatcher {
+	t.Helper()
+	cfg := &config.Config{WebhookURLs: url, WebhookSecret: testSecret}
+	if configure != nil {
+		configure(cfg)
+	}
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	d.backoff = func(int) time.Duration { return time.Millisecond }
+	return d
+}
+
+func waitFor(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcher_DeliversSignedEvents(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	d := newDispatcher(t, srv.URL+"/hooks/ftp", nil)
+	d.Start()
+	defer d.Stop(context.Background())
+
+	d.HandleLog("upload_completed", map[string]any{"status": "ok", "file": "a.jpg", "event_id": "evt_1"})
+	d.HandleLog("upload_completed", map[string]any{"status": "error", "file": "b.jpg", "event_id": "evt_1"})
+	d.HandleLog("upload_r2_ok", map[string]any{"file": "a.jpg"}) // not a webhook event
+
+	waitFor(t, "two deliveries", func() bool { return len(rc.received()) == 2 })
+	got := map[string]string{}
+	for _, p := range rc.received() {
+		got[p.Event] = p.Data["file"].(string)
+		if p.ID == "" || p.Timestamp.IsZero() || p.Data["event_id"] != "evt_1" {
+			t.Errorf("Incomplete payload: %+v", p)
+		}
+	}
+	if got[EventUploadCompleted] != "a.jpg" || got[EventUploadFailed] != "b.jpg" {
+		t.Errorf("Deliveries = %v, want a.jpg completed and b.jpg failed", got)
+	}
+	if rc.badSig.Load() != 0 {
+		t.Errorf("%d deliveries had a bad signature", rc.badSig.Load())
+	}
+}
+
+func TestDispatcher_RetriesServerErrors(t *testing.T) {
+	rc := &receiver{status: func(attempt int32) int {
+		if attempt < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	d := newDispatcher(t, srv.URL, nil)
+	d.Start()
+	defer d.Stop(context.Background())
+
+	d.Publish(EventClientConnected, map[string]any{"client_ip": "192.0.2.1:40000"})
+	waitFor(t, "delivery after retries", func() bool { return len(rc.received()) == 1 })
+	if n := rc.attempts.Load(); n != 3 {
+		t.Errorf("Attempts = %d, want 3", n)
+	}
+}
+
+func TestDispatcher_GivesUpOnClientErrorsAndMaxAttempts(t *testing.T) {
+	rejected := &receiver{status: func(int32) int { return http.StatusBadRequest }}
+	failing := &receiver{status: func(int32) int { return http.StatusInternalServerError }}
+	rejectedSrv := httptest.NewServer(rejected)
+	defer rejectedSrv.Close()
+	failingSrv := httptest.NewServer(failing)
+	defer failingSrv.Close()
+
+	d := newDispatcher(t, rejectedSrv.URL+","+failingSrv.URL, func(cfg *config.Config) {
+		cfg.WebhookMaxAttempts = 4
+	})
+	d.Start()
+	defer d.Stop(context.Background())
+
+	d.Publish(EventAuthFailed, map[string]any{"user": "cam"})
+	waitFor(t, "4 attempts on the failing sink", func() bool { return failing.attempts.Load() == 4 })
+	time.Sleep(20 * time.Millisecond)
+	if n := failing.attempts.Load(); n != 4 {
+		t.Errorf("Failing sink attempts = %d, want WEBHOOK_MAX_ATTEMPTS (4)", n)
+	}
+	if n := rejected.attempts.Load(); n != 1 {
+		t.Errorf("Rejecting sink attempts = %d, want 1 (4xx is not retried)", n)
+	}
+}
+
+func TestDispatcher_QueueIsBounded(t *testing.T) {
+	d := newDispatcher(t, "http://127.0.0.1:1/hook", func(cfg *config.Config) {
+		cfg.WebhookQueueSize = 2
+	})
+
+	// Not started: nothing drains the queue
+	for i := 0; i < 5; i++ {
+		d.Publish(EventUploadStarted, map[string]any{"file": "a.jpg"})
+	}
+	if n := len(d.queue); n != 2 {
+		t.Errorf("Queued deliveries = %d, want the queue size (2)", n)
+	}
+}
+
+func TestDispatcher_EventFilter(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	d := newDispatcher(t, srv.URL, func(cfg *config.Config) {
+		cfg.WebhookEvents = "upload_failed"
+	})
+	d.Start()
+	defer d.Stop(context.Background())
+
+	d.HandleLog("upload_started", map[string]any{"file": "a.jpg"})
+	d.HandleLog("upload_aborted", map[string]any{"file": "a.jpg"})
+	d.Publish(EventPolicyTriggered, map[string]any{"reason": "3 consecutive failed uploads"})
+
+	waitFor(t, "two deliveries", func() bool { return len(rc.received()) == 2 })
+	for _, p := range rc.received() {
+		if p.Event != EventUploadFailed && p.Event != EventPolicyTriggered {
+			t.Errorf("Unselected event delivered: %s", p.Event)
+		}
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	if d, err := New(&config.Config{}); d != nil || err != nil {
+		t.Errorf("No URLs = (%v, %v), want disabled", d, err)
+	}
+	cases := map[string]*config.Config{
+		"missing secret": {WebhookURLs: "https://hooks.example.com/ftp"},
+		"bad scheme":     {WebhookURLs: "ftp://hooks.example.com", WebhookSecret: "s"},
+		"unknown event":  {WebhookURLs: "https://hooks.example.com/ftp", WebhookSecret: "s", WebhookEvents: "upload_done"},
+	}
+	for name, cfg := range cases {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
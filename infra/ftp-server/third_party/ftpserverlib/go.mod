@@ -0,0 +1,11 @@
+module github.com/fclairamb/ftpserverlib
+
+go 1.24.0
+
+require (
+	github.com/fclairamb/go-log v0.6.0
+	github.com/spf13/afero v1.14.0
+	golang.org/x/sys v0.35.0
+)
+
+require golang.org/x/text v0.23.0 // indirect